@@ -0,0 +1,237 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// ToGrayscale returns a grayscale copy of img using the Rec. 601 luma
+// weights. Transparency is kept, so the result is an *image.NRGBA rather
+// than an *image.Gray, or an *image.NRGBA64 for 16-bit images.
+func ToGrayscale(img image.Image) image.Image {
+	bounds := img.Bounds()
+	if is16Bit(img) {
+		newImage := image.NewNRGBA64(bounds)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+				luma := uint16((299*uint64(c.R) + 587*uint64(c.G) + 114*uint64(c.B) + 500) / 1000)
+				newImage.SetNRGBA64(x, y, color.NRGBA64{R: luma, G: luma, B: luma, A: c.A})
+			}
+		}
+		return newImage
+	}
+
+	newImage := image.NewNRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			luma := uint8((299*uint32(c.R) + 587*uint32(c.G) + 114*uint32(c.B) + 500) / 1000)
+			newImage.SetNRGBA(x, y, color.NRGBA{R: luma, G: luma, B: luma, A: c.A})
+		}
+	}
+
+	return newImage
+}
+
+// AdjustBrightnessContrast returns a copy of img with brightness added to
+// every color channel and the contrast around mid-gray scaled by
+// 1+contrast, with channel values running from 0 to 1. Zero leaves either
+// unchanged, so brightness -0.2 and contrast -0.5 give a darker, flatter
+// proof. The results are clamped and the alpha is kept. The copy is an
+// *image.NRGBA, or an *image.NRGBA64 for 16-bit images.
+func AdjustBrightnessContrast(img image.Image, brightness, contrast float64) image.Image {
+	adjust := func(v uint16) uint16 {
+		f := (float64(v)/0xffff-0.5)*(1+contrast) + 0.5 + brightness
+		return clampUint16(int(math.Round(f * 0xffff)))
+	}
+
+	bounds := img.Bounds()
+	var newImage draw.Image = image.NewNRGBA(bounds)
+	if is16Bit(img) {
+		newImage = image.NewNRGBA64(bounds)
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+			newImage.Set(x, y, color.NRGBA64{R: adjust(c.R), G: adjust(c.G), B: adjust(c.B), A: c.A})
+		}
+	}
+
+	return newImage
+}
+
+// TintImage returns a copy of img with the color of every pixel replaced
+// by the color of c and its alpha kept, which recolors a single-color logo
+// or mask. The alpha of c is ignored.
+func TintImage(img image.Image, c color.Color) image.Image {
+	tint := color.NRGBAModel.Convert(c).(color.NRGBA)
+	bounds := img.Bounds()
+	newImage := image.NewNRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			_, _, _, a := img.At(x, y).RGBA()
+			if a == 0 {
+				continue
+			}
+			newImage.SetNRGBA(x, y, color.NRGBA{R: tint.R, G: tint.G, B: tint.B, A: uint8(a >> 8)})
+		}
+	}
+
+	return newImage
+}
+
+// ApplyColorKey returns a copy of img in which the pixels whose red, green
+// and blue each differ from those of key by at most tolerance, out of 255,
+// are made fully transparent. It gives a watermark without an alpha
+// channel, such as a JPEG logo on white, a transparent background. The
+// alpha of key is ignored.
+func ApplyColorKey(img image.Image, key color.Color, tolerance uint8) image.Image {
+	k := color.NRGBAModel.Convert(key).(color.NRGBA)
+	bounds := img.Bounds()
+	newImage := image.NewNRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if channelDistance(c.R, k.R) <= tolerance && channelDistance(c.G, k.G) <= tolerance && channelDistance(c.B, k.B) <= tolerance {
+				continue
+			}
+			newImage.SetNRGBA(x, y, c)
+		}
+	}
+
+	return newImage
+}
+
+// channelDistance returns how far apart the channel values a and b are.
+func channelDistance(a, b uint8) uint8 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+// thresholdAlpha returns a copy of img in which the pixels with an alpha
+// below threshold, out of 255, are made fully transparent so they leave
+// the main image untouched. The other pixels are kept as they are.
+func thresholdAlpha(img image.Image, threshold int) image.Image {
+	bounds := img.Bounds()
+	newImage := image.NewNRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if int(c.A) < threshold {
+				continue
+			}
+			newImage.SetNRGBA(x, y, c)
+		}
+	}
+
+	return newImage
+}
+
+// averageLuminance returns the mean Rec. 601 luma, from 0 to 255, of the
+// part of img inside r, or 0 when they do not overlap.
+func averageLuminance(img image.Image, r image.Rectangle) float64 {
+	r = r.Intersect(img.Bounds())
+	if r.Empty() {
+		return 0
+	}
+
+	var sum uint64
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			sum += uint64(299*uint32(c.R) + 587*uint32(c.G) + 114*uint32(c.B))
+		}
+	}
+
+	return float64(sum) / 1000 / float64(r.Dx()*r.Dy())
+}
+
+// luminanceMask returns a copy of wm with the alpha of each pixel scaled
+// by the Rec. 601 luma of the pixel of dst it lands on with wm placed at
+// (x, y), or by its inverse when dark is set, so the watermark shows on
+// the light or the dark parts of dst only.
+func luminanceMask(dst, wm image.Image, x, y int, dark bool) *image.NRGBA {
+	b := wm.Bounds()
+	masked := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(masked, masked.Rect, wm, b.Min, draw.Src)
+
+	for j := 0; j < b.Dy(); j++ {
+		for i := 0; i < b.Dx(); i++ {
+			at := image.Pt(x+i, y+j)
+			if !at.In(dst.Bounds()) {
+				continue
+			}
+			c := color.NRGBAModel.Convert(dst.At(at.X, at.Y)).(color.NRGBA)
+			lum := 299*uint32(c.R) + 587*uint32(c.G) + 114*uint32(c.B)
+			if dark {
+				lum = 255000 - lum
+			}
+			a := &masked.Pix[masked.PixOffset(i, j)+3]
+			*a = uint8((uint32(*a)*lum + 127500) / 255000)
+		}
+	}
+
+	return masked
+}
+
+// ApplyMask returns a copy of img with the alpha of every pixel multiplied
+// by the gray level of mask, stretched over img, so white parts of the
+// mask keep img as it is, black parts clear it and grays fade it.
+func ApplyMask(img, mask image.Image) *image.NRGBA {
+	return maskAlpha(img, img.Bounds().Min, img.Bounds(), mask)
+}
+
+// maskAlpha returns a copy of wm with the alpha of each pixel multiplied by
+// the gray level of the pixel of mask it lands on, with wm placed at at and
+// mask stretched over area. Pixels outside area are cleared.
+func maskAlpha(wm image.Image, at image.Point, area image.Rectangle, mask image.Image) *image.NRGBA {
+	b := wm.Bounds()
+	masked := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(masked, masked.Rect, wm, b.Min, draw.Src)
+
+	mb := mask.Bounds()
+	for j := 0; j < b.Dy(); j++ {
+		for i := 0; i < b.Dx(); i++ {
+			a := &masked.Pix[masked.PixOffset(i, j)+3]
+			p := at.Add(image.Pt(i, j))
+			if !p.In(area) || mb.Empty() {
+				*a = 0
+				continue
+			}
+
+			// nearest pixel of the stretched mask
+			mx := mb.Min.X + (p.X-area.Min.X)*mb.Dx()/area.Dx()
+			my := mb.Min.Y + (p.Y-area.Min.Y)*mb.Dy()/area.Dy()
+			level := color.GrayModel.Convert(mask.At(mx, my)).(color.Gray).Y
+			*a = uint8((uint32(*a)*uint32(level) + 127) / 255)
+		}
+	}
+
+	return masked
+}
+
+// contrastColor returns black for a background of luminance lum above the
+// middle of the range and white otherwise.
+func contrastColor(lum float64) color.Color {
+	if lum > 127.5 {
+		return color.Black
+	}
+	return color.White
+}
+
+// Flatten returns an opaque copy of img composited over a solid bg, for
+// formats such as JPEG that cannot store transparency and would otherwise
+// turn transparent areas black.
+func Flatten(img image.Image, bg color.Color) image.Image {
+	bounds := img.Bounds()
+	newImage := image.NewRGBA(bounds)
+	draw.Draw(newImage, bounds, &image.Uniform{C: bg}, image.Point{}, draw.Src)
+	draw.Draw(newImage, bounds, img, bounds.Min, draw.Over)
+
+	return newImage
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"path/filepath"
+	"testing"
+)
+
+func TestToGrayscale(t *testing.T) {
+	tests := []struct {
+		in   color.Color
+		want color.Color
+	}{
+		{color.NRGBA{255, 0, 0, 255}, color.NRGBA{76, 76, 76, 255}},
+		{color.NRGBA{0, 255, 0, 255}, color.NRGBA{150, 150, 150, 255}},
+		{color.NRGBA{0, 0, 255, 255}, color.NRGBA{29, 29, 29, 255}},
+		{color.NRGBA{200, 100, 50, 128}, color.NRGBA{124, 124, 124, 128}},
+		{color.NRGBA64{0xffff, 0, 0, 0xffff}, color.NRGBA64{19595, 19595, 19595, 0xffff}},
+	}
+
+	for _, tt := range tests {
+		var img draw.Image = image.NewNRGBA(image.Rect(0, 0, 1, 1))
+		if _, ok := tt.in.(color.NRGBA64); ok {
+			img = image.NewNRGBA64(img.Bounds())
+		}
+		img.Set(0, 0, tt.in)
+
+		if got := ToGrayscale(img).At(0, 0); got != tt.want {
+			t.Errorf("ToGrayscale of %v = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestGrayscaleKeepsWatermarkColor(t *testing.T) {
+	main := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	draw.Draw(main, main.Rect, image.NewUniform(color.NRGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+	wm := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	draw.Draw(wm, wm.Rect, image.NewUniform(color.NRGBA{0, 0, 255, 255}), image.Point{}, draw.Src)
+
+	out, err := WatermarkImage(main, []WatermarkSpec{{Image: wm}}, WithGrayscale())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := color.NRGBAModel.Convert(out.At(5, 5)), (color.NRGBA{76, 76, 76, 255}); got != want {
+		t.Errorf("main image pixel = %v, want %v", got, want)
+	}
+	if got, want := color.NRGBAModel.Convert(out.At(1, 1)), (color.NRGBA{0, 0, 255, 255}); got != want {
+		t.Errorf("watermark pixel = %v, want %v", got, want)
+	}
+}
+
+func TestSaveImageBackground(t *testing.T) {
+	// transparent but for an opaque red center
+	src := image.NewNRGBA(image.Rect(0, 0, 32, 32))
+	draw.Draw(src, image.Rect(8, 8, 24, 24), image.NewUniform(color.NRGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.png")
+	err := SaveImage(src, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transparent, err := ReadImage(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		opts   []Option
+		corner color.NRGBA
+	}{
+		{"white background", []Option{WithBackground(color.White)}, color.NRGBA{255, 255, 255, 255}},
+		{"no background", nil, color.NRGBA{0, 0, 0, 255}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// full chroma, so the red does not bleed into the corners
+			out := filepath.Join(t.TempDir(), "out.jpg")
+			err := SaveImage(transparent, out, append(tt.opts, WithQuality(100), WithSubsampling(Subsampling444))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			img, err := ReadImage(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// JPEG is lossy, so the channels may be off a little
+			for _, p := range []image.Point{{0, 0}, {31, 0}, {0, 31}, {31, 31}} {
+				if got := color.NRGBAModel.Convert(img.At(p.X, p.Y)).(color.NRGBA); !closeNRGBA(got, tt.corner, 4) {
+					t.Errorf("corner %v = %v, want about %v", p, got, tt.corner)
+				}
+			}
+			if got := color.NRGBAModel.Convert(img.At(16, 16)).(color.NRGBA); !closeNRGBA(got, color.NRGBA{255, 0, 0, 255}, 8) {
+				t.Errorf("center = %v, want about red", got)
+			}
+		})
+	}
+}
+
+func TestTintImage(t *testing.T) {
+	// a white logo with an antialiased edge on a transparent background,
+	// tinted with a color whose alpha is ignored
+	logo := image.NewNRGBA(image.Rect(0, 0, 3, 1))
+	logo.SetNRGBA(0, 0, color.NRGBA{255, 255, 255, 255})
+	logo.SetNRGBA(1, 0, color.NRGBA{255, 255, 255, 100})
+
+	tinted := TintImage(logo, color.NRGBA{255, 0, 0, 40}).(*image.NRGBA)
+	tests := []struct {
+		x    int
+		want color.NRGBA
+	}{
+		{0, color.NRGBA{255, 0, 0, 255}},
+		{1, color.NRGBA{255, 0, 0, 100}},
+		{2, color.NRGBA{}},
+	}
+	for _, tt := range tests {
+		if got := tinted.NRGBAAt(tt.x, 0); got != tt.want {
+			t.Errorf("pixel %d = %v, want %v", tt.x, got, tt.want)
+		}
+	}
+}
+
+func TestAdjustBrightnessContrast(t *testing.T) {
+	tests := []struct {
+		name                 string
+		brightness, contrast float64
+		in, want             color.NRGBA
+	}{
+		// 0.2 of the range is 51 levels, clamped at 255
+		{"brighter", 0.2, 0, color.NRGBA{100, 200, 230, 255}, color.NRGBA{151, 251, 255, 255}},
+		{"darker", -0.2, 0, color.NRGBA{100, 30, 0, 255}, color.NRGBA{49, 0, 0, 255}},
+		{"alpha kept", 0.2, 0, color.NRGBA{0, 0, 0, 128}, color.NRGBA{51, 51, 51, 128}},
+		{"more contrast", 0, 1, color.NRGBA{64, 128, 200, 255}, color.NRGBA{0, 129, 255, 255}},
+		{"less contrast", 0, -0.5, color.NRGBA{0, 255, 64, 255}, color.NRGBA{64, 191, 96, 255}},
+		{"unchanged", 0, 0, color.NRGBA{1, 2, 3, 255}, color.NRGBA{1, 2, 3, 255}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+			img.SetNRGBA(0, 0, tt.in)
+
+			got := color.NRGBAModel.Convert(AdjustBrightnessContrast(img, tt.brightness, tt.contrast).At(0, 0))
+			if got != tt.want {
+				t.Errorf("AdjustBrightnessContrast(%v, %v, %v) = %v, want %v", tt.in, tt.brightness, tt.contrast, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyColorKey(t *testing.T) {
+	// a red logo on a white background with slightly off-white noise, as
+	// a JPEG gives it
+	logo := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	draw.Draw(logo, logo.Rect, image.NewUniform(color.NRGBA{255, 255, 255, 255}), image.Point{}, draw.Src)
+	logo.SetNRGBA(1, 1, color.NRGBA{250, 252, 255, 255})
+	draw.Draw(logo, image.Rect(2, 2, 6, 6), image.NewUniform(color.NRGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+
+	keyed := ApplyColorKey(logo, color.White, 8)
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			_, _, _, a := keyed.At(x, y).RGBA()
+			if inLogo := image.Pt(x, y).In(image.Rect(2, 2, 6, 6)); inLogo != (a != 0) {
+				t.Fatalf("pixel (%d, %d) has alpha %d, want it to show only in the logo", x, y, a)
+			}
+		}
+	}
+
+	// blended on black, the background no longer shows
+	main := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	draw.Draw(main, main.Rect, image.NewUniform(color.NRGBA{0, 0, 0, 255}), image.Point{}, draw.Src)
+	out, err := WatermarkImage(main, []WatermarkSpec{{Image: logo}}, WithColorKey(color.White, 8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := color.NRGBAModel.Convert(out.At(1, 1)); got != (color.NRGBA{0, 0, 0, 255}) {
+		t.Errorf("keyed background pixel = %v, want the black main image", got)
+	}
+	if got := color.NRGBAModel.Convert(out.At(3, 3)); got != (color.NRGBA{255, 0, 0, 255}) {
+		t.Errorf("logo pixel = %v, want red", got)
+	}
+
+	// without enough tolerance the off-white pixel stays
+	if _, _, _, a := ApplyColorKey(logo, color.White, 4).At(1, 1).RGBA(); a == 0 {
+		t.Error("a pixel 5 levels off the key was keyed with a tolerance of 4")
+	}
+}
+
+func TestWatermarkMask(t *testing.T) {
+	// white on the left half, black on the right, and smaller than the
+	// main image, so it is stretched over it
+	mask := image.NewGray(image.Rect(0, 0, 10, 5))
+	draw.Draw(mask, image.Rect(0, 0, 5, 5), image.White, image.Point{}, draw.Src)
+
+	main := image.NewGray(image.Rect(0, 0, 40, 20))
+	red := image.NewNRGBA(main.Rect)
+	draw.Draw(red, red.Rect, image.NewUniform(color.NRGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+
+	out, err := WatermarkImage(main, []WatermarkSpec{{Image: red}}, WithMask(mask))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := redBounds(out), image.Rect(0, 0, 20, 20); got != want {
+		t.Errorf("watermark shows on %v, want the white half %v", got, want)
+	}
+	for _, p := range []image.Point{{20, 0}, {39, 19}, {30, 10}} {
+		if got := color.NRGBAModel.Convert(out.At(p.X, p.Y)); got != (color.NRGBA{0, 0, 0, 255}) {
+			t.Errorf("pixel %v under the black half = %v, want the main image", p, got)
+		}
+	}
+
+	// a gray mask halves the alpha
+	gray := image.NewGray(image.Rect(0, 0, 1, 1))
+	gray.SetGray(0, 0, color.Gray{128})
+	masked := ApplyMask(red, gray)
+	if got := masked.NRGBAAt(3, 3); got != (color.NRGBA{255, 0, 0, 128}) {
+		t.Errorf("ApplyMask of gray = %v, want half alpha red", got)
+	}
+}
@@ -0,0 +1,29 @@
+//go:build avif
+
+package main
+
+import (
+	"image"
+	"io"
+
+	"github.com/gen2brain/avif"
+)
+
+// avifSupported reports whether AVIF images can be read.
+const avifSupported = true
+
+// decodeAVIF decodes an AVIF image. Builds with the avif tag need
+// github.com/gen2brain/avif, whose oldest release already requires Go
+// 1.21 while the module supports 1.19, so it is not in go.mod. Add it
+// before building, which raises the go directive of go.mod to 1.21:
+//
+//	go get github.com/gen2brain/avif@v0.1.0
+//	go build -tags avif
+func decodeAVIF(r io.Reader) (image.Image, error) {
+	return avif.Decode(r)
+}
+
+// decodeAVIFConfig decodes the size and color model of an AVIF image.
+func decodeAVIFConfig(r io.Reader) (image.Config, error) {
+	return avif.DecodeConfig(r)
+}
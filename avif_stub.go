@@ -0,0 +1,26 @@
+//go:build !avif
+
+package main
+
+import (
+	"errors"
+	"image"
+	"io"
+)
+
+// avifSupported reports whether AVIF images can be read.
+const avifSupported = false
+
+// errNoAVIF is returned for AVIF images by builds without the avif tag,
+// whose decoder is left out so the module builds with older Go versions.
+var errNoAVIF = errors.New("avif decoding requires a build with -tags avif")
+
+// decodeAVIF is unavailable without the avif build tag.
+func decodeAVIF(r io.Reader) (image.Image, error) {
+	return nil, errNoAVIF
+}
+
+// decodeAVIFConfig is unavailable without the avif build tag.
+func decodeAVIFConfig(r io.Reader) (image.Config, error) {
+	return image.Config{}, errNoAVIF
+}
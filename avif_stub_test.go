@@ -0,0 +1,15 @@
+//go:build !avif
+
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestReadAVIFWithoutTag(t *testing.T) {
+	_, err := ReadImage("testdata/red-blue.avif")
+	if !errors.Is(err, errNoAVIF) {
+		t.Errorf("ReadImage = %v, want %v", err, errNoAVIF)
+	}
+}
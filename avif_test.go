@@ -0,0 +1,46 @@
+//go:build avif
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestReadAVIF(t *testing.T) {
+	// testdata/red-blue.avif is 16x8, red on the left half and blue on the
+	// right
+	config, err := ReadImageConfig("testdata/red-blue.avif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Width != 16 || config.Height != 8 {
+		t.Errorf("config size = %dx%d, want 16x8", config.Width, config.Height)
+	}
+
+	img, err := ReadImage("testdata/red-blue.avif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != image.Rect(0, 0, 16, 8) {
+		t.Fatalf("bounds = %v, want 16x8", img.Bounds())
+	}
+
+	tests := []struct {
+		at   image.Point
+		want color.NRGBA
+	}{
+		{image.Pt(0, 0), color.NRGBA{255, 0, 0, 255}},
+		{image.Pt(7, 7), color.NRGBA{255, 0, 0, 255}},
+		{image.Pt(8, 0), color.NRGBA{0, 0, 255, 255}},
+		{image.Pt(15, 7), color.NRGBA{0, 0, 255, 255}},
+	}
+	for _, tt := range tests {
+		// the sample is lossy, so the channels may be off a little
+		got := color.NRGBAModel.Convert(img.At(tt.at.X, tt.at.Y)).(color.NRGBA)
+		if !closeNRGBA(got, tt.want, 8) {
+			t.Errorf("pixel %v = %v, want about %v", tt.at, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ProcessDirectory applies the watermark image file to every supported
+// image directly inside inputDir and writes the results to outDir under
+// the same file names. See AddWatermark for the placement rules.
+func ProcessDirectory(inputDir, watermarkImagePath, outDir, anchor string, x, y, height, width int, opts ...Option) error {
+	waterMarkImg, err := ReadImage(watermarkImagePath, opts...)
+	if err != nil {
+		return err
+	}
+
+	spec := WatermarkSpec{Image: waterMarkImg, Anchor: anchor, X: x, Y: y, Height: height, Width: width}
+	return processDirectory(inputDir, []WatermarkSpec{spec}, outDir, opts...)
+}
+
+// processDirectory is ProcessDirectory for in-memory watermarks, which are
+// applied in order as by AddWatermarks and prepared once for all main
+// images of the same size. Subdirectories and files that are not in a
+// supported format are skipped with a warning. Files are watermarked
+// concurrently by the number of workers set with WithWorkers; a failing
+// file does not stop the others and all failures are returned together as a
+// *BatchError. The callback set with WithProgress is called after each
+// file, failed or not.
+func processDirectory(inputDir string, specs []WatermarkSpec, outDir string, opts ...Option) error {
+	o := newOptions(opts)
+	if o.workers < 1 {
+		return fmt.Errorf("workers %d must be at least 1", o.workers)
+	}
+
+	// the watermarks are only resized once for each size of main image
+	opts = append(opts[:len(opts):len(opts)], withPreparedCache(&preparedCache{}))
+
+	entries, err := os.ReadDir(inputDir)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(outDir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		name := entry.Name()
+		if !isSupportedFormat(strings.TrimPrefix(filepath.Ext(name), ".")) {
+			if !o.quiet {
+				fmt.Fprintf(os.Stderr, "warning: skipping %s: has to be of type %s\n", name, supportedFormats)
+			}
+			continue
+		}
+
+		names = append(names, name)
+	}
+
+	// each worker only writes the error slot of the file it processed, so
+	// the errors come back in directory order whatever the worker count
+	errs := make([]error, len(names))
+	jobs := make(chan int)
+
+	// progress is reported under a lock so the callback sees one file at a
+	// time and done only ever grows
+	var progressMu sync.Mutex
+	done := 0
+
+	var wg sync.WaitGroup
+	for w := 0; w < o.workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				outName := names[i]
+				if o.format != "" {
+					outName = strings.TrimSuffix(outName, filepath.Ext(outName)) + "." + o.format
+				}
+				errs[i] = AddWatermarks(filepath.Join(inputDir, names[i]), specs, filepath.Join(outDir, outName), opts...)
+
+				if o.progress != nil {
+					progressMu.Lock()
+					done++
+					o.progress(done, len(names))
+					progressMu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for i := range names {
+		if o.ctx.Err() != nil {
+			break
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	err = o.ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	batchErr := &BatchError{}
+	for i, err := range errs {
+		if err != nil {
+			batchErr.Errs = append(batchErr.Errs, fmt.Errorf("%s: %w", names[i], err))
+		}
+	}
+
+	if len(batchErr.Errs) > 0 {
+		return batchErr
+	}
+
+	return nil
+}
+
+// preparedCache holds the watermarks of a batch as prepareWatermarks left
+// them, by the size of the main image they were prepared for. Its methods
+// are safe for concurrent use and do nothing on a nil cache.
+type preparedCache struct {
+	mu    sync.Mutex
+	specs map[image.Point][]WatermarkSpec
+}
+
+// withPreparedCache makes prepareWatermarks reuse the watermarks in c.
+func withPreparedCache(c *preparedCache) Option {
+	return func(o *options) {
+		o.prepared = c
+	}
+}
+
+// get returns the watermarks prepared for a main image of size, if any.
+func (c *preparedCache) get(size image.Point) ([]WatermarkSpec, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	specs, ok := c.specs[size]
+	return specs, ok
+}
+
+// put stores the watermarks prepared for a main image of size.
+func (c *preparedCache) put(size image.Point, specs []WatermarkSpec) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.specs == nil {
+		c.specs = map[image.Point][]WatermarkSpec{}
+	}
+	c.specs[size] = specs
+}
+
+// BatchError holds the errors of every file that could not be watermarked
+// in a batch run.
+type BatchError struct {
+	Errs []error
+}
+
+func (e *BatchError) Error() string {
+	msgs := make([]string, len(e.Errs))
+	for i, err := range e.Errs {
+		msgs[i] = err.Error()
+	}
+
+	return fmt.Sprintf("%d file(s) failed:\n%s", len(e.Errs), strings.Join(msgs, "\n"))
+}
+
+// Unwrap returns the errors of the files, so errors.Is and errors.As match
+// a failure of any one of them.
+func (e *BatchError) Unwrap() []error {
+	return e.Errs
+}
+
+// Is reports whether the error of any file matches target, for Go versions
+// whose errors.Is does not look at Unwrap() []error.
+func (e *BatchError) Is(target error) bool {
+	for _, err := range e.Errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSupportedFormat reports whether images of format can be both read and
+// written, by the built-in codecs or registered ones.
+func isSupportedFormat(format string) bool {
+	_, decodable := registeredDecoder(format)
+	_, encodable := registeredEncoder(format)
+	return decodable && encodable
+}
+
+// isWritableFormat reports whether images of format can be written.
+func isWritableFormat(format string) bool {
+	_, ok := registeredEncoder(format)
+	return ok
+}
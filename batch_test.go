@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestBatchErrorUnwrap(t *testing.T) {
+	in, out := t.TempDir(), t.TempDir()
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	for _, name := range []string{"a.png", "b.png"} {
+		err := SaveImage(img, filepath.Join(in, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// only b.png is in the way
+	err := os.WriteFile(filepath.Join(out, "b.png"), []byte("existing"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wm := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	err = processDirectory(in, []WatermarkSpec{{Image: wm}}, out)
+
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) || len(batchErr.Errs) != 1 {
+		t.Fatalf("processDirectory error = %v, want a *BatchError for one file", err)
+	}
+	if !errors.Is(err, fs.ErrExist) {
+		t.Errorf("errors.Is(%v, fs.ErrExist) = false, want true", err)
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("errors.Is(%v, fs.ErrNotExist) = true, want false", err)
+	}
+}
+
+func TestProcessDirectory(t *testing.T) {
+	in, out := t.TempDir(), filepath.Join(t.TempDir(), "out")
+	img := noiseImage(20, 10)
+	names := []string{"a.png", "b.jpg", "c.gif", "d.BMP"}
+	for _, name := range names {
+		err := SaveImage(img, filepath.Join(in, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// skipped, not failed
+	err := os.WriteFile(filepath.Join(in, "notes.txt"), []byte("not an image"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Mkdir(filepath.Join(in, "sub"), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wmPath := filepath.Join(t.TempDir(), "wm.png")
+	err = SaveImage(image.NewNRGBA(image.Rect(0, 0, 4, 4)), wmPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ProcessDirectory(in, wmPath, out, "bottom-right", 0, 0, 0, 0, WithQuiet())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, entry := range entries {
+		got = append(got, entry.Name())
+	}
+	if !reflect.DeepEqual(got, names) {
+		t.Fatalf("outputs = %q, want %q", got, names)
+	}
+
+	for _, name := range names {
+		watermarked, err := ReadImage(filepath.Join(out, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if watermarked.Bounds() != img.Rect {
+			t.Errorf("%s bounds = %v, want %v", name, watermarked.Bounds(), img.Rect)
+		}
+	}
+}
+
+func TestProcessDirectoryWorkers(t *testing.T) {
+	in := t.TempDir()
+	for i := 0; i < 16; i++ {
+		img := noiseImage(24+i, 16)
+		err := SaveImage(img, filepath.Join(in, fmt.Sprintf("%02d.png", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wm := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	for i := range wm.Pix {
+		wm.Pix[i] = uint8(i * 37)
+	}
+	specs := []WatermarkSpec{{Image: wm, Anchor: "center", Width: 6}}
+
+	run := func(workers int) map[string][]byte {
+		out := t.TempDir()
+		err := processDirectory(in, specs, out, WithWorkers(workers))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		files := map[string][]byte{}
+		entries, err := os.ReadDir(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range entries {
+			data, err := os.ReadFile(filepath.Join(out, entry.Name()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			files[entry.Name()] = data
+		}
+		return files
+	}
+
+	serial, parallel := run(1), run(4)
+	if len(serial) != 16 {
+		t.Fatalf("workers=1 wrote %d files, want 16", len(serial))
+	}
+	if len(parallel) != len(serial) {
+		t.Fatalf("workers=4 wrote %d files, workers=1 %d", len(parallel), len(serial))
+	}
+	for name, data := range serial {
+		if !bytes.Equal(parallel[name], data) {
+			t.Errorf("%s differs between workers=4 and workers=1", name)
+		}
+	}
+}
+
+func TestProcessDirectoryProgress(t *testing.T) {
+	in, out := t.TempDir(), t.TempDir()
+	for i := 0; i < 6; i++ {
+		err := SaveImage(image.NewGray(image.Rect(0, 0, 8, 8)), filepath.Join(in, fmt.Sprintf("%d.png", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a failing file is reported too
+	err := os.WriteFile(filepath.Join(out, "3.png"), []byte("existing"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type call struct{ done, total int }
+	var calls []call
+	progress := func(done, total int) {
+		calls = append(calls, call{done, total})
+	}
+
+	wm := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	err = processDirectory(in, []WatermarkSpec{{Image: wm}}, out, WithWorkers(3), WithProgress(progress))
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) || len(batchErr.Errs) != 1 {
+		t.Fatalf("processDirectory error = %v, want a *BatchError for one file", err)
+	}
+
+	var want []call
+	for done := 1; done <= 6; done++ {
+		want = append(want, call{done, 6})
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("progress calls = %v, want %v", calls, want)
+	}
+}
+
+func TestProcessDirectoryCancelled(t *testing.T) {
+	in, out := t.TempDir(), t.TempDir()
+	for i := 0; i < 10; i++ {
+		err := SaveImage(image.NewGray(image.Rect(0, 0, 8, 8)), filepath.Join(in, fmt.Sprintf("%d.png", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// cancelled once the second file is done
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	progress := func(done, total int) {
+		if done == 2 {
+			cancel()
+		}
+	}
+
+	wm := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	err := processDirectory(in, []WatermarkSpec{{Image: wm}}, out, WithContext(ctx), WithProgress(progress))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("processDirectory error = %v, want %v", err, context.Canceled)
+	}
+
+	entries, err := os.ReadDir(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) >= 10 {
+		t.Errorf("%d files written after cancelling, want fewer than all 10", len(entries))
+	}
+}
+
+func TestProcessDirectoryWatermarkOnce(t *testing.T) {
+	const files = 5
+	in, out := t.TempDir(), t.TempDir()
+	for i := 0; i < files; i++ {
+		err := SaveImage(image.NewGray(image.Rect(0, 0, 40, 30)), filepath.Join(in, fmt.Sprintf("%d.png", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the watermark is a PNG in a format of its own, to count its decodes
+	var decodes int32
+	RegisterDecoder("countedpng", func(r io.Reader) (image.Image, error) {
+		atomic.AddInt32(&decodes, 1)
+		return png.Decode(r)
+	})
+	wm := filepath.Join(t.TempDir(), "wm.countedpng")
+	err := SaveImage(redSquare(20), wm, WithFormat("png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.Main, cfg.Output, cfg.Dir = in, out, true
+	cfg.Watermarks = []WatermarkConfig{{Image: wm, Width: 10, Height: 10}}
+	err = cfg.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decodes != 1 {
+		t.Errorf("watermark decoded %d times for %d files, want once", decodes, files)
+	}
+
+	// and resized once for the main images of the same size
+	specs, err := cfg.Specs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logged bytes.Buffer
+	err = processDirectory(in, specs, t.TempDir(), WithWorkers(1), WithLogger(log.New(&logged, "", 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(logged.String(), "resizing watermark"); got != 1 {
+		t.Errorf("watermark resized %d times for %d files, want once", got, files)
+	}
+	if got := strings.Count(logged.String(), "reusing watermarks"); got != files-1 {
+		t.Errorf("watermarks reused for %d files, want %d", got, files-1)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"image/color"
+	"math"
+)
+
+// BlendMode selects how the colors of a watermark are combined with the
+// main image where they overlap. The alpha is composited the same way for
+// every mode.
+type BlendMode int
+
+const (
+	// SourceOver paints the watermark over the main image, as Blend does.
+	SourceOver BlendMode = iota
+	// Multiply darkens the main image by the watermark, so white parts of
+	// the watermark leave it unchanged.
+	Multiply
+	// Screen lightens the main image by the watermark, so black parts of
+	// the watermark leave it unchanged.
+	Screen
+	// Overlay multiplies the dark parts of the main image and screens the
+	// light parts, which keeps its highlights and shadows.
+	Overlay
+)
+
+// blendModes maps the -blendmode flag values to their BlendMode.
+var blendModes = map[string]BlendMode{
+	"source-over": SourceOver,
+	"multiply":    Multiply,
+	"screen":      Screen,
+	"overlay":     Overlay,
+}
+
+// BlendWithMode composites the watermark color onto the main color with
+// mode. SourceOver gives the same result as Blend. The other modes mix the
+// colors as the W3C compositing spec describes, in proportion to the alpha
+// of the main color, and give a non-premultiplied color.NRGBA64.
+func BlendWithMode(watermark color.Color, main color.Color, mode BlendMode) color.Color {
+	if mode == SourceOver {
+		return Blend(watermark, main)
+	}
+
+	w := color.NRGBA64Model.Convert(watermark).(color.NRGBA64)
+	if w.A == 0 {
+		return main
+	}
+	m := color.NRGBA64Model.Convert(main).(color.NRGBA64)
+
+	wc := [3]float64{float64(w.R) / 0xffff, float64(w.G) / 0xffff, float64(w.B) / 0xffff}
+	mc := [3]float64{float64(m.R) / 0xffff, float64(m.G) / 0xffff, float64(m.B) / 0xffff}
+	out, a := blendMode(mode, wc, float64(w.A)/0xffff, mc, float64(m.A)/0xffff)
+	return color.NRGBA64{
+		R: uint16(math.Round(out[0] * 0xffff)),
+		G: uint16(math.Round(out[1] * 0xffff)),
+		B: uint16(math.Round(out[2] * 0xffff)),
+		A: uint16(math.Round(a * 0xffff)),
+	}
+}
+
+// blendMode composites the watermark color wc with alpha wa onto the main
+// color mc with alpha ma with mode. The colors are not premultiplied and
+// everything runs from 0 to 1. It returns the color and alpha of the
+// result.
+func blendMode(mode BlendMode, wc [3]float64, wa float64, mc [3]float64, ma float64) ([3]float64, float64) {
+	var out [3]float64
+	a := wa + ma*(1-wa)
+	if a == 0 {
+		return out, 0
+	}
+
+	for c := range out {
+		mixed := mixChannel(mode, mc[c], wc[c])
+		// where the main image is transparent the watermark shows as it is
+		premul := wa*(1-ma)*wc[c] + wa*ma*mixed + (1-wa)*ma*mc[c]
+		out[c] = premul / a
+	}
+	return out, a
+}
+
+// mixChannel is the blend function of mode for the main channel value b
+// and the watermark channel value s.
+func mixChannel(mode BlendMode, b, s float64) float64 {
+	switch mode {
+	case Multiply:
+		return b * s
+	case Screen:
+		return b + s - b*s
+	case Overlay:
+		if b <= 0.5 {
+			return 2 * b * s
+		}
+		return 1 - 2*(1-b)*(1-s)
+	default:
+		return s
+	}
+}
+
+// blendNRGBAPixelMode returns blendNRGBAPixel with the arithmetic of
+// BlendWithMode for mode.
+func blendNRGBAPixelMode(mode BlendMode) func(d, s []uint8) {
+	return func(d, s []uint8) {
+		wc := [3]float64{float64(s[0]) / 0xff, float64(s[1]) / 0xff, float64(s[2]) / 0xff}
+		mc := [3]float64{float64(d[0]) / 0xff, float64(d[1]) / 0xff, float64(d[2]) / 0xff}
+		out, a := blendMode(mode, wc, float64(s[3])/0xff, mc, float64(d[3])/0xff)
+		d[0] = uint8(math.Round(out[0] * 0xff))
+		d[1] = uint8(math.Round(out[1] * 0xff))
+		d[2] = uint8(math.Round(out[2] * 0xff))
+		d[3] = uint8(math.Round(a * 0xff))
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestBlendModes(t *testing.T) {
+	white := color.NRGBA{255, 255, 255, 255}
+	black := color.NRGBA{0, 0, 0, 255}
+	mains := []color.NRGBA{{200, 100, 30, 255}, {0, 255, 128, 255}, {10, 20, 30, 255}, black, white}
+
+	tests := []struct {
+		name      string
+		mode      BlendMode
+		watermark color.NRGBA
+		want      func(main color.NRGBA) color.NRGBA
+	}{
+		{"multiply by white", Multiply, white, func(m color.NRGBA) color.NRGBA { return m }},
+		{"multiply by black", Multiply, black, func(color.NRGBA) color.NRGBA { return black }},
+		{"screen with black", Screen, black, func(m color.NRGBA) color.NRGBA { return m }},
+		{"screen with white", Screen, white, func(color.NRGBA) color.NRGBA { return white }},
+		{"source over", SourceOver, white, func(color.NRGBA) color.NRGBA { return white }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, m := range mains {
+				got := color.NRGBAModel.Convert(BlendWithMode(tt.watermark, m, tt.mode))
+				if want := tt.want(m); got != want {
+					t.Errorf("BlendWithMode(%v, %v) = %v, want %v", tt.watermark, m, got, want)
+				}
+			}
+
+			// the fast path of a whole image gives the same
+			main := image.NewNRGBA(image.Rect(0, 0, len(mains), 1))
+			for x, m := range mains {
+				main.SetNRGBA(x, 0, m)
+			}
+			wm := image.NewNRGBA(main.Rect)
+			draw.Draw(wm, wm.Rect, image.NewUniform(tt.watermark), image.Point{}, draw.Src)
+			out, err := WatermarkImage(main, []WatermarkSpec{{Image: wm}}, WithBlendMode(tt.mode))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for x, m := range mains {
+				if got, want := color.NRGBAModel.Convert(out.At(x, 0)), tt.want(m); got != want {
+					t.Errorf("watermarked pixel over %v = %v, want %v", m, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Check validates the job the way Run would without watermarking anything
+// or writing the output. Only the image headers are read to learn the
+// dimensions, so every watermark is sized and placed on every main image
+// with the same rules as AddWatermarks. Images fetched from URLs or read
+// from stdin cannot be measured and only have their settings checked.
+// All problems found are returned; none means the job is valid.
+func (c *Config) Check() []error {
+	var errs []error
+
+	opts, err := c.Options()
+	if err != nil {
+		return []error{err}
+	}
+	o := newOptions(opts)
+
+	if o.workers < 1 {
+		errs = append(errs, fmt.Errorf("workers %d must be at least 1", o.workers))
+	}
+	if c.Tile && c.Gap < 0 {
+		errs = append(errs, fmt.Errorf("gap %d must not be negative", c.Gap))
+	}
+	if len(c.Watermarks) == 0 && !o.stamp {
+		errs = append(errs, errors.New("no watermark given"))
+	}
+	if _, err = prepareStamp(o); err != nil {
+		errs = append(errs, err)
+	}
+
+	// sizes of the watermarks as read, nil for those that cannot be measured
+	sizes := make([]*image.Point, len(c.Watermarks))
+	for i, wm := range c.Watermarks {
+		err = validateWatermark(wm.spec(nil), o)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		size, err := wm.size(c.loadOptions()...)
+		if err == nil && size != nil && o.maxDim > 0 {
+			err = checkDimensions(size.X, size.Y, o.maxDim)
+			if err != nil {
+				err = fmt.Errorf("%s: %w", wm.Image, err)
+			}
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		sizes[i] = size
+	}
+
+	if err = c.checkPaths(); err != nil {
+		errs = append(errs, err)
+	}
+
+	mains, err := c.checkMains()
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	outFormat, err := c.checkOutput()
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if outFormat == "jpg" || outFormat == "jpeg" || outFormat == "webp" {
+		if o.quality < 1 || o.quality > 100 {
+			errs = append(errs, fmt.Errorf("quality %d must be between 1 and 100", o.quality))
+		}
+	}
+
+	for _, path := range mains {
+		config, err := ReadImageConfig(path, opts...)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if o.maxDim > 0 {
+			err = checkDimensions(config.Width, config.Height, o.maxDim)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", path, err))
+				continue
+			}
+		}
+
+		mainBounds := image.Rect(0, 0, config.Width, config.Height)
+		if o.crop {
+			err = checkCrop(o.cropRect, mainBounds)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", path, err))
+				continue
+			}
+			mainBounds = image.Rect(0, 0, o.cropRect.Dx(), o.cropRect.Dy())
+		}
+
+		for i, size := range sizes {
+			if size == nil {
+				continue
+			}
+
+			err = checkFit(size.X, size.Y, mainBounds, o)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", path, err))
+				continue
+			}
+
+			wm := c.Watermarks[i]
+			w, h := watermarkSize(size.X, size.Y, wm.Height, wm.Width, mainBounds, o)
+			x, y := wm.spec(nil).offset(mainBounds.Dx(), mainBounds.Dy())
+			_, _, err = placeWatermark(mainBounds.Dx(), mainBounds.Dy(), w, h, wm.Position, x, y, o)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			}
+		}
+	}
+
+	return errs
+}
+
+// checkMains returns the main image files of the job that can be measured.
+func (c *Config) checkMains() ([]string, error) {
+	if c.Dir {
+		entries, err := os.ReadDir(c.Main)
+		if err != nil {
+			return nil, err
+		}
+
+		var paths []string
+		for _, entry := range entries {
+			if !entry.IsDir() && isSupportedFormat(strings.TrimPrefix(filepath.Ext(entry.Name()), ".")) {
+				paths = append(paths, filepath.Join(c.Main, entry.Name()))
+			}
+		}
+		return paths, nil
+	}
+
+	if c.Main == "-" {
+		if c.InFormat == "" {
+			return nil, errors.New("-informat is required when reading from stdin")
+		}
+		if !isSupportedFormat(c.InFormat) {
+			return nil, fmt.Errorf("%w %q, has to be %s", ErrUnsupportedFormat, c.InFormat, supportedFormats)
+		}
+		return nil, nil
+	}
+
+	if isURL(c.Main) {
+		return nil, nil
+	}
+
+	return []string{c.Main}, nil
+}
+
+// checkOutput checks that the output can be written and returns its format.
+func (c *Config) checkOutput() (string, error) {
+	if c.Dir {
+		// the output directory is created when missing
+		if c.OutFormat != "" && !isWritableFormat(c.OutFormat) {
+			return "", fmt.Errorf("%w %q, has to be %s", ErrUnsupportedFormat, c.OutFormat, supportedFormats)
+		}
+		return strings.ToLower(c.OutFormat), nil
+	}
+
+	if c.Output == "-" {
+		format := c.OutFormat
+		if format == "" {
+			format = c.InFormat
+		}
+		if format == "" {
+			format = strings.TrimPrefix(filepath.Ext(c.Main), ".")
+		}
+		if !isWritableFormat(format) {
+			return "", fmt.Errorf("%w %q, has to be %s", ErrUnsupportedFormat, format, supportedFormats)
+		}
+		return strings.ToLower(format), nil
+	}
+
+	format := c.OutFormat
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(c.Output), ".")
+	}
+	if !isWritableFormat(format) {
+		return "", fmt.Errorf("%s: %w, has to be %s", c.Output, ErrUnsupportedFormat, supportedFormats)
+	}
+
+	info, err := os.Stat(filepath.Dir(c.Output))
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", filepath.Dir(c.Output))
+	}
+
+	if !c.Force {
+		_, err = os.Stat(c.Output)
+		if err == nil {
+			return "", fmt.Errorf("%s: %w, use -force to overwrite it", c.Output, fs.ErrExist)
+		}
+	}
+
+	return strings.ToLower(format), nil
+}
+
+// size returns the dimensions of the watermark before it is resized, or
+// nil when it is fetched from a URL. Text is rendered with opts to measure
+// it.
+func (w *WatermarkConfig) size(opts ...Option) (*image.Point, error) {
+	if w.Text == "" && w.Image != "" {
+		if isURL(w.Image) {
+			return nil, nil
+		}
+
+		config, err := ReadImageConfig(w.Image)
+		if err != nil {
+			return nil, err
+		}
+		return &image.Point{X: config.Width, Y: config.Height}, nil
+	}
+
+	img, err := w.load(opts...)
+	if err != nil {
+		return nil, err
+	}
+	size := img.Bounds().Size()
+	return &size, nil
+}
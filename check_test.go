@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	mainPath := filepath.Join(dir, "main.png")
+	wmPath := filepath.Join(dir, "wm.png")
+	tiffPath := filepath.Join(dir, "main.tiff")
+	for path, size := range map[string]int{mainPath: 100, wmPath: 20, tiffPath: 100} {
+		err := SaveImage(image.NewNRGBA(image.Rect(0, 0, size, size)), path)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a format that can be written but not read is a valid output
+	RegisterEncoder("writeonly", func(w io.Writer, img image.Image) error {
+		_, err := w.Write([]byte("ok"))
+		return err
+	})
+
+	job := func(change func(c *Config)) *Config {
+		c := DefaultConfig()
+		c.Main = mainPath
+		c.Output = filepath.Join(dir, "out.png")
+		c.Watermarks = []WatermarkConfig{{Image: wmPath, Position: "bottom-right"}}
+		if change != nil {
+			change(&c)
+		}
+		return &c
+	}
+
+	tests := []struct {
+		name string
+		job  *Config
+		want []string
+	}{
+		{"valid", job(nil), nil},
+		{"write-only output", job(func(c *Config) { c.Output = filepath.Join(dir, "out.writeonly") }), nil},
+		{"missing main image", job(func(c *Config) { c.Main = filepath.Join(dir, "missing.png") }), []string{"missing.png"}},
+		{"missing watermark", job(func(c *Config) { c.Watermarks[0].Image = filepath.Join(dir, "missing.png") }), []string{"missing.png"}},
+		{"unknown output format", job(func(c *Config) { c.Output = filepath.Join(dir, "out.xyz") }), []string{"unsupported format"}},
+		{"read-only output format", job(func(c *Config) { c.OutFormat = "svg" }), []string{"unsupported format"}},
+		{"off the image", job(func(c *Config) { c.Watermarks[0].Position, c.Watermarks[0].X = "", 200 }), []string{"out of bounds"}},
+		{"quality", job(func(c *Config) { c.Output, c.Quality = filepath.Join(dir, "out.jpg"), 101 }), []string{"quality 101"}},
+		{"too large", job(func(c *Config) { c.MaxDim = 50 }), []string{"image too large"}},
+		{"missing page", job(func(c *Config) { c.Main, c.Page = tiffPath, 1 }), []string{"page 1 not found"}},
+		{
+			"several problems",
+			job(func(c *Config) {
+				c.Output = filepath.Join(dir, "out.xyz")
+				c.Watermarks[0].Position, c.Watermarks[0].Y = "", -40
+			}),
+			[]string{"unsupported format", "out of bounds"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := tt.job.Check()
+			if len(errs) != len(tt.want) {
+				t.Fatalf("Check() = %v, want %d problems", errs, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(errs[i].Error(), want) {
+					t.Errorf("problem %d = %q, want it to mention %q", i, errs[i], want)
+				}
+			}
+		})
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "out.png")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Check wrote the output: %v", err)
+	}
+}
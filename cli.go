@@ -0,0 +1,442 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// The subcommands of the tool, each taking the flags relevant to it. Flags
+// given without a subcommand keep working, with every flag available, but
+// are deprecated.
+const (
+	commandImage = "image"
+	commandText  = "text"
+	commandBatch = "batch"
+)
+
+// splitCommand splits the subcommand off the front of args. Arguments that
+// start with a flag have no subcommand and are returned unchanged, anything
+// else that is not a known subcommand is an error.
+func splitCommand(args []string) (string, []string, error) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return "", args, nil
+	}
+
+	switch args[0] {
+	case commandImage, commandText, commandBatch:
+		return args[0], args[1:], nil
+	default:
+		return "", nil, fmt.Errorf("unknown command %q, use image, text or batch", args[0])
+	}
+}
+
+// cli holds the flags of one command while they are parsed into a Config.
+type cli struct {
+	fs      *flag.FlagSet
+	command string
+
+	cfg         Config
+	configPath  string
+	check       bool
+	version     bool
+	listFormats bool
+	stampText   string
+
+	watermarkImages, positions                  stringsFlag
+	posX, posY, watermarkHeight, watermarkWidth intsFlag
+	posXPercent, posYPercent                    floatsFlag
+	text                                        WatermarkConfig
+}
+
+// newCLI registers the flags of command, which is commandImage,
+// commandText, commandBatch or empty for the deprecated flat flag set. name
+// is the program name shown in the usage.
+func newCLI(name, command string) *cli {
+	c := &cli{
+		fs:      flag.NewFlagSet(name, flag.ExitOnError),
+		command: command,
+		cfg:     DefaultConfig(),
+		text:    WatermarkConfig{FontSize: defaultFontSize},
+	}
+	if command == commandBatch {
+		c.cfg.Dir = true
+	}
+
+	c.fs.Usage = func() {
+		out := c.fs.Output()
+		if command == "" {
+			fmt.Fprintf(out, "usage: %s image|text|batch [flags]\n\n", name)
+			fmt.Fprintf(out, "  image  watermark an image with -w\n")
+			fmt.Fprintf(out, "  text   watermark an image with -text\n")
+			fmt.Fprintf(out, "  batch  watermark every image of a directory\n\n")
+			fmt.Fprintf(out, "Run %s <command> -h for the flags of a command. Flags given without a\ncommand, deprecated, are:\n", name)
+		} else {
+			fmt.Fprintf(out, "usage: %s %s [flags]\n", name, command)
+		}
+		c.fs.PrintDefaults()
+	}
+
+	c.fs.StringVar(&c.configPath, "config", "", "JSON job file; flags given on the command line override its settings")
+	c.fs.BoolVar(&c.check, "check", false, "validate the job and report every problem without writing the output")
+	c.fs.BoolVar(&c.cfg.Verbose, "v", false, "report which images are read and written, their dimensions and where each watermark is placed")
+	c.fs.BoolVar(&c.cfg.Quiet, "q", false, "do not print warnings")
+	c.fs.BoolVar(&c.cfg.Stats, "stats", false, "print the elapsed time, the largest image and the memory allocated when done")
+	c.fs.BoolVar(&c.version, "version", false, "print the version and build information and exit")
+	c.fs.BoolVar(&c.listFormats, "list-formats", false, "print the image formats that can be read and written and exit")
+
+	switch c.command {
+	case commandBatch:
+		c.fs.StringVar(&c.cfg.Main, "m", "", "directory of the main images")
+		c.fs.StringVar(&c.cfg.Output, "o", "", "output directory, created when missing")
+	case commandImage, commandText:
+		c.fs.StringVar(&c.cfg.Main, "m", "", "main image or http(s) URL, or - for stdin")
+		c.fs.StringVar(&c.cfg.Output, "o", "", "out path, or - for stdout")
+	default:
+		c.fs.StringVar(&c.cfg.Main, "m", "", "main image or http(s) URL, - for stdin, or input directory with -dir")
+		c.fs.StringVar(&c.cfg.Output, "o", "", "out path, - for stdout, or output directory with -dir")
+	}
+	if c.command != commandText {
+		c.fs.Var(&c.watermarkImages, "w", "watermark image or http(s) URL, may be repeated to apply several")
+	}
+	if c.command != commandBatch {
+		c.fs.StringVar(&c.cfg.InFormat, "informat", "", "format of the main image read from stdin")
+	}
+	c.fs.StringVar(&c.cfg.OutFormat, "outformat", "", "format of the output, overriding the extension of -o (default the extension, or the input format for stdout)")
+	c.fs.StringVar(&c.cfg.OutFormat, "format", "", "alias for -outformat")
+	c.fs.BoolVar(&c.cfg.Force, "force", false, "overwrite output files that already exist")
+	c.fs.BoolVar(&c.cfg.PreserveTime, "preservetime", false, "give each output file the modification time of its main image")
+	c.fs.StringVar(&c.cfg.Author, "author", "", "author to write into the metadata of PNG and JPEG output")
+	c.fs.StringVar(&c.cfg.Copyright, "copyright", "", "copyright notice to write into the metadata of PNG and JPEG output")
+	if c.command == "" {
+		c.fs.BoolVar(&c.cfg.Dir, "dir", false, "watermark every image in the -m directory into the -o directory")
+	}
+	c.fs.IntVar(&c.cfg.Page, "page", 0, "page, counted from 0, of a multi-page TIFF main image to watermark")
+	c.fs.IntVar(&c.cfg.MaxDim, "maxdim", c.cfg.MaxDim, "reject images wider or taller than this many pixels, 0 for no limit")
+	if c.command == "" || c.command == commandBatch {
+		c.fs.IntVar(&c.cfg.Workers, "workers", c.cfg.Workers, "number of images to watermark concurrently with -dir")
+	}
+
+	// the placement flags may be repeated: the n-th value applies to the
+	// n-th watermark (each -w in order, then -text) and watermarks without
+	// a value of their own reuse the last one given
+	c.fs.Var(&c.positions, "pos", "anchor position: top-left, top, top-right, left, center, right, bottom-left, bottom, bottom-right, or quiet for the least detailed part of the main image")
+	c.fs.Var(&c.posX, "x", "x position on the main image (offset from -pos when set)")
+	c.fs.Var(&c.posY, "y", "y position on the main image (offset from -pos when set)")
+	c.fs.Var(&c.posXPercent, "xpct", "x position as a percentage from 0 to 100 of the main image width, added to -x")
+	c.fs.Var(&c.posYPercent, "ypct", "y position as a percentage from 0 to 100 of the main image height, added to -y")
+	c.fs.BoolVar(&c.cfg.Center, "center", false, "treat -x/-y and -pos as the center of the watermark instead of its edges")
+	c.fs.IntVar(&c.cfg.Margin, "margin", 0, "inset in pixels of a -pos watermark from the image edges")
+	c.fs.IntVar(&c.cfg.MarginX, "marginx", c.cfg.MarginX, "horizontal inset for -pos, overrides -margin")
+	c.fs.IntVar(&c.cfg.MarginY, "marginy", c.cfg.MarginY, "vertical inset for -pos, overrides -margin")
+	c.fs.Var(&c.watermarkHeight, "height", "height of watermark (0 keeps the aspect ratio of -width)")
+	c.fs.Var(&c.watermarkWidth, "width", "width of watermark (0 keeps the aspect ratio of -height)")
+	c.fs.Float64Var(&c.cfg.Scale, "scale", 0, "width of watermarks without -height or -width as a fraction of the main image width, e.g. 0.25")
+	c.fs.BoolVar(&c.cfg.NoResize, "noresize", false, "never resize watermarks and fail when one is larger than the main image")
+	c.fs.BoolVar(&c.cfg.Upscale, "upscale", false, "also enlarge watermarks smaller than -height or -width")
+	c.fs.StringVar(&c.cfg.Resample, "resample", c.cfg.Resample, "resize sampling: nearest, bilinear or lanczos")
+	c.fs.Float64Var(&c.cfg.Rotate, "rotate", 0, "rotate the watermark clockwise by this many degrees")
+	c.fs.BoolVar(&c.cfg.Flip, "flip", false, "mirror the watermark top to bottom")
+	c.fs.BoolVar(&c.cfg.Flop, "flop", false, "mirror the watermark left to right")
+	c.fs.StringVar(&c.cfg.Tint, "tint", "", "recolor every watermark to this #RRGGBB color, keeping its transparency")
+	c.fs.StringVar(&c.cfg.ColorKey, "colorkey", "", "make the pixels of every watermark of this #RRGGBB color transparent, for watermarks without alpha such as JPEG logos")
+	c.fs.IntVar(&c.cfg.ColorKeyTolerance, "colorkeytolerance", 0, "how far from 0 to 255 each channel may differ from -colorkey and still be made transparent")
+	c.fs.BoolVar(&c.cfg.AutoColor, "autocolor", false, "recolor every watermark black or white, whichever stands out more from the area it covers")
+	c.fs.BoolVar(&c.cfg.Tile, "tile", false, "repeat the watermark across the whole main image")
+	c.fs.BoolVar(&c.cfg.StripRow, "striprow", false, "repeat the watermark across the row it is placed on")
+	c.fs.BoolVar(&c.cfg.StripCol, "stripcol", false, "repeat the watermark down the column it is placed on")
+	c.fs.IntVar(&c.cfg.Gap, "gap", 0, "spacing in pixels between tiles with -tile, -striprow or -stripcol")
+	c.fs.BoolVar(&c.cfg.Stagger, "stagger", false, "shift every other row of -tile or -stamp by half a tile, like bricks")
+	c.fs.IntVar(&c.cfg.Feather, "feather", 0, "fade the watermark out over this many pixels towards its edges")
+	c.fs.BoolVar(&c.cfg.Shadow, "shadow", false, "draw a soft drop shadow behind the watermark")
+	c.fs.IntVar(&c.cfg.ShadowOffset, "shadowoffset", c.cfg.ShadowOffset, "distance in pixels of the -shadow down and to the right")
+	c.fs.Float64Var(&c.cfg.ShadowOpacity, "shadowopacity", c.cfg.ShadowOpacity, "opacity of the -shadow from 0.0 to 1.0")
+	c.fs.Float64Var(&c.cfg.Opacity, "opacity", c.cfg.Opacity, "watermark opacity from 0.0 to 1.0")
+	c.fs.IntVar(&c.cfg.Threshold, "threshold", 0, "skip watermark pixels with an alpha below this value from 0 to 255, for hard edges")
+	c.fs.IntVar(&c.cfg.Quality, "quality", c.cfg.Quality, "JPEG and WebP output quality from 1 to 100")
+	c.fs.StringVar(&c.cfg.PNGCompression, "pngcompression", c.cfg.PNGCompression, "PNG compression: default, none, speed or best")
+	c.fs.StringVar(&c.cfg.Subsampling, "subsampling", c.cfg.Subsampling, "JPEG chroma subsampling: 420, or 444 for sharper colored edges")
+	c.fs.BoolVar(&c.cfg.Progressive, "progressive", false, "write JPEG output as a progressive JPEG, always with 444 subsampling")
+	c.fs.BoolVar(&c.cfg.Grayscale, "grayscale", false, "convert the main image to grayscale before watermarking")
+	c.fs.Float64Var(&c.cfg.Brightness, "brightness", 0, "brighten the main image by this amount from -1.0 to 1.0 before watermarking")
+	c.fs.Float64Var(&c.cfg.Contrast, "contrast", 0, "change the contrast of the main image by this amount from -1.0 to 1.0 before watermarking")
+	c.fs.BoolVar(&c.cfg.Dither, "dither", false, "add a faint dither pattern over the watermarked region to make it harder to retouch")
+	c.fs.Int64Var(&c.cfg.DitherSeed, "ditherseed", 0, "seed choosing the -dither pattern")
+	c.fs.BoolVar(&c.cfg.PreserveDepth, "preservedepth", false, "keep 16 bits per channel for 16-bit images such as 16-bit PNGs")
+	c.fs.BoolVar(&c.cfg.LinearBlend, "linearblend", false, "blend the watermark in linear light, which keeps translucent edges from darkening")
+	c.fs.BoolVar(&c.cfg.OnlyBright, "onlybright", false, "fade the watermark by the brightness under it so it only shows on light areas")
+	c.fs.BoolVar(&c.cfg.OnlyDark, "onlydark", false, "fade the watermark by the darkness under it so it only shows on dark areas")
+	c.fs.StringVar(&c.cfg.Mask, "mask", "", "grayscale image stretched over the main image: the watermark shows where it is white and not where it is black")
+	c.fs.StringVar(&c.cfg.BlendMode, "blendmode", c.cfg.BlendMode, "how the watermark colors combine with the image: source-over, multiply, screen or overlay")
+	c.fs.StringVar(&c.cfg.Background, "background", "", "color as #RRGGBB to flatten transparent areas onto when writing JPEG (default black)")
+	c.fs.IntVar(&c.cfg.CropX, "cropx", 0, "left edge of the region of the main image to keep")
+	c.fs.IntVar(&c.cfg.CropY, "cropy", 0, "top edge of the region of the main image to keep")
+	c.fs.IntVar(&c.cfg.CropW, "cropw", 0, "width of the region of the main image to keep, crops when -cropw or -croph is set")
+	c.fs.IntVar(&c.cfg.CropH, "croph", 0, "height of the region of the main image to keep, crops when -cropw or -croph is set")
+	c.fs.IntVar(&c.cfg.Radius, "radius", 0, "round the corners of the output with this radius in pixels, filled with -background in JPEG output")
+	c.fs.IntVar(&c.cfg.ThumbWidth, "thumbwidth", 0, "resize the output to this width, keeping the aspect ratio unless -thumbheight is set too")
+	c.fs.IntVar(&c.cfg.ThumbHeight, "thumbheight", 0, "resize the output to this height, keeping the aspect ratio unless -thumbwidth is set too")
+	c.fs.BoolVar(&c.cfg.Compare, "compare", false, "write the original and the watermarked image side by side, to review the watermark")
+
+	if c.command != commandImage {
+		c.fs.StringVar(&c.text.Text, "text", "", "text to use as a watermark, in addition to or instead of -w")
+	}
+	c.fs.StringVar(&c.text.Font, "font", "", "path to a TTF/OTF font for -text and -stamp (default Go Regular)")
+	c.fs.Float64Var(&c.text.FontSize, "fontsize", c.text.FontSize, "font size in points for -text and -stamp")
+	c.fs.Float64Var(&c.cfg.DPI, "dpi", 0, "resolution the -fontsize points are rendered at, e.g. 300 for print (default 72)")
+	c.fs.StringVar(&c.text.Color, "color", "", "text color as #RRGGBB or #RRGGBBAA (default #FFFFFF)")
+	if c.command != commandImage {
+		c.fs.StringVar(&c.text.Stroke, "stroke", "", "outline color for -text as #RRGGBB or #RRGGBBAA")
+		c.fs.IntVar(&c.text.StrokeWidth, "strokewidth", defaultStrokeWidth, "outline width in pixels for -text when -stroke is set")
+		c.fs.StringVar(&c.text.BadgeColor, "badgecolor", "", "draw -text on a rounded box of this color as #RRGGBB or #RRGGBBAA, e.g. #00000080")
+		c.fs.IntVar(&c.text.BadgePadding, "badgepadding", defaultBadgePadding, "padding in pixels around -text when -badgecolor is set")
+	}
+	c.fs.StringVar(&c.stampText, "stamp", "", "text to repeat diagonally across the main image like a preview stamp, styled by -font, -fontsize and -color")
+	c.fs.Float64Var(&c.cfg.StampAngle, "stampangle", DefaultStampOptions().Angle, "clockwise rotation in degrees of the -stamp text")
+	c.fs.IntVar(&c.cfg.StampSpacingX, "stampspacingx", DefaultStampOptions().Gap, "spacing in pixels between repetitions of the -stamp text")
+	c.fs.IntVar(&c.cfg.StampSpacingY, "stampspacingy", DefaultStampOptions().GapY, "spacing in pixels between rows of the -stamp text, the same as -stampspacingx when negative")
+
+	return c
+}
+
+// parse parses args and returns the job they describe, merged with the
+// -config file when one is given.
+func (c *cli) parse(args []string) (*Config, error) {
+	err := c.fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range c.watermarkImages {
+		c.cfg.Watermarks = append(c.cfg.Watermarks, WatermarkConfig{Image: path})
+	}
+	if c.text.Text != "" {
+		c.cfg.Watermarks = append(c.cfg.Watermarks, c.text)
+	}
+	if c.stampText != "" {
+		c.cfg.Stamp = &WatermarkConfig{Text: c.stampText}
+	}
+
+	if c.configPath != "" {
+		fileCfg, err := LoadConfig(c.configPath)
+		if err != nil {
+			return nil, err
+		}
+
+		// command-line flags take precedence over the config file
+		c.fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "m":
+				fileCfg.Main = c.cfg.Main
+			case "o":
+				fileCfg.Output = c.cfg.Output
+			case "w", "text":
+				fileCfg.Watermarks = c.cfg.Watermarks
+			case "stamp":
+				fileCfg.Stamp = c.cfg.Stamp
+			case "dpi":
+				fileCfg.DPI = c.cfg.DPI
+			case "stampangle":
+				fileCfg.StampAngle = c.cfg.StampAngle
+			case "stampspacingx":
+				fileCfg.StampSpacingX = c.cfg.StampSpacingX
+			case "stampspacingy":
+				fileCfg.StampSpacingY = c.cfg.StampSpacingY
+			case "informat":
+				fileCfg.InFormat = c.cfg.InFormat
+			case "outformat", "format":
+				fileCfg.OutFormat = c.cfg.OutFormat
+			case "force":
+				fileCfg.Force = c.cfg.Force
+			case "preservetime":
+				fileCfg.PreserveTime = c.cfg.PreserveTime
+			case "author":
+				fileCfg.Author = c.cfg.Author
+			case "copyright":
+				fileCfg.Copyright = c.cfg.Copyright
+			case "dir":
+				fileCfg.Dir = c.cfg.Dir
+			case "workers":
+				fileCfg.Workers = c.cfg.Workers
+			case "page":
+				fileCfg.Page = c.cfg.Page
+			case "maxdim":
+				fileCfg.MaxDim = c.cfg.MaxDim
+			case "center":
+				fileCfg.Center = c.cfg.Center
+			case "margin":
+				fileCfg.Margin = c.cfg.Margin
+			case "marginx":
+				fileCfg.MarginX = c.cfg.MarginX
+			case "marginy":
+				fileCfg.MarginY = c.cfg.MarginY
+			case "resample":
+				fileCfg.Resample = c.cfg.Resample
+			case "rotate":
+				fileCfg.Rotate = c.cfg.Rotate
+			case "flip":
+				fileCfg.Flip = c.cfg.Flip
+			case "flop":
+				fileCfg.Flop = c.cfg.Flop
+			case "tint":
+				fileCfg.Tint = c.cfg.Tint
+			case "autocolor":
+				fileCfg.AutoColor = c.cfg.AutoColor
+			case "colorkey":
+				fileCfg.ColorKey = c.cfg.ColorKey
+			case "colorkeytolerance":
+				fileCfg.ColorKeyTolerance = c.cfg.ColorKeyTolerance
+			case "scale":
+				fileCfg.Scale = c.cfg.Scale
+			case "noresize":
+				fileCfg.NoResize = c.cfg.NoResize
+			case "upscale":
+				fileCfg.Upscale = c.cfg.Upscale
+			case "tile":
+				fileCfg.Tile = c.cfg.Tile
+			case "striprow":
+				fileCfg.StripRow = c.cfg.StripRow
+			case "stripcol":
+				fileCfg.StripCol = c.cfg.StripCol
+			case "gap":
+				fileCfg.Gap = c.cfg.Gap
+			case "stagger":
+				fileCfg.Stagger = c.cfg.Stagger
+			case "feather":
+				fileCfg.Feather = c.cfg.Feather
+			case "shadow":
+				fileCfg.Shadow = c.cfg.Shadow
+			case "shadowoffset":
+				fileCfg.ShadowOffset = c.cfg.ShadowOffset
+			case "shadowopacity":
+				fileCfg.ShadowOpacity = c.cfg.ShadowOpacity
+			case "opacity":
+				fileCfg.Opacity = c.cfg.Opacity
+			case "threshold":
+				fileCfg.Threshold = c.cfg.Threshold
+			case "quality":
+				fileCfg.Quality = c.cfg.Quality
+			case "pngcompression":
+				fileCfg.PNGCompression = c.cfg.PNGCompression
+			case "subsampling":
+				fileCfg.Subsampling = c.cfg.Subsampling
+			case "progressive":
+				fileCfg.Progressive = c.cfg.Progressive
+			case "grayscale":
+				fileCfg.Grayscale = c.cfg.Grayscale
+			case "brightness":
+				fileCfg.Brightness = c.cfg.Brightness
+			case "contrast":
+				fileCfg.Contrast = c.cfg.Contrast
+			case "dither":
+				fileCfg.Dither = c.cfg.Dither
+			case "ditherseed":
+				fileCfg.DitherSeed = c.cfg.DitherSeed
+			case "preservedepth":
+				fileCfg.PreserveDepth = c.cfg.PreserveDepth
+			case "linearblend":
+				fileCfg.LinearBlend = c.cfg.LinearBlend
+			case "blendmode":
+				fileCfg.BlendMode = c.cfg.BlendMode
+			case "onlybright":
+				fileCfg.OnlyBright = c.cfg.OnlyBright
+			case "onlydark":
+				fileCfg.OnlyDark = c.cfg.OnlyDark
+			case "mask":
+				fileCfg.Mask = c.cfg.Mask
+			case "background":
+				fileCfg.Background = c.cfg.Background
+			case "cropx":
+				fileCfg.CropX = c.cfg.CropX
+			case "cropy":
+				fileCfg.CropY = c.cfg.CropY
+			case "cropw":
+				fileCfg.CropW = c.cfg.CropW
+			case "croph":
+				fileCfg.CropH = c.cfg.CropH
+			case "compare":
+				fileCfg.Compare = c.cfg.Compare
+			case "radius":
+				fileCfg.Radius = c.cfg.Radius
+			case "thumbwidth":
+				fileCfg.ThumbWidth = c.cfg.ThumbWidth
+			case "thumbheight":
+				fileCfg.ThumbHeight = c.cfg.ThumbHeight
+			case "v":
+				fileCfg.Verbose = c.cfg.Verbose
+			case "q":
+				fileCfg.Quiet = c.cfg.Quiet
+			case "stats":
+				fileCfg.Stats = c.cfg.Stats
+			}
+		})
+		c.cfg = *fileCfg
+	}
+
+	// placement and text flags override the matching setting of every
+	// watermark, whether it came from the command line or the config file
+	c.fs.Visit(func(f *flag.Flag) {
+		for i := range c.cfg.Watermarks {
+			wm := &c.cfg.Watermarks[i]
+			switch f.Name {
+			case "pos":
+				wm.Position = c.positions.at(i, "")
+			case "x":
+				wm.X = c.posX.at(i, 0)
+			case "y":
+				wm.Y = c.posY.at(i, 0)
+			case "xpct":
+				wm.XPercent = c.posXPercent.at(i, 0)
+			case "ypct":
+				wm.YPercent = c.posYPercent.at(i, 0)
+			case "height":
+				wm.Height = c.watermarkHeight.at(i, 0)
+			case "width":
+				wm.Width = c.watermarkWidth.at(i, 0)
+			case "font":
+				wm.Font = c.text.Font
+			case "fontsize":
+				wm.FontSize = c.text.FontSize
+			case "color":
+				wm.Color = c.text.Color
+			case "stroke":
+				wm.Stroke = c.text.Stroke
+			case "strokewidth":
+				wm.StrokeWidth = c.text.StrokeWidth
+			case "badgecolor":
+				wm.BadgeColor = c.text.BadgeColor
+			case "badgepadding":
+				wm.BadgePadding = c.text.BadgePadding
+			}
+		}
+
+		if c.cfg.Stamp != nil {
+			switch f.Name {
+			case "font":
+				c.cfg.Stamp.Font = c.text.Font
+			case "fontsize":
+				c.cfg.Stamp.FontSize = c.text.FontSize
+			case "color":
+				c.cfg.Stamp.Color = c.text.Color
+			}
+		}
+	})
+
+	if c.command == commandBatch {
+		c.cfg.Dir = true
+	}
+
+	return &c.cfg, nil
+}
+
+// required returns the flags of which at least one has to be given.
+func (c *cli) required() string {
+	switch c.command {
+	case commandImage:
+		return "-w or -stamp"
+	case commandText:
+		return "-text or -stamp"
+	default:
+		return "-w, -text or -stamp"
+	}
+}
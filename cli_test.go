@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		command string
+		rest    []string
+		wantErr bool
+	}{
+		{"image", []string{"image", "-m", "in.png"}, commandImage, []string{"-m", "in.png"}, false},
+		{"text", []string{"text", "-text", "hi"}, commandText, []string{"-text", "hi"}, false},
+		{"batch", []string{"batch", "-m", "in"}, commandBatch, []string{"-m", "in"}, false},
+		{"flat flags", []string{"-m", "in.png", "-w", "wm.png"}, "", []string{"-m", "in.png", "-w", "wm.png"}, false},
+		{"no arguments", nil, "", nil, false},
+		{"unknown command", []string{"resize", "-m", "in.png"}, "", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			command, rest, err := splitCommand(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("splitCommand(%q) error = %v, want error %v", tt.args, err, tt.wantErr)
+			}
+			if command != tt.command {
+				t.Errorf("command = %q, want %q", command, tt.command)
+			}
+			if !reflect.DeepEqual(rest, tt.rest) {
+				t.Errorf("rest = %q, want %q", rest, tt.rest)
+			}
+		})
+	}
+}
+
+func TestCLIParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		args    []string
+		wantErr bool
+		check   func(t *testing.T, cfg *Config)
+	}{
+		{
+			name:    "image",
+			command: commandImage,
+			args:    []string{"-m", "in.png", "-o", "out.png", "-w", "wm.png", "-pos", "bottom-right"},
+			check: func(t *testing.T, cfg *Config) {
+				if len(cfg.Watermarks) != 1 || cfg.Watermarks[0].Image != "wm.png" || cfg.Watermarks[0].Position != "bottom-right" {
+					t.Errorf("watermarks = %+v, want wm.png at bottom-right", cfg.Watermarks)
+				}
+				if cfg.Dir {
+					t.Error("image command set Dir")
+				}
+			},
+		},
+		{
+			name:    "image has no -text",
+			command: commandImage,
+			args:    []string{"-m", "in.png", "-o", "out.png", "-text", "hi"},
+			wantErr: true,
+		},
+		{
+			name:    "text",
+			command: commandText,
+			args:    []string{"-m", "in.png", "-o", "out.png", "-text", "hi", "-fontsize", "30"},
+			check: func(t *testing.T, cfg *Config) {
+				if len(cfg.Watermarks) != 1 || cfg.Watermarks[0].Text != "hi" || cfg.Watermarks[0].FontSize != 30 {
+					t.Errorf("watermarks = %+v, want text hi at size 30", cfg.Watermarks)
+				}
+			},
+		},
+		{
+			name:    "text has no -w",
+			command: commandText,
+			args:    []string{"-m", "in.png", "-o", "out.png", "-w", "wm.png"},
+			wantErr: true,
+		},
+		{
+			name:    "batch",
+			command: commandBatch,
+			args:    []string{"-m", "in", "-o", "out", "-w", "wm.png", "-workers", "4"},
+			check: func(t *testing.T, cfg *Config) {
+				if !cfg.Dir || cfg.Workers != 4 {
+					t.Errorf("Dir = %v, Workers = %d, want true and 4", cfg.Dir, cfg.Workers)
+				}
+			},
+		},
+		{
+			name:    "batch has no -dir",
+			command: commandBatch,
+			args:    []string{"-m", "in", "-o", "out", "-dir"},
+			wantErr: true,
+		},
+		{
+			name: "deprecated flat flags",
+			args: []string{"-m", "in", "-o", "out", "-w", "wm.png", "-text", "hi", "-dir"},
+			check: func(t *testing.T, cfg *Config) {
+				if len(cfg.Watermarks) != 2 || !cfg.Dir {
+					t.Errorf("watermarks = %+v, Dir = %v, want an image and a text watermark with Dir", cfg.Watermarks, cfg.Dir)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCLI("wm", tt.command)
+			c.fs.Init("wm", flag.ContinueOnError)
+			c.fs.SetOutput(io.Discard)
+
+			cfg, err := c.parse(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parse(%q) error = %v, want error %v", tt.args, err, tt.wantErr)
+			}
+			if err == nil && tt.check != nil {
+				tt.check(t, cfg)
+			}
+		})
+	}
+}
+
+func TestCLIConfig(t *testing.T) {
+	dir := t.TempDir()
+	in, wm := filepath.Join(dir, "in.png"), filepath.Join(dir, "wm.png")
+	err := SaveImage(image.NewGray(image.Rect(0, 0, 20, 10)), in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	red := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	draw.Draw(red, red.Rect, image.NewUniform(color.NRGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+	err = SaveImage(red, wm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := filepath.Join(dir, "job.json")
+	job := `{
+		"m": "` + in + `",
+		"o": "` + filepath.Join(dir, "from-config.png") + `",
+		"watermarks": [{"w": "` + wm + `", "pos": "bottom-right"}],
+		"opacity": 0.5
+	}`
+	err = os.WriteFile(config, []byte(job), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		out  string
+		want color.NRGBA
+	}{
+		{"config only", []string{"-config", config}, "from-config.png", color.NRGBA{127, 0, 0, 255}},
+		{"flags override", []string{"-config", config, "-o", filepath.Join(dir, "from-flags.png"), "-opacity", "1"}, "from-flags.png", color.NRGBA{255, 0, 0, 255}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCLI("wm", commandImage)
+			c.fs.Init("wm", flag.ContinueOnError)
+			c.fs.SetOutput(io.Discard)
+
+			cfg, err := c.parse(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = cfg.Run()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			out, err := ReadImage(filepath.Join(dir, tt.out))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := color.NRGBAModel.Convert(out.At(18, 8)); got != tt.want {
+				t.Errorf("watermarked pixel = %v, want %v", got, tt.want)
+			}
+			if got, want := color.NRGBAModel.Convert(out.At(10, 2)), (color.NRGBA{0, 0, 0, 255}); got != want {
+				t.Errorf("pixel off the watermark = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// TestMain runs the command itself instead of the tests when WM_TEST_MAIN
+// holds its arguments, for runMain.
+func TestMain(m *testing.M) {
+	if args, ok := os.LookupEnv("WM_TEST_MAIN"); ok {
+		os.Args = append([]string{"wm"}, strings.Fields(args)...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runMain runs the command with args split at spaces and returns what it
+// wrote to stdout and stderr.
+func runMain(t *testing.T, args string) (string, string) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), "WM_TEST_MAIN="+args)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	if err != nil {
+		t.Fatalf("wm %s: %v: %s", args, err, stderr.String())
+	}
+	return stdout.String(), stderr.String()
+}
+
+func TestVersionFlag(t *testing.T) {
+	for _, args := range []string{"-version", "image -version"} {
+		t.Run(args, func(t *testing.T) {
+			out, _ := runMain(t, args)
+			if !strings.Contains(out, "built with go") {
+				t.Errorf("wm %s printed %q, want the Go version it was built with", args, out)
+			}
+			if first, _, _ := strings.Cut(out, "\n"); len(strings.Fields(first)) != 2 {
+				t.Errorf("wm %s began with %q, want the module path and version", args, first)
+			}
+		})
+	}
+}
+
+func TestVerboseQuiet(t *testing.T) {
+	dir := t.TempDir()
+	in, wm, out := filepath.Join(dir, "in.png"), filepath.Join(dir, "wm.png"), filepath.Join(dir, "out.png")
+	err := SaveImage(image.NewGray(image.Rect(0, 0, 20, 10)), in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SaveImage(redSquare(4), wm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := "-m " + in + " -w " + wm + " -pos bottom-right -force -o " + out
+
+	tests := []struct {
+		name string
+		args string
+		want []string
+		not  []string
+	}{
+		{
+			"verbose",
+			"image -v " + job,
+			[]string{"read " + in + ": 20x10 png", "placing watermark at (16,6)-(20,10)", "wrote " + out + ": 20x10 png"},
+			nil,
+		},
+		{"default", "image " + job, nil, []string{"info:"}},
+		{"deprecated flags warn", job, []string{"warning: flags without a command are deprecated"}, nil},
+		{"quiet", "-q " + job, nil, []string{"warning:"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, stderr := runMain(t, tt.args)
+			for _, want := range tt.want {
+				if !strings.Contains(stderr, want) {
+					t.Errorf("stderr %q does not report %q", stderr, want)
+				}
+			}
+			for _, not := range tt.not {
+				if strings.Contains(stderr, not) {
+					t.Errorf("stderr %q reports %q", stderr, not)
+				}
+			}
+		})
+	}
+}
+
+func TestStatsFlag(t *testing.T) {
+	dir := t.TempDir()
+	in, wm := filepath.Join(dir, "in.png"), filepath.Join(dir, "wm.png")
+	err := SaveImage(image.NewGray(image.Rect(0, 0, 30, 20)), in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SaveImage(redSquare(4), wm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, stderr := runMain(t, "image -stats -m "+in+" -w "+wm+" -o "+filepath.Join(dir, "out.png"))
+	stats := regexp.MustCompile(`stats: elapsed [0-9.]+(ns|µs|ms|s|m[0-9.]+s), peak image 30x20, allocated [0-9.]+ MiB`)
+	if !stats.MatchString(stderr) {
+		t.Errorf("stderr %q does not report the elapsed time, peak image and memory", stderr)
+	}
+}
+
+func TestListFormatsFlag(t *testing.T) {
+	out, _ := runMain(t, "-list-formats")
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	for _, want := range []string{"jpg", "png", "gif", "svg (read only)"} {
+		found := false
+		for _, line := range lines {
+			found = found || line == want
+		}
+		if !found {
+			t.Errorf("wm -list-formats printed %q, want a line %q", lines, want)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/gif"
+	"image/png"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
+)
+
+// decoder reads images of one format. decode also returns the metadata of
+// the formats that carry any. config reads the size from the header, and
+// is nil for decoders registered with RegisterDecoder, which only give
+// whole images. prepare, when set, turns the data into what decode and
+// config read with the options applied, such as the page of a TIFF.
+type decoder struct {
+	decode  func(r io.Reader) (image.Image, *Metadata, error)
+	config  func(r io.Reader) (image.Config, error)
+	prepare func(r io.Reader, o *options) (io.Reader, error)
+}
+
+// encoder writes images of one format with the options that apply to it.
+type encoder func(w io.Writer, img image.Image, o *options) error
+
+// codecs holds the decoders and encoders, built in or registered by
+// callers, by lowercase extension without the dot.
+var codecs = struct {
+	sync.RWMutex
+	decoders map[string]decoder
+	encoders map[string]encoder
+}{
+	decoders: map[string]decoder{},
+	encoders: map[string]encoder{},
+}
+
+func init() {
+	jpegDecoder := decoder{decode: decodeJPEG, config: decodeJPEGConfig}
+	tiffDecoder := decoder{decode: plainDecode(tiff.Decode), config: tiff.DecodeConfig, prepare: selectTIFFPage}
+
+	registerDecoder("jpg", jpegDecoder)
+	registerDecoder("jpeg", jpegDecoder)
+	registerDecoder("png", decoder{decode: decodePNG, config: png.DecodeConfig})
+	registerDecoder("gif", decoder{decode: plainDecode(gif.Decode), config: gif.DecodeConfig})
+	registerDecoder("webp", decoder{decode: plainDecode(webp.Decode), config: webp.DecodeConfig})
+	registerDecoder("tif", tiffDecoder)
+	registerDecoder("tiff", tiffDecoder)
+	registerDecoder("bmp", decoder{decode: plainDecode(bmp.Decode), config: bmp.DecodeConfig})
+	registerDecoder("svg", decoder{decode: plainDecode(decodeSVG), config: decodeSVGConfig})
+	// registered without the avif tag too, so reading one says what is
+	// missing
+	registerDecoder("avif", decoder{decode: plainDecode(decodeAVIF), config: decodeAVIFConfig})
+
+	tiffEncoder := plainEncode(func(w io.Writer, img image.Image) error {
+		return tiff.Encode(w, img, nil)
+	})
+
+	registerEncoder("jpg", encodeJPEGWith)
+	registerEncoder("jpeg", encodeJPEGWith)
+	registerEncoder("png", func(w io.Writer, img image.Image, o *options) error {
+		encoder := png.Encoder{CompressionLevel: o.pngCompression}
+		return encodePNG(w, img, &encoder, o.metadata.withText(o.author, o.copyright))
+	})
+	registerEncoder("gif", plainEncode(func(w io.Writer, img image.Image) error {
+		return gif.Encode(w, img, nil)
+	}))
+	registerEncoder("webp", func(w io.Writer, img image.Image, o *options) error {
+		err := checkQuality(o.quality)
+		if err != nil {
+			return err
+		}
+		return encodeWebP(w, img, o.quality)
+	})
+	registerEncoder("tif", tiffEncoder)
+	registerEncoder("tiff", tiffEncoder)
+	registerEncoder("bmp", plainEncode(bmp.Encode))
+}
+
+// encodeJPEGWith encodes img as a JPEG with the quality, subsampling,
+// progressive, background and metadata options.
+func encodeJPEGWith(w io.Writer, img image.Image, o *options) error {
+	err := checkQuality(o.quality)
+	if err != nil {
+		return err
+	}
+	if o.background != nil {
+		img = Flatten(img, o.background)
+	}
+	return encodeJPEG(w, img, o.quality, o.subsampling, o.progressive, o.metadata.withText(o.author, o.copyright))
+}
+
+// checkQuality checks a WithQuality value.
+func checkQuality(quality int) error {
+	if quality < 1 || quality > 100 {
+		return fmt.Errorf("quality %d must be between 1 and 100", quality)
+	}
+	return nil
+}
+
+// RegisterDecoder makes ReadImage and ReadImageFrom decode images of the
+// format ext, such as "foo" or ".foo", with fn. Registering again for the
+// same format replaces the earlier decoder, and registering a built-in
+// format replaces the built-in decoder, so the metadata and EXIF
+// orientation it reads are lost.
+func RegisterDecoder(ext string, fn func(io.Reader) (image.Image, error)) {
+	registerDecoder(ext, decoder{decode: plainDecode(fn)})
+}
+
+// RegisterEncoder makes SaveImage and WriteImageTo encode images of the
+// format ext, such as "foo" or ".foo", with fn, replacing the encoder
+// registered or built in for it before. It ignores options such as
+// WithQuality that only the built-in encoders know about.
+func RegisterEncoder(ext string, fn func(io.Writer, image.Image) error) {
+	registerEncoder(ext, plainEncode(fn))
+}
+
+// registerDecoder adds dec to the decoders for the format ext.
+func registerDecoder(ext string, dec decoder) {
+	codecs.Lock()
+	defer codecs.Unlock()
+	codecs.decoders[codecKey(ext)] = dec
+}
+
+// registerEncoder adds enc to the encoders for the format ext.
+func registerEncoder(ext string, enc encoder) {
+	codecs.Lock()
+	defer codecs.Unlock()
+	codecs.encoders[codecKey(ext)] = enc
+}
+
+// registeredDecoder returns the decoder for format, if any.
+func registeredDecoder(format string) (decoder, bool) {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	dec, ok := codecs.decoders[codecKey(format)]
+	return dec, ok
+}
+
+// registeredEncoder returns the encoder for format, if any.
+func registeredEncoder(format string) (encoder, bool) {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	enc, ok := codecs.encoders[codecKey(format)]
+	return enc, ok
+}
+
+// plainDecode adapts a decoder of images without metadata.
+func plainDecode(fn func(io.Reader) (image.Image, error)) func(io.Reader) (image.Image, *Metadata, error) {
+	return func(r io.Reader) (image.Image, *Metadata, error) {
+		img, err := fn(r)
+		return img, nil, err
+	}
+}
+
+// plainEncode adapts an encoder without options.
+func plainEncode(fn func(io.Writer, image.Image) error) encoder {
+	return func(w io.Writer, img image.Image, o *options) error {
+		return fn(w, img)
+	}
+}
+
+// codecKey normalizes an extension or format name for the codec maps.
+func codecKey(ext string) string {
+	return strings.ToLower(strings.TrimPrefix(ext, "."))
+}
+
+// SupportedFormats returns the extensions, without the dot, of the image
+// formats that can be read or written, by the built-in codecs or ones
+// registered with RegisterDecoder and RegisterEncoder, in sorted order.
+// svg and avif can only be read, avif only in builds with the avif tag.
+func SupportedFormats() []string {
+	seen := map[string]bool{}
+
+	codecs.RLock()
+	for format := range codecs.decoders {
+		seen[format] = true
+	}
+	for format := range codecs.encoders {
+		seen[format] = true
+	}
+	codecs.RUnlock()
+
+	if !avifSupported {
+		delete(seen, "avif")
+	}
+
+	formats := make([]string, 0, len(seen))
+	for format := range seen {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
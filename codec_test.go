@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// encodeFoo writes img as the width and height followed by the NRGBA
+// pixels, a format only the tests know.
+func encodeFoo(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	nrgba := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			nrgba.Set(x, y, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	err := binary.Write(w, binary.BigEndian, [2]uint32{uint32(b.Dx()), uint32(b.Dy())})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(nrgba.Pix)
+	return err
+}
+
+// decodeFoo reads an image written by encodeFoo.
+func decodeFoo(r io.Reader) (image.Image, error) {
+	var size [2]uint32
+	err := binary.Read(r, binary.BigEndian, &size)
+	if err != nil {
+		return nil, err
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, int(size[0]), int(size[1])))
+	_, err = io.ReadFull(r, img.Pix)
+	return img, err
+}
+
+func TestRegisteredCodecRoundTrip(t *testing.T) {
+	RegisterDecoder(".foo", decodeFoo)
+	RegisterEncoder("foo", encodeFoo)
+
+	img := image.NewNRGBA(image.Rect(0, 0, 5, 3))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 17)
+	}
+
+	// the extension is matched like the built-in ones, in any case
+	path := filepath.Join(t.TempDir(), "round.FOO")
+	err := SaveImage(img, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := ReadImageConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Width != 5 || config.Height != 3 {
+		t.Errorf("config size = %dx%d, want 5x3", config.Width, config.Height)
+	}
+
+	got, err := ReadImage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Bounds() != img.Rect {
+		t.Fatalf("bounds = %v, want %v", got.Bounds(), img.Rect)
+	}
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 5; x++ {
+			if g, w := color.NRGBAModel.Convert(got.At(x, y)), img.NRGBAAt(x, y); g != w {
+				t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, g, w)
+			}
+		}
+	}
+
+	if !isSupportedFormat("foo") {
+		t.Error("foo is not a supported format")
+	}
+	var listed bool
+	for _, format := range SupportedFormats() {
+		listed = listed || format == "foo"
+	}
+	if !listed {
+		t.Errorf("SupportedFormats() = %q, want foo in it", SupportedFormats())
+	}
+}
+
+func TestBuiltinCodecsRegistered(t *testing.T) {
+	for _, format := range []string{"jpg", "jpeg", "png", "gif", "webp", "tif", "tiff", "bmp"} {
+		if !isSupportedFormat(format) {
+			t.Errorf("%s cannot be both read and written", format)
+		}
+	}
+	for _, format := range []string{"svg", "avif"} {
+		if !isReadableFormat(format) || isWritableFormat(format) {
+			t.Errorf("%s is not read only", format)
+		}
+	}
+}
+
+// noiseImage returns an opaque w x h image of noise, which compresses
+// differently enough at every setting to tell them apart.
+func noiseImage(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	state := uint64(1)
+	for i := range img.Pix {
+		img.Pix[i] = uint8(splitmix64(&state))
+	}
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 255
+	}
+	return img
+}
+
+func TestJPEGQuality(t *testing.T) {
+	img := noiseImage(64, 64)
+
+	size := func(quality int) int {
+		var buf bytes.Buffer
+		err := WriteImageTo(&buf, img, "jpg", WithQuality(quality))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf.Len()
+	}
+
+	low, high := size(20), size(95)
+	if low >= high {
+		t.Errorf("quality 20 gave %d bytes and 95 gave %d, want fewer at 20", low, high)
+	}
+
+	for _, quality := range []int{0, 101} {
+		err := WriteImageTo(io.Discard, img, "jpg", WithQuality(quality))
+		if err == nil {
+			t.Errorf("quality %d was accepted", quality)
+		}
+	}
+}
+
+func TestLosslessRoundTrip(t *testing.T) {
+	// opaque, since BMP has no alpha
+	img := noiseImage(13, 7)
+
+	for _, format := range []string{"tif", "tiff", "bmp", "png"} {
+		t.Run(format, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "round."+format)
+			err := SaveImage(img, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := ReadImage(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Bounds() != img.Rect {
+				t.Fatalf("bounds = %v, want %v", got.Bounds(), img.Rect)
+			}
+			for y := 0; y < 7; y++ {
+				for x := 0; x < 13; x++ {
+					if g, w := color.NRGBAModel.Convert(got.At(x, y)), img.NRGBAAt(x, y); g != w {
+						t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, g, w)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestExtensionCase(t *testing.T) {
+	img := noiseImage(8, 8)
+
+	tests := []struct {
+		name  string
+		magic string
+	}{
+		{"photo.JPG", "\xff\xd8"},
+		{"photo.Jpeg", "\xff\xd8"},
+		{"logo.PNG", "\x89PNG"},
+		{"logo.PnG", "\x89PNG"},
+		{"anim.GIF", "GIF8"},
+		{"scan.TIFF", "II*\x00"},
+		{"icon.Bmp", "BM"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.name)
+			err := SaveImage(img, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(data, []byte(tt.magic)) {
+				t.Errorf("%s starts with %q, want %q", tt.name, data[:4], tt.magic)
+			}
+
+			got, err := ReadImage(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Bounds() != img.Rect {
+				t.Errorf("bounds = %v, want %v", got.Bounds(), img.Rect)
+			}
+		})
+	}
+}
+
+func TestPNGCompression(t *testing.T) {
+	// smooth enough for the levels to matter, unlike noise
+	img := image.NewNRGBA(image.Rect(0, 0, 128, 128))
+	for y := 0; y < 128; y++ {
+		for x := 0; x < 128; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x), uint8(y), uint8((x * y) >> 6), 255})
+		}
+	}
+
+	size := func(level png.CompressionLevel) int {
+		var buf bytes.Buffer
+		err := WriteImageTo(&buf, img, "png", WithPNGCompression(level))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf.Len()
+	}
+
+	none, fast, best := size(png.NoCompression), size(png.BestSpeed), size(png.BestCompression)
+	if !(none > fast && fast > best) {
+		t.Errorf("no compression gave %d bytes, best speed %d and best compression %d, want fewer at each", none, fast, best)
+	}
+}
+
+func TestSaveImageFormat(t *testing.T) {
+	img := noiseImage(8, 8)
+
+	tests := []struct {
+		name    string
+		format  string
+		magic   string
+		wantErr error
+	}{
+		{"out.dat", "jpeg", "\xff\xd8", nil},
+		{"out.png", "gif", "GIF8", nil},
+		{"out.jpg", "PNG", "\x89PNG", nil},
+		{"out", "bmp", "BM", nil},
+		{"out.png", "xyz", "", ErrUnsupportedFormat},
+		{"out.png", "svg", "", ErrUnsupportedFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name+" as "+tt.format, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.name)
+			err := SaveImage(img, path, WithFormat(tt.format))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("SaveImage error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(data, []byte(tt.magic)) {
+				t.Errorf("%s starts with %q, want %q", tt.name, data[:4], tt.magic)
+			}
+		})
+	}
+}
+
+func TestReadImageByContent(t *testing.T) {
+	img := noiseImage(8, 8)
+
+	tests := []struct {
+		name   string
+		format string
+	}{
+		{"upload.dat", "png"},
+		{"upload", "png"},
+		{"upload.tmp", "gif"},
+		{"upload.dat", "bmp"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name+" holding "+tt.format, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.name)
+			err := SaveImage(img, path, WithFormat(tt.format))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := ReadImage(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Bounds() != img.Rect {
+				t.Errorf("bounds = %v, want %v", got.Bounds(), img.Rect)
+			}
+			config, err := ReadImageConfig(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.Width != 8 || config.Height != 8 {
+				t.Errorf("ReadImageConfig = %dx%d, want 8x8", config.Width, config.Height)
+			}
+		})
+	}
+
+	// content that is no image still says so
+	path := filepath.Join(t.TempDir(), "notes.dat")
+	err := os.WriteFile(path, []byte("just some notes"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadImage(path); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("ReadImage of text = %v, want %v", err, ErrUnsupportedFormat)
+	}
+}
+
+func TestSupportedFormats(t *testing.T) {
+	formats := SupportedFormats()
+	if !sort.StringsAreSorted(formats) {
+		t.Errorf("SupportedFormats() = %q, want them sorted", formats)
+	}
+
+	listed := map[string]bool{}
+	for _, format := range formats {
+		listed[format] = true
+	}
+	for _, want := range []string{"jpg", "jpeg", "png", "gif", "webp", "tif", "tiff", "bmp", "svg"} {
+		if !listed[want] {
+			t.Errorf("SupportedFormats() = %q, want %s in it", formats, want)
+		}
+	}
+	if listed["avif"] != avifSupported {
+		t.Errorf("avif listed = %v, want %v as the build supports it", listed["avif"], avifSupported)
+	}
+}
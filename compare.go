@@ -0,0 +1,40 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// compareDivider is the width in pixels of the line separating the
+// original from the watermarked image of a comparison.
+const compareDivider = 4
+
+// compareDividerColor is the color of the line of a comparison.
+var compareDividerColor = color.NRGBA{R: 128, G: 128, B: 128, A: 255}
+
+// sideBySide returns an image with before on the left and after on the
+// right, separated by a compareDivider pixels wide line, for WithCompare.
+// It keeps 16 bits per channel when after has them.
+func sideBySide(before image.Image, after draw.Image) draw.Image {
+	bw, bh := before.Bounds().Dx(), before.Bounds().Dy()
+	aw, ah := after.Bounds().Dx(), after.Bounds().Dy()
+	h := bh
+	if ah > h {
+		h = ah
+	}
+
+	r := image.Rect(0, 0, bw+compareDivider+aw, h)
+	var canvas draw.Image
+	if _, ok := after.(*image.NRGBA64); ok {
+		canvas = image.NewNRGBA64(r)
+	} else {
+		canvas = image.NewNRGBA(r)
+	}
+
+	draw.Draw(canvas, image.Rect(0, 0, bw, bh), before, before.Bounds().Min, draw.Src)
+	draw.Draw(canvas, image.Rect(bw, 0, bw+compareDivider, h), image.NewUniform(compareDividerColor), image.Point{}, draw.Src)
+	draw.Draw(canvas, image.Rect(bw+compareDivider, 0, r.Max.X, ah), after, after.Bounds().Min, draw.Src)
+
+	return canvas
+}
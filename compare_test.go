@@ -0,0 +1,55 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/gif"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	main := image.NewGray(image.Rect(0, 0, 30, 20))
+	out, err := WatermarkImage(main, []WatermarkSpec{{Image: redSquare(10)}}, WithCompare())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := out.Bounds(), image.Rect(0, 0, 2*30+compareDivider, 20); got != want {
+		t.Fatalf("bounds = %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		name string
+		p    image.Point
+		want color.NRGBA
+	}{
+		{"original", image.Pt(5, 5), color.NRGBA{0, 0, 0, 255}},
+		{"divider", image.Pt(30, 5), compareDividerColor},
+		{"watermarked", image.Pt(30+compareDivider+5, 5), color.NRGBA{255, 0, 0, 255}},
+		{"watermarked off the watermark", image.Pt(30+compareDivider+20, 5), color.NRGBA{0, 0, 0, 255}},
+	}
+	for _, tt := range tests {
+		if got := color.NRGBAModel.Convert(out.At(tt.p.X, tt.p.Y)); got != tt.want {
+			t.Errorf("%s pixel %v = %v, want %v", tt.name, tt.p, got, tt.want)
+		}
+	}
+
+	// a GIF output is compared on its first frame, not kept animated
+	in := writeTestGIF(t, &gif.GIF{
+		Image: []*image.Paletted{solidFrame(20, 10, 0), solidFrame(20, 10, 1)},
+		Delay: []int{10, 10},
+	})
+	gifOut := filepath.Join(t.TempDir(), "out.gif")
+	err = AddWatermarks(in, []WatermarkSpec{{Image: redSquare(4)}}, gifOut, WithCompare())
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := ReadImageConfig(gifOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Width != 2*20+compareDivider || config.Height != 10 {
+		t.Errorf("GIF comparison is %dx%d, want %dx10", config.Width, config.Height, 2*20+compareDivider)
+	}
+}
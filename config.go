@@ -0,0 +1,566 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Config describes a whole watermarking job. It can be loaded from a JSON
+// file with -config so the same job can be checked into source control.
+// The JSON keys match the command-line flag names.
+type Config struct {
+	Main       string            `json:"m"`
+	Output     string            `json:"o"`
+	Watermarks []WatermarkConfig `json:"watermarks"`
+
+	// Stamp repeats its text diagonally across the main image, only its
+	// text, font, fontsize and color are used.
+	Stamp *WatermarkConfig `json:"stamp,omitempty"`
+	// DPI is the resolution the font sizes of text watermarks and the
+	// stamp are rendered at, 72 when zero.
+	DPI float64 `json:"dpi,omitempty"`
+	// StampAngle, StampSpacingX and StampSpacingY set the Angle, Gap and
+	// GapY of the stamp, StampSpacingY follows StampSpacingX when negative.
+	StampAngle    float64 `json:"stampangle"`
+	StampSpacingX int     `json:"stampspacingx"`
+	StampSpacingY int     `json:"stampspacingy"`
+
+	InFormat     string `json:"informat,omitempty"`
+	OutFormat    string `json:"outformat,omitempty"`
+	Force        bool   `json:"force,omitempty"`
+	PreserveTime bool   `json:"preservetime,omitempty"`
+	Author       string `json:"author,omitempty"`
+	Copyright    string `json:"copyright,omitempty"`
+	Dir          bool   `json:"dir,omitempty"`
+	Workers      int    `json:"workers,omitempty"`
+	MaxDim       int    `json:"maxdim,omitempty"`
+	Page         int    `json:"page,omitempty"`
+
+	Center    bool    `json:"center,omitempty"`
+	Margin    int     `json:"margin,omitempty"`
+	MarginX   int     `json:"marginx,omitempty"`
+	MarginY   int     `json:"marginy,omitempty"`
+	Resample  string  `json:"resample,omitempty"`
+	Rotate    float64 `json:"rotate,omitempty"`
+	Flip      bool    `json:"flip,omitempty"`
+	Flop      bool    `json:"flop,omitempty"`
+	Tint      string  `json:"tint,omitempty"`
+	AutoColor bool    `json:"autocolor,omitempty"`
+	Scale     float64 `json:"scale,omitempty"`
+	NoResize  bool    `json:"noresize,omitempty"`
+	Upscale   bool    `json:"upscale,omitempty"`
+	Tile      bool    `json:"tile,omitempty"`
+	Gap       int     `json:"gap,omitempty"`
+	Stagger   bool    `json:"stagger,omitempty"`
+	StripRow  bool    `json:"striprow,omitempty"`
+	StripCol  bool    `json:"stripcol,omitempty"`
+	Opacity   float64 `json:"opacity,omitempty"`
+	Threshold int     `json:"threshold,omitempty"`
+	Quality   int     `json:"quality,omitempty"`
+
+	ColorKey          string `json:"colorkey,omitempty"`
+	ColorKeyTolerance int    `json:"colorkeytolerance,omitempty"`
+	Feather           int    `json:"feather,omitempty"`
+
+	Shadow        bool    `json:"shadow,omitempty"`
+	ShadowOffset  int     `json:"shadowoffset,omitempty"`
+	ShadowOpacity float64 `json:"shadowopacity,omitempty"`
+
+	PNGCompression string  `json:"pngcompression,omitempty"`
+	Subsampling    string  `json:"subsampling,omitempty"`
+	Progressive    bool    `json:"progressive,omitempty"`
+	Grayscale      bool    `json:"grayscale,omitempty"`
+	Brightness     float64 `json:"brightness,omitempty"`
+	Contrast       float64 `json:"contrast,omitempty"`
+	LinearBlend    bool    `json:"linearblend,omitempty"`
+	BlendMode      string  `json:"blendmode,omitempty"`
+	OnlyBright     bool    `json:"onlybright,omitempty"`
+	OnlyDark       bool    `json:"onlydark,omitempty"`
+	Mask           string  `json:"mask,omitempty"`
+	PreserveDepth  bool    `json:"preservedepth,omitempty"`
+	Background     string  `json:"background,omitempty"`
+
+	Dither     bool  `json:"dither,omitempty"`
+	DitherSeed int64 `json:"ditherseed,omitempty"`
+
+	CropX int `json:"cropx,omitempty"`
+	CropY int `json:"cropy,omitempty"`
+	CropW int `json:"cropw,omitempty"`
+	CropH int `json:"croph,omitempty"`
+
+	Compare bool `json:"compare,omitempty"`
+	Radius  int  `json:"radius,omitempty"`
+
+	ThumbWidth  int `json:"thumbwidth,omitempty"`
+	ThumbHeight int `json:"thumbheight,omitempty"`
+
+	Verbose bool `json:"v,omitempty"`
+	Quiet   bool `json:"q,omitempty"`
+	// Stats prints the elapsed time, the largest image read and the memory
+	// allocated on stderr once the job is done.
+	Stats bool `json:"stats,omitempty"`
+}
+
+// WatermarkConfig describes one watermark of a Config, either an image
+// file or a line of text.
+type WatermarkConfig struct {
+	Image string `json:"w,omitempty"`
+
+	Text     string  `json:"text,omitempty"`
+	Font     string  `json:"font,omitempty"`
+	FontSize float64 `json:"fontsize,omitempty"`
+	Color    string  `json:"color,omitempty"`
+
+	Stroke      string `json:"stroke,omitempty"`
+	StrokeWidth int    `json:"strokewidth,omitempty"`
+
+	BadgeColor   string `json:"badgecolor,omitempty"`
+	BadgePadding int    `json:"badgepadding,omitempty"`
+
+	Position string  `json:"pos,omitempty"`
+	X        int     `json:"x,omitempty"`
+	Y        int     `json:"y,omitempty"`
+	XPercent float64 `json:"xpct,omitempty"`
+	YPercent float64 `json:"ypct,omitempty"`
+	Height   int     `json:"height,omitempty"`
+	Width    int     `json:"width,omitempty"`
+}
+
+// defaultMaxDim is the largest width or height of the images a job reads
+// by default.
+const defaultMaxDim = 20000
+
+// defaultFontSize is the point size of text watermarks that do not set one.
+const defaultFontSize = 24
+
+// defaultStrokeWidth is the outline width of text watermarks with a stroke
+// color but no width.
+const defaultStrokeWidth = 2
+
+// defaultBadgePadding is the padding around text watermarks with a badge
+// color but no padding.
+const defaultBadgePadding = 8
+
+// DefaultConfig returns the settings used for everything a job leaves out.
+func DefaultConfig() Config {
+	return Config{
+		Workers:  1,
+		MaxDim:   defaultMaxDim,
+		MarginX:  -1,
+		MarginY:  -1,
+		Resample: "nearest",
+		Opacity:  1,
+		Quality:  jpeg.DefaultQuality,
+
+		StampAngle:    DefaultStampOptions().Angle,
+		StampSpacingX: DefaultStampOptions().Gap,
+		StampSpacingY: DefaultStampOptions().GapY,
+
+		ShadowOffset:   4,
+		ShadowOpacity:  0.5,
+		PNGCompression: "default",
+		Subsampling:    "420",
+		BlendMode:      "source-over",
+	}
+}
+
+// LoadConfig reads a JSON job description from path. Settings missing from
+// the file keep their DefaultConfig values.
+func LoadConfig(path string) (*Config, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	cfg := DefaultConfig()
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return &cfg, nil
+}
+
+// verboseLogger reports on stderr for Verbose.
+var verboseLogger = log.New(os.Stderr, "info: ", 0)
+
+// Options translates the job settings into Options.
+func (c *Config) Options() ([]Option, error) {
+	resampleMode, ok := resamplers[c.Resample]
+	if !ok {
+		return nil, fmt.Errorf("unknown resample mode %q", c.Resample)
+	}
+
+	pngCompression, ok := pngCompressionLevels[c.PNGCompression]
+	if !ok {
+		return nil, fmt.Errorf("unknown png compression %q", c.PNGCompression)
+	}
+
+	subsampling, ok := subsamplings[c.Subsampling]
+	if !ok {
+		return nil, fmt.Errorf("unknown subsampling %q", c.Subsampling)
+	}
+
+	blendMode, ok := blendModes[c.BlendMode]
+	if !ok {
+		return nil, fmt.Errorf("unknown blend mode %q", c.BlendMode)
+	}
+
+	marginX, marginY := c.MarginX, c.MarginY
+	if marginX < 0 {
+		marginX = c.Margin
+	}
+	if marginY < 0 {
+		marginY = c.Margin
+	}
+
+	opts := []Option{
+		WithOpacity(c.Opacity),
+		WithThreshold(c.Threshold),
+		WithQuality(c.Quality),
+		WithPNGCompression(pngCompression),
+		WithSubsampling(subsampling),
+		WithBlendMode(blendMode),
+		WithResample(resampleMode),
+		WithRotation(c.Rotate),
+		WithScale(c.Scale),
+		WithMargin(marginX, marginY),
+		WithWorkers(c.Workers),
+		WithMaxDimension(c.MaxDim),
+		WithPage(c.Page),
+	}
+	if c.Tile {
+		opts = append(opts, WithTile(c.Gap))
+	}
+	if c.OutFormat != "" {
+		opts = append(opts, WithFormat(c.OutFormat))
+	}
+	if c.Force {
+		opts = append(opts, WithOverwrite())
+	}
+	if c.PreserveTime {
+		opts = append(opts, WithPreserveTime())
+	}
+	if c.Author != "" {
+		opts = append(opts, WithAuthor(c.Author))
+	}
+	if c.Copyright != "" {
+		opts = append(opts, WithCopyright(c.Copyright))
+	}
+	if c.StripRow {
+		opts = append(opts, WithStripRow(c.Gap))
+	}
+	if c.StripCol {
+		opts = append(opts, WithStripColumn(c.Gap))
+	}
+	if c.Stagger {
+		opts = append(opts, WithStagger())
+	}
+	if c.Center {
+		opts = append(opts, WithCenter())
+	}
+	if c.Flip {
+		opts = append(opts, WithFlip())
+	}
+	if c.Flop {
+		opts = append(opts, WithFlop())
+	}
+	if c.Tint != "" {
+		tint, err := ParseColor(c.Tint)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithTint(tint))
+	}
+	if c.AutoColor {
+		opts = append(opts, WithAutoColor())
+	}
+	if c.ColorKey != "" {
+		key, err := ParseColor(c.ColorKey)
+		if err != nil {
+			return nil, err
+		}
+		if c.ColorKeyTolerance < 0 || c.ColorKeyTolerance > 255 {
+			return nil, fmt.Errorf("color key tolerance %d must be between 0 and 255", c.ColorKeyTolerance)
+		}
+		opts = append(opts, WithColorKey(key, uint8(c.ColorKeyTolerance)))
+	}
+	if c.Feather != 0 {
+		opts = append(opts, WithFeather(c.Feather))
+	}
+	if c.Shadow {
+		opts = append(opts, WithShadow(c.ShadowOffset, c.ShadowOpacity))
+	}
+	if c.NoResize {
+		opts = append(opts, WithNoResize())
+	}
+	if c.Upscale {
+		opts = append(opts, WithUpscale())
+	}
+	if c.Mask != "" {
+		mask, err := ReadImage(c.Mask, WithMaxDimension(c.MaxDim))
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithMask(mask))
+	}
+	if c.Progressive {
+		opts = append(opts, WithProgressive())
+	}
+	if c.OnlyBright {
+		opts = append(opts, WithOnlyBright())
+	}
+	if c.OnlyDark {
+		opts = append(opts, WithOnlyDark())
+	}
+	if c.Grayscale {
+		opts = append(opts, WithGrayscale())
+	}
+	if c.Brightness != 0 || c.Contrast != 0 {
+		opts = append(opts, WithBrightnessContrast(c.Brightness, c.Contrast))
+	}
+	if c.Dither {
+		opts = append(opts, WithDither(c.DitherSeed))
+	}
+	if c.PreserveDepth {
+		opts = append(opts, WithPreserveDepth())
+	}
+	if c.LinearBlend {
+		opts = append(opts, WithLinearBlend())
+	}
+	if c.Background != "" {
+		bg, err := ParseColor(c.Background)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithBackground(bg))
+	}
+	if c.Stamp != nil {
+		so, err := c.Stamp.stampOptions()
+		if err != nil {
+			return nil, err
+		}
+		so.DPI = c.DPI
+		so.Angle = c.StampAngle
+		so.Gap = c.StampSpacingX
+		so.GapY = c.StampSpacingY
+		opts = append(opts, WithStamp(c.Stamp.Text, so))
+	}
+	if c.Compare {
+		opts = append(opts, WithCompare())
+	}
+	if c.Radius != 0 {
+		opts = append(opts, WithRoundCorners(c.Radius))
+	}
+	if c.ThumbWidth != 0 || c.ThumbHeight != 0 {
+		opts = append(opts, WithThumbnail(c.ThumbWidth, c.ThumbHeight))
+	}
+	if c.Verbose {
+		opts = append(opts, WithLogger(verboseLogger))
+	}
+	if c.Quiet {
+		opts = append(opts, WithQuiet())
+	}
+	if c.CropW != 0 || c.CropH != 0 {
+		opts = append(opts, WithCrop(image.Rect(c.CropX, c.CropY, c.CropX+c.CropW, c.CropY+c.CropH)))
+	}
+
+	return opts, nil
+}
+
+// Specs loads or renders every watermark of the job.
+func (c *Config) Specs() ([]WatermarkSpec, error) {
+	opts := c.loadOptions()
+	if c.Verbose {
+		opts = append(opts, WithLogger(verboseLogger))
+	}
+
+	specs := make([]WatermarkSpec, len(c.Watermarks))
+	for i, wm := range c.Watermarks {
+		waterMarkImg, err := wm.load(opts...)
+		if err != nil {
+			return nil, err
+		}
+
+		specs[i] = wm.spec(waterMarkImg)
+	}
+
+	return specs, nil
+}
+
+// loadOptions returns the options the watermarks of the job are read and
+// rendered with.
+func (c *Config) loadOptions() []Option {
+	return []Option{WithMaxDimension(c.MaxDim), WithDPI(c.DPI)}
+}
+
+// spec returns the WatermarkSpec placing waterMarkImg as w describes.
+func (w *WatermarkConfig) spec(waterMarkImg image.Image) WatermarkSpec {
+	return WatermarkSpec{
+		Image:    waterMarkImg,
+		Anchor:   w.Position,
+		X:        w.X,
+		Y:        w.Y,
+		Height:   w.Height,
+		Width:    w.Width,
+		XPercent: w.XPercent,
+		YPercent: w.YPercent,
+	}
+}
+
+// load reads the watermark image or renders its text, with opts.
+func (w *WatermarkConfig) load(opts ...Option) (image.Image, error) {
+	if w.Text == "" {
+		if w.Image == "" {
+			return nil, errors.New("watermark needs an image or a text")
+		}
+		return ReadImage(w.Image, opts...)
+	}
+
+	var textColor color.Color = color.White
+	if w.Color != "" {
+		var err error
+		textColor, err = ParseColor(w.Color)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fontSize := w.FontSize
+	if fontSize == 0 {
+		fontSize = defaultFontSize
+	}
+
+	if w.Stroke != "" {
+		strokeColor, err := ParseColor(w.Stroke)
+		if err != nil {
+			return nil, err
+		}
+
+		strokeWidth := w.StrokeWidth
+		if strokeWidth == 0 {
+			strokeWidth = defaultStrokeWidth
+		}
+		opts = append(opts, WithStroke(strokeColor, strokeWidth))
+	}
+
+	if w.BadgeColor != "" {
+		badgeColor, err := ParseColor(w.BadgeColor)
+		if err != nil {
+			return nil, err
+		}
+
+		badgePadding := w.BadgePadding
+		if badgePadding == 0 {
+			badgePadding = defaultBadgePadding
+		}
+		opts = append(opts, WithBadge(badgeColor, badgePadding))
+	}
+
+	return RenderTextWatermark(w.Text, fontSize, textColor, w.Font, opts...)
+}
+
+// stampOptions returns the StampOptions for a stamp with w's font and
+// color, keeping the defaults for anything w does not set.
+func (w *WatermarkConfig) stampOptions() (StampOptions, error) {
+	so := DefaultStampOptions()
+	so.Font = w.Font
+	if w.FontSize != 0 {
+		so.Size = w.FontSize
+	}
+
+	if w.Color != "" {
+		var err error
+		so.Color, err = ParseColor(w.Color)
+		if err != nil {
+			return StampOptions{}, err
+		}
+	}
+
+	return so, nil
+}
+
+// Run executes the job.
+func (c *Config) Run() error {
+	err := c.checkPaths()
+	if err != nil {
+		return err
+	}
+
+	opts, err := c.Options()
+	if err != nil {
+		return err
+	}
+
+	specs, err := c.Specs()
+	if err != nil {
+		return err
+	}
+
+	if c.Stats {
+		stats := startStats()
+		opts = append(opts, withStats(stats))
+		defer stats.print(os.Stderr)
+	}
+
+	if c.Dir {
+		return processDirectory(c.Main, specs, c.Output, opts...)
+	}
+
+	if c.Main == "-" || c.Output == "-" {
+		return watermarkStream(os.Stdin, os.Stdout, c.Main, c.InFormat, specs, c.Output, c.OutFormat, opts...)
+	}
+
+	return AddWatermarks(c.Main, specs, c.Output, opts...)
+}
+
+// checkPaths returns an error when the output of the job is one of its
+// inputs, which would be truncated while it is still needed, unless Force
+// is set.
+func (c *Config) checkPaths() error {
+	if c.Force || c.Output == "-" {
+		return nil
+	}
+
+	inputs := []string{c.Main}
+	for _, wm := range c.Watermarks {
+		if wm.Text == "" {
+			inputs = append(inputs, wm.Image)
+		}
+	}
+
+	for _, input := range inputs {
+		if input == "-" || isURL(input) {
+			continue
+		}
+		if samePath(input, c.Output) {
+			return fmt.Errorf("%s: output is also an input, use -force to overwrite it", c.Output)
+		}
+	}
+
+	return nil
+}
+
+// samePath reports whether a and b name the same file, either as the same
+// absolute path or through a link.
+func samePath(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA == nil && errB == nil && absA == absB {
+		return true
+	}
+
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	return errA == nil && errB == nil && os.SameFile(infoA, infoB)
+}
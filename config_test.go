@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckPaths(t *testing.T) {
+	dir := t.TempDir()
+	photo, logo := filepath.Join(dir, "photo.jpg"), filepath.Join(dir, "logo.png")
+	for _, path := range []string{photo, logo} {
+		err := os.WriteFile(path, nil, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := filepath.Join(dir, "link.jpg")
+	err := os.Symlink(photo, link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	relative, err := filepath.Rel(wd, photo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{"same path", Config{Main: photo, Output: photo}, true},
+		{"relative path", Config{Main: relative, Output: photo}, true},
+		{"through a link", Config{Main: photo, Output: link}, true},
+		{"watermark", Config{Main: photo, Output: logo, Watermarks: []WatermarkConfig{{Image: logo}}}, true},
+		{"forced", Config{Main: photo, Output: photo, Force: true}, false},
+		{"other output", Config{Main: photo, Output: filepath.Join(dir, "out.jpg"), Watermarks: []WatermarkConfig{{Image: logo}}}, false},
+		{"stdout", Config{Main: "-", Output: "-"}, false},
+		{"text watermark", Config{Main: photo, Output: filepath.Join(dir, "out.jpg"), Watermarks: []WatermarkConfig{{Text: "hi"}}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.checkPaths()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkPaths() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
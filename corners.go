@@ -0,0 +1,94 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// RoundCorners returns a copy of img with its corners rounded off with the
+// given radius, as for avatars and thumbnails. The pixels outside the
+// rounded rectangle become bg, or transparent when bg is nil, and the edge
+// is antialiased. The radius is limited to half the shorter side.
+func RoundCorners(img image.Image, radius int, bg color.Color) image.Image {
+	var newImg draw.Image
+	if is16Bit(img) {
+		newImg = copyNRGBA64(img, img.Bounds())
+	} else {
+		newImg = copyNRGBA(toNRGBA(img))
+	}
+
+	roundCorners(newImg, radius, bg)
+	return newImg
+}
+
+// roundCorners is RoundCorners drawing onto dst itself.
+func roundCorners(dst draw.Image, radius int, bg color.Color) {
+	b := dst.Bounds()
+	if half := b.Dx() / 2; radius > half {
+		radius = half
+	}
+	if half := b.Dy() / 2; radius > half {
+		radius = half
+	}
+	if radius <= 0 {
+		return
+	}
+
+	var bgColor color.NRGBA64
+	if bg != nil {
+		bgColor = color.NRGBA64Model.Convert(bg).(color.NRGBA64)
+	}
+	mix := func(c, bg uint16, cover float64) uint16 {
+		return uint16(float64(c)*cover + float64(bg)*(1-cover) + 0.5)
+	}
+
+	r := float64(radius)
+	left, right := float64(b.Min.X)+r, float64(b.Max.X)-r
+	top, bottom := float64(b.Min.Y)+r, float64(b.Max.Y)-r
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		// only the rows and columns within radius of an edge have corners
+		if y == b.Min.Y+radius {
+			y = b.Max.Y - radius
+		}
+		cy := top
+		if float64(y) >= bottom {
+			cy = bottom
+		}
+
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if x == b.Min.X+radius {
+				x = b.Max.X - radius
+			}
+			cx := left
+			if float64(x) >= right {
+				cx = right
+			}
+
+			// coverage of the pixel by the circle around the corner's center
+			d := math.Hypot(float64(x)+0.5-cx, float64(y)+0.5-cy)
+			cover := r - d + 0.5
+			if cover >= 1 {
+				continue
+			}
+			if cover < 0 {
+				cover = 0
+			}
+
+			c := color.NRGBA64Model.Convert(dst.At(x, y)).(color.NRGBA64)
+			if bg == nil {
+				c.A = mix(c.A, 0, cover)
+			} else {
+				c = color.NRGBA64{
+					R: mix(c.R, bgColor.R, cover),
+					G: mix(c.G, bgColor.G, cover),
+					B: mix(c.B, bgColor.B, cover),
+					A: mix(c.A, bgColor.A, cover),
+				}
+			}
+			dst.Set(x, y, c)
+		}
+	}
+}
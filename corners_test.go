@@ -0,0 +1,69 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"path/filepath"
+	"testing"
+)
+
+func TestRoundCorners(t *testing.T) {
+	red := color.NRGBA{255, 0, 0, 255}
+	white := color.NRGBA{255, 255, 255, 255}
+	img := image.NewNRGBA(image.Rect(0, 0, 100, 80))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+3] = 255, 255
+	}
+
+	corners := []image.Point{{0, 0}, {99, 0}, {0, 79}, {99, 79}, {3, 3}, {96, 76}}
+	kept := []image.Point{{50, 0}, {0, 40}, {50, 40}, {20, 20}, {99, 40}}
+
+	tests := []struct {
+		name string
+		bg   color.Color
+		want color.NRGBA
+	}{
+		{"transparent", nil, color.NRGBA{}},
+		{"background", color.White, white},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := RoundCorners(img, 20, tt.bg)
+			for _, p := range corners {
+				got := color.NRGBAModel.Convert(out.At(p.X, p.Y)).(color.NRGBA)
+				if got.A != tt.want.A || (tt.want.A != 0 && got != tt.want) {
+					t.Errorf("corner pixel %v = %v, want %v", p, got, tt.want)
+				}
+			}
+			for _, p := range kept {
+				if got := color.NRGBAModel.Convert(out.At(p.X, p.Y)); got != red {
+					t.Errorf("pixel %v = %v, want it kept %v", p, got, red)
+				}
+			}
+		})
+	}
+
+	if img.NRGBAAt(0, 0) != red {
+		t.Error("RoundCorners changed img")
+	}
+
+	// JPEG output has no alpha, so the corners take the background
+	in, path := filepath.Join(t.TempDir(), "in.png"), filepath.Join(t.TempDir(), "out.jpg")
+	err := SaveImage(img, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AddWatermarks(in, []WatermarkSpec{{Image: redSquare(4)}}, path,
+		WithRoundCorners(20), WithBackground(color.White), WithQuality(100), WithSubsampling(Subsampling444))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ReadImage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := color.NRGBAModel.Convert(out.At(0, 0)).(color.NRGBA); !closeNRGBA(got, white, 4) {
+		t.Errorf("JPEG corner = %v, want %v", got, white)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+)
+
+// CropImage returns a copy of the region rect of img, which has to lie
+// within the image bounds. The copy is an *image.NRGBA with its origin at
+// (0, 0).
+func CropImage(img image.Image, rect image.Rectangle) (image.Image, error) {
+	if img == nil {
+		return nil, ErrNilImage
+	}
+
+	err := checkCrop(rect, img.Bounds())
+	if err != nil {
+		return nil, err
+	}
+
+	newImage := image.NewNRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(newImage, newImage.Bounds(), img, rect.Min, draw.Src)
+
+	return newImage, nil
+}
+
+// checkCrop checks that rect is a non-empty region within bounds.
+func checkCrop(rect, bounds image.Rectangle) error {
+	if rect.Empty() {
+		return fmt.Errorf("crop %v is empty", rect)
+	}
+
+	if !rect.In(bounds) {
+		return fmt.Errorf("crop %v %w %v", rect, ErrOutOfBounds, bounds)
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestCropImage(t *testing.T) {
+	// every pixel of the 10x8 source holds its own coordinates; the
+	// bounds do not start at the origin
+	src := image.NewNRGBA(image.Rect(2, 3, 12, 11))
+	for y := 3; y < 11; y++ {
+		for x := 2; x < 12; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{uint8(x), uint8(y), 0, 255})
+		}
+	}
+
+	tests := []struct {
+		name    string
+		rect    image.Rectangle
+		wantErr error
+	}{
+		{"inside", image.Rect(4, 5, 7, 9), nil},
+		{"whole image", src.Rect, nil},
+		{"single pixel", image.Rect(11, 10, 12, 11), nil},
+		{"past the right edge", image.Rect(8, 5, 13, 9), ErrOutOfBounds},
+		{"before the origin", image.Rect(0, 0, 4, 4), ErrOutOfBounds},
+		{"outside", image.Rect(20, 20, 25, 25), ErrOutOfBounds},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cropped, err := CropImage(src, tt.rect)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CropImage(%v) error = %v, want %v", tt.rect, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if got, want := cropped.Bounds(), image.Rect(0, 0, tt.rect.Dx(), tt.rect.Dy()); got != want {
+				t.Fatalf("bounds = %v, want %v", got, want)
+			}
+			for y := 0; y < tt.rect.Dy(); y++ {
+				for x := 0; x < tt.rect.Dx(); x++ {
+					if got, want := cropped.At(x, y), src.At(tt.rect.Min.X+x, tt.rect.Min.Y+y); got != want {
+						t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+					}
+				}
+			}
+		})
+	}
+
+	if _, err := CropImage(src, image.Rect(4, 4, 4, 8)); err == nil {
+		t.Error("an empty crop was accepted")
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// ditherStrength is how many 8-bit levels ditherRegion moves a channel at
+// most, little enough to go unnoticed.
+const ditherStrength = 2
+
+// bayer4 is the 4x4 ordered dithering matrix.
+var bayer4 = [4][4]int{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5},
+}
+
+// ditherRegion nudges every channel of the pixels of dst inside r up or
+// down by an ordered dither pattern. seed shifts the pattern of each
+// channel separately, so the same seed always gives the same result and
+// different seeds, however close, usually give different patterns. With
+// only 16 phases of the pattern per channel this varies the dither rather
+// than hides it. The alpha is left alone.
+func ditherRegion(dst draw.Image, r image.Rectangle, seed int64) {
+	r = r.Intersect(dst.Bounds())
+
+	// a phase of the pattern for each of the red, green and blue channels,
+	// drawn from every bit of the seed
+	state := uint64(seed)
+	var phase [3]image.Point
+	for c := range phase {
+		bits := splitmix64(&state)
+		phase[c] = image.Pt(int(bits&3), int(bits>>2&3))
+	}
+
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			col := color.NRGBA64Model.Convert(dst.At(x, y)).(color.NRGBA64)
+			channels := [3]*uint16{&col.R, &col.G, &col.B}
+			for c, v := range channels {
+				// from -ditherStrength to +ditherStrength levels
+				level := bayer4[(y+phase[c].Y)&3][(x+phase[c].X)&3]
+				delta := (2*level - 15) * ditherStrength * 0x101 / 15
+				*v = clampUint16(int(*v) + delta)
+			}
+			dst.Set(x, y, col)
+		}
+	}
+}
+
+// splitmix64 advances state and returns the next value of the SplitMix64
+// generator, which spreads every bit of the state over the result.
+func splitmix64(state *uint64) uint64 {
+	*state += 0x9e3779b97f4a7c15
+	z := *state
+	z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+	z = (z ^ z>>27) * 0x94d049bb133111eb
+	return z ^ z>>31
+}
+
+// clampUint16 limits v to the range of a uint16.
+func clampUint16(v int) uint16 {
+	if v < 0 {
+		return 0
+	}
+	if v > 0xffff {
+		return 0xffff
+	}
+	return uint16(v)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"reflect"
+	"testing"
+)
+
+func TestDitherRegion(t *testing.T) {
+	main := image.NewNRGBA(image.Rect(0, 0, 60, 40))
+	draw.Draw(main, main.Rect, image.NewUniform(color.NRGBA{128, 128, 128, 255}), image.Point{}, draw.Src)
+	wm := image.NewNRGBA(image.Rect(0, 0, 20, 10))
+	draw.Draw(wm, wm.Rect, image.NewUniform(color.NRGBA{255, 255, 255, 64}), image.Point{}, draw.Src)
+	region := image.Rect(30, 20, 50, 30)
+
+	watermark := func(opts ...Option) *image.NRGBA {
+		out, err := WatermarkImage(main, []WatermarkSpec{{Image: wm, X: 30, Y: 20}}, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out.(*image.NRGBA)
+	}
+
+	plain, dithered := watermark(), watermark(WithDither(42))
+	changed := 0
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 60; x++ {
+			p, d := plain.NRGBAAt(x, y), dithered.NRGBAAt(x, y)
+			if p == d {
+				continue
+			}
+			if !image.Pt(x, y).In(region) {
+				t.Fatalf("pixel (%d, %d) outside the watermark changed from %v to %v", x, y, p, d)
+			}
+			if !closeNRGBA(d, p, ditherStrength) || d.A != p.A {
+				t.Fatalf("pixel (%d, %d) changed from %v to %v, more than the dither strength", x, y, p, d)
+			}
+			changed++
+		}
+	}
+	if changed < region.Dx()*region.Dy()/2 {
+		t.Errorf("%d of the %d watermarked pixels changed, want most of them", changed, region.Dx()*region.Dy())
+	}
+
+	if again := watermark(WithDither(42)); !reflect.DeepEqual(again.Pix, dithered.Pix) {
+		t.Error("the same seed gave a different dither")
+	}
+	if other := watermark(WithDither(43)); reflect.DeepEqual(other.Pix, dithered.Pix) {
+		t.Error("another seed gave the same dither")
+	}
+}
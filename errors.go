@@ -0,0 +1,25 @@
+package main
+
+import "errors"
+
+// Errors returned, possibly wrapped, by the image functions so callers can
+// tell the failures apart with errors.Is.
+var (
+	// ErrUnsupportedFormat is returned for an image format, or a path
+	// extension, that cannot be read or written.
+	ErrUnsupportedFormat = errors.New("unsupported format")
+	// ErrOutOfBounds is returned when a watermark lies entirely off the
+	// main image or a crop does not start on it.
+	ErrOutOfBounds = errors.New("out of bounds")
+	// ErrNilImage is returned when a nil image is passed in.
+	ErrNilImage = errors.New("image is nil")
+	// ErrTooLarge is returned for an image wider or taller than the limit
+	// set with WithMaxDimension.
+	ErrTooLarge = errors.New("image too large")
+	// ErrInvalidSize is returned for a resize to a width or height that is
+	// not positive.
+	ErrInvalidSize = errors.New("invalid size")
+	// ErrCorrupt is returned for an image whose data ends before it has
+	// been decoded completely, as a truncated file does.
+	ErrCorrupt = errors.New("corrupt or truncated image")
+)
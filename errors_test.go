@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestErrorSentinels(t *testing.T) {
+	dir := t.TempDir()
+	mainPath, wmPath := filepath.Join(dir, "main.png"), filepath.Join(dir, "wm.png")
+	err := SaveImage(image.NewGray(image.Rect(0, 0, 20, 20)), mainPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SaveImage(image.NewGray(image.Rect(0, 0, 4, 4)), wmPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notImage := filepath.Join(dir, "notes.xyz")
+	err = os.WriteFile(notImage, []byte("not an image"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out.png")
+
+	tests := []struct {
+		name string
+		err  func() error
+		want error
+	}{
+		{"ReadImage of an unknown format", func() error {
+			_, err := ReadImage(notImage)
+			return err
+		}, ErrUnsupportedFormat},
+		{"ReadImage of a missing file", func() error {
+			_, err := ReadImage(filepath.Join(dir, "missing.png"))
+			return err
+		}, fs.ErrNotExist},
+		{"ReadImageFrom of an unknown format", func() error {
+			_, err := ReadImageFrom(nil, "xyz")
+			return err
+		}, ErrUnsupportedFormat},
+		{"SaveImage of a nil image", func() error {
+			return SaveImage(nil, out)
+		}, ErrNilImage},
+		{"SaveImage to an unknown format", func() error {
+			return SaveImage(image.NewGray(image.Rect(0, 0, 1, 1)), filepath.Join(dir, "out.xyz"))
+		}, ErrUnsupportedFormat},
+		{"ResizeImage of a nil image", func() error {
+			_, err := ResizeImage(nil, 4, 4)
+			return err
+		}, ErrNilImage},
+		{"ResizeImage to no width", func() error {
+			_, err := ResizeImage(image.NewGray(image.Rect(0, 0, 4, 4)), 0, 4)
+			return err
+		}, ErrInvalidSize},
+		{"AddWatermarkImage off the main image", func() error {
+			return AddWatermarkImage(mainPath, wmPath, out, "", 50, 0, 0, 0)
+		}, ErrOutOfBounds},
+		{"AddWatermarkImage of an unknown main format", func() error {
+			return AddWatermarkImage(notImage, wmPath, out, "", 0, 0, 0, 0)
+		}, ErrUnsupportedFormat},
+		{"AddWatermarkImage to an unknown format", func() error {
+			return AddWatermarkImage(mainPath, wmPath, filepath.Join(dir, "out.xyz"), "", 0, 0, 0, 0)
+		}, ErrUnsupportedFormat},
+		{"AddWatermark of a nil watermark", func() error {
+			return AddWatermark(mainPath, nil, out, "", 0, 0, 0, 0)
+		}, ErrNilImage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.err()
+			if !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"io"
+	"sort"
+)
+
+// jpegSegment is a marker segment from the header of a JPEG stream.
+type jpegSegment struct {
+	marker byte
+	data   []byte
+}
+
+// readJPEGHeader reads the marker segments of the JPEG stream r up to its
+// frame header. It returns them along with a reader that replays the whole
+// stream from the start. A malformed header ends the segments early and is
+// left for the JPEG decoder to report.
+func readJPEGHeader(r io.Reader) ([]jpegSegment, io.Reader) {
+	hr := newHeaderReader(r)
+
+	var soi [2]byte
+	if _, err := io.ReadFull(hr, soi[:]); err != nil || soi != [2]byte{0xff, 0xd8} {
+		return nil, hr.replay()
+	}
+
+	var segments []jpegSegment
+	for {
+		var marker [2]byte
+		if _, err := io.ReadFull(hr, marker[:]); err != nil || marker[0] != 0xff {
+			return segments, hr.replay()
+		}
+
+		switch {
+		case marker[1] >= 0xd0 && marker[1] <= 0xd7, marker[1] == 0x01:
+			// standalone markers carry no length
+			continue
+		case marker[1] >= 0xc0 && marker[1] <= 0xcf && marker[1] != 0xc4 && marker[1] != 0xc8 && marker[1] != 0xcc,
+			marker[1] == 0xda, marker[1] == 0xd9:
+			// frame header, start of scan or end of image
+			return segments, hr.replay()
+		}
+
+		var length [2]byte
+		if _, err := io.ReadFull(hr, length[:]); err != nil {
+			return segments, hr.replay()
+		}
+		n := int(binary.BigEndian.Uint16(length[:])) - 2
+		if n < 0 {
+			return segments, hr.replay()
+		}
+
+		data := make([]byte, n)
+		if _, err := io.ReadFull(hr, data); err != nil {
+			return segments, hr.replay()
+		}
+		segments = append(segments, jpegSegment{marker: marker[1], data: data})
+	}
+}
+
+// exifOrientation returns the EXIF orientation, from 1 to 8, recorded in
+// the APP1 segments of a JPEG header, or 1 when there is none.
+func exifOrientation(segments []jpegSegment) int {
+	for _, segment := range segments {
+		if segment.marker != 0xe1 {
+			continue
+		}
+
+		offset, order := exifOrientationOffset(segment.data)
+		if offset < 0 {
+			continue
+		}
+
+		orientation := int(order.Uint16(segment.data[offset:]))
+		if orientation < 1 || orientation > 8 {
+			return 1
+		}
+		return orientation
+	}
+
+	return 1
+}
+
+// exifOrientationOffset returns where the orientation value is stored in
+// the data of an APP1 segment and the byte order to read it with, or -1
+// when the segment is not EXIF or has no orientation.
+func exifOrientationOffset(data []byte) (int, binary.ByteOrder) {
+	tiff, ifd, count, order := exifIFD0(data)
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return -1, nil
+		}
+
+		// the orientation is a SHORT stored directly in the entry
+		if order.Uint16(tiff[entry:]) == 0x0112 && order.Uint16(tiff[entry+2:]) == 3 {
+			return 6 + entry + 8, order
+		}
+	}
+
+	return -1, nil
+}
+
+// exifIFD0 returns the TIFF structure within the data of an APP1 segment,
+// the offset of its first IFD, the number of entries in it and the byte
+// order, or no entries when the segment is not EXIF.
+func exifIFD0(data []byte) ([]byte, int, int, binary.ByteOrder) {
+	if !bytes.HasPrefix(data, []byte("Exif\x00\x00")) {
+		return nil, 0, 0, nil
+	}
+
+	tiff := data[6:]
+	if len(tiff) < 8 {
+		return nil, 0, 0, nil
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, 0, 0, nil
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return nil, 0, 0, nil
+	}
+
+	return tiff, ifd, int(order.Uint16(tiff[ifd:])), order
+}
+
+// stripExifThumbnail returns a copy of the APP1 segment data without the
+// second IFD, which holds the thumbnail of the original image, or data
+// itself when there is none. The thumbnail would otherwise show the image
+// without its watermark. The link to the IFD is cleared and the IFD and
+// the thumbnail it points to are blanked, and cut off when they are at
+// the end of the segment, as they usually are.
+func stripExifThumbnail(data []byte) []byte {
+	tiff, ifd, count, order := exifIFD0(data)
+	next := ifd + 2 + count*12
+	if order == nil || next+4 > len(tiff) {
+		return data
+	}
+	ifd1 := int(order.Uint32(tiff[next:]))
+	if ifd1 < 8 || ifd1+2 > len(tiff) {
+		return data
+	}
+
+	stripped := append([]byte(nil), data...)
+	tiff = stripped[6:]
+	order.PutUint32(tiff[next:], 0)
+
+	// the IFD, the values too large for its entries and the thumbnail
+	entries := int(order.Uint16(tiff[ifd1:]))
+	ranges := [][2]int{{ifd1, ifd1 + 2 + entries*12 + 4}}
+	var thumbOffset, thumbLength int
+	for i := 0; i < entries; i++ {
+		entry := ifd1 + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		switch order.Uint16(tiff[entry:]) {
+		case 0x0201: // JPEGInterchangeFormat
+			thumbOffset = int(order.Uint32(tiff[entry+8:]))
+		case 0x0202: // JPEGInterchangeFormatLength
+			thumbLength = int(order.Uint32(tiff[entry+8:]))
+		}
+
+		typ := int(order.Uint16(tiff[entry+2:]))
+		if typ < len(exifTypeSizes) {
+			size := exifTypeSizes[typ] * int(order.Uint32(tiff[entry+4:]))
+			if size > 4 {
+				offset := int(order.Uint32(tiff[entry+8:]))
+				ranges = append(ranges, [2]int{offset, offset + size})
+			}
+		}
+	}
+	if thumbLength > 0 {
+		ranges = append(ranges, [2]int{thumbOffset, thumbOffset + thumbLength})
+	}
+
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+	end := ifd1
+	for _, r := range ranges {
+		if r[0] < 8 || r[0] > r[1] || r[1] > len(tiff) {
+			continue
+		}
+		for i := r[0]; i < r[1]; i++ {
+			tiff[i] = 0
+		}
+		// values are padded to an even offset
+		if r[0] <= end+1 && r[1] > end {
+			end = r[1]
+		}
+	}
+
+	if end >= len(tiff)-1 {
+		return stripped[:6+ifd1]
+	}
+	return stripped
+}
+
+// exifTypeSizes is the size in bytes of one value of each TIFF field type.
+var exifTypeSizes = []int{0, 1, 1, 2, 4, 8, 1, 1, 2, 4, 8, 4, 8}
+
+// decodeJPEG decodes a JPEG and turns it upright according to its EXIF
+// orientation, since image/jpeg ignores it. The metadata segments of the
+// header are returned too.
+func decodeJPEG(r io.Reader) (image.Image, *Metadata, error) {
+	segments, r := readJPEGHeader(r)
+
+	img, err := jpeg.Decode(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return orientImage(img, exifOrientation(segments)), jpegMetadata(segments), nil
+}
+
+// decodeJPEGConfig is jpeg.DecodeConfig with the dimensions swapped for
+// EXIF orientations that turn the image on its side.
+func decodeJPEGConfig(r io.Reader) (image.Config, error) {
+	segments, r := readJPEGHeader(r)
+
+	config, err := jpeg.DecodeConfig(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	if exifOrientation(segments) >= 5 {
+		config.Width, config.Height = config.Height, config.Width
+	}
+
+	return config, nil
+}
+
+// orientImage undoes the transformation described by an EXIF orientation.
+func orientImage(img image.Image, orientation int) image.Image {
+	switch orientation {
+	case 2:
+		return FlipHorizontal(img)
+	case 3:
+		return rotateQuarter(img, 2)
+	case 4:
+		return FlipVertical(img)
+	case 5:
+		return rotateQuarter(FlipHorizontal(img), 3)
+	case 6:
+		return rotateQuarter(img, 1)
+	case 7:
+		return rotateQuarter(FlipHorizontal(img), 1)
+	case 8:
+		return rotateQuarter(img, 3)
+	default:
+		return img
+	}
+}
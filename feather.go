@@ -0,0 +1,51 @@
+package main
+
+import (
+	"image"
+	"image/color"
+)
+
+// FeatherEdges returns a copy of img whose alpha fades out over radius
+// pixels towards its edges, and towards the edges of its transparent
+// areas, so it blends into the main image instead of looking pasted on.
+// How far each pixel is from an edge is measured by box blurring the mask
+// of its non-transparent pixels.
+func FeatherEdges(img image.Image, radius int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	newImage := image.NewNRGBA(bounds)
+
+	mask := make([]uint32, w*h)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			newImage.SetNRGBA(x, y, c)
+			if c.A > 0 {
+				mask[(y-bounds.Min.Y)*w+x-bounds.Min.X] = 255
+			}
+		}
+	}
+
+	if radius <= 0 {
+		return newImage
+	}
+
+	// beyond the bounds counts as transparent, which fades the edges
+	coverage := boxBlur(mask, w, h, 1, w, radius)
+	coverage = boxBlur(coverage, h, w, w, 1, radius)
+
+	for i, c := range coverage {
+		// an edge pixel is about half covered, so stretch the half from
+		// there to fully covered over the whole alpha range
+		factor := int(2*c) - 255
+		if factor >= 255 {
+			continue
+		}
+		if factor < 0 {
+			factor = 0
+		}
+		newImage.Pix[i*4+3] = uint8(int(newImage.Pix[i*4+3]) * factor / 255)
+	}
+
+	return newImage
+}
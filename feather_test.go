@@ -0,0 +1,50 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestFeatherEdges(t *testing.T) {
+	feathered := FeatherEdges(redSquare(20), 4).(*image.NRGBA)
+	alpha := func(x, y int) uint8 {
+		return feathered.NRGBAAt(x, y).A
+	}
+
+	// across the middle of the left edge the alpha rises to opaque
+	if a := alpha(0, 10); a == 0 || a == 255 {
+		t.Errorf("edge pixel alpha = %d, want between 0 and 255", a)
+	}
+	for x := 1; x <= 4; x++ {
+		if alpha(x, 10) <= alpha(x-1, 10) && alpha(x, 10) != 255 {
+			t.Errorf("alpha at x = %d is %d, want more than the %d towards the edge", x, alpha(x, 10), alpha(x-1, 10))
+		}
+	}
+	if a := alpha(10, 10); a != 255 {
+		t.Errorf("center pixel alpha = %d, want 255", a)
+	}
+	if c := feathered.NRGBAAt(0, 10); c.R != 255 || c.G != 0 || c.B != 0 {
+		t.Errorf("edge pixel = %v, want red with less alpha", c)
+	}
+
+	// no radius keeps the edges hard
+	if a := FeatherEdges(redSquare(20), 0).(*image.NRGBA).NRGBAAt(0, 10).A; a != 255 {
+		t.Errorf("edge pixel alpha without feathering = %d, want 255", a)
+	}
+
+	// blended, the edge is between the watermark and the main image
+	main := image.NewGray(image.Rect(0, 0, 20, 20))
+	out, err := WatermarkImage(main, []WatermarkSpec{{Image: redSquare(20)}}, WithFeather(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := color.NRGBAModel.Convert(out.At(0, 10)).(color.NRGBA)
+	if got.R == 0 || got.R == 255 {
+		t.Errorf("blended edge pixel = %v, want part way from black to red", got)
+	}
+
+	if _, err := WatermarkImage(main, []WatermarkSpec{{Image: redSquare(20)}}, WithFeather(-1)); err == nil {
+		t.Error("a negative feather was accepted")
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// stringsFlag is a flag.Value collecting every value of a string flag that
+// may be repeated.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// at returns the i-th value, or the last one when fewer were given, or def
+// when the flag was never set.
+func (f stringsFlag) at(i int, def string) string {
+	if len(f) == 0 {
+		return def
+	}
+	if i >= len(f) {
+		return f[len(f)-1]
+	}
+	return f[i]
+}
+
+// intsFlag is a flag.Value collecting every value of an int flag that may
+// be repeated.
+type intsFlag []int
+
+func (f *intsFlag) String() string {
+	values := make([]string, len(*f))
+	for i, v := range *f {
+		values[i] = strconv.Itoa(v)
+	}
+	return strings.Join(values, ",")
+}
+
+func (f *intsFlag) Set(value string) error {
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid integer %q", value)
+	}
+	*f = append(*f, v)
+	return nil
+}
+
+// at returns the i-th value, or the last one when fewer were given, or def
+// when the flag was never set.
+func (f intsFlag) at(i int, def int) int {
+	if len(f) == 0 {
+		return def
+	}
+	if i >= len(f) {
+		return f[len(f)-1]
+	}
+	return f[i]
+}
+
+// floatsFlag is a flag.Value collecting every value of a float flag that may
+// be repeated.
+type floatsFlag []float64
+
+func (f *floatsFlag) String() string {
+	values := make([]string, len(*f))
+	for i, v := range *f {
+		values[i] = strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return strings.Join(values, ",")
+}
+
+func (f *floatsFlag) Set(value string) error {
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("invalid number %q", value)
+	}
+	*f = append(*f, v)
+	return nil
+}
+
+// at returns the i-th value, or the last one when fewer were given, or def
+// when the flag was never set.
+func (f floatsFlag) at(i int, def float64) float64 {
+	if len(f) == 0 {
+		return def
+	}
+	if i >= len(f) {
+		return f[len(f)-1]
+	}
+	return f[i]
+}
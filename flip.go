@@ -0,0 +1,35 @@
+package main
+
+import "image"
+
+// FlipHorizontal returns a copy of img mirrored left to right, so the
+// pixel at (0, 0) moves to (w-1, 0). Transparency is kept.
+func FlipHorizontal(img image.Image) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	newImage := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < w; i++ {
+		for j := 0; j < h; j++ {
+			newImage.Set(i, j, img.At(bounds.Max.X-1-i, bounds.Min.Y+j))
+		}
+	}
+
+	return newImage
+}
+
+// FlipVertical returns a copy of img mirrored top to bottom, so the pixel
+// at (0, 0) moves to (0, h-1). Transparency is kept.
+func FlipVertical(img image.Image) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	newImage := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < w; i++ {
+		for j := 0; j < h; j++ {
+			newImage.Set(i, j, img.At(bounds.Min.X+i, bounds.Max.Y-1-j))
+		}
+	}
+
+	return newImage
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestFlip(t *testing.T) {
+	// a 3x2 image, offset from the origin, with a different color and
+	// alpha at every pixel
+	src := image.NewNRGBA(image.Rect(5, 5, 8, 7))
+	for y := 5; y < 7; y++ {
+		for x := 5; x < 8; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{uint8(x * 30), uint8(y * 30), 90, uint8(40 * (x + y - 9))})
+		}
+	}
+
+	tests := []struct {
+		name string
+		flip func(image.Image) image.Image
+		// where the source pixel at (x, y), relative to its bounds, goes
+		moved func(x, y int) image.Point
+	}{
+		{"horizontal", FlipHorizontal, func(x, y int) image.Point { return image.Pt(2-x, y) }},
+		{"vertical", FlipVertical, func(x, y int) image.Point { return image.Pt(x, 1-y) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flipped := tt.flip(src)
+			if got, want := flipped.Bounds(), image.Rect(0, 0, 3, 2); got != want {
+				t.Fatalf("bounds = %v, want %v", got, want)
+			}
+
+			for y := 0; y < 2; y++ {
+				for x := 0; x < 3; x++ {
+					p := tt.moved(x, y)
+					if got, want := flipped.At(p.X, p.Y), src.NRGBAAt(5+x, 5+y); got != want {
+						t.Errorf("pixel %v = %v, want %v from (%d, %d)", p, got, want, x, y)
+					}
+				}
+			}
+		})
+	}
+}
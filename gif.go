@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// isGIF reports whether path has a .gif extension.
+func isGIF(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".gif")
+}
+
+// AddWatermarkGIF blends the watermark onto every frame of the GIF at
+// mainImagePath and saves the animation to outPath. Frame delays, disposal
+// methods and the loop count are preserved. See AddWatermark for the
+// placement and sizing rules.
+func AddWatermarkGIF(mainImagePath string, waterMarkImg image.Image, outPath, anchor string, x, y, height, width int, opts ...Option) error {
+	spec := WatermarkSpec{Image: waterMarkImg, Anchor: anchor, X: x, Y: y, Height: height, Width: width}
+	return addWatermarksGIF(mainImagePath, []WatermarkSpec{spec}, outPath, newOptions(opts))
+}
+
+// addWatermarksGIF is AddWatermarkGIF for several watermarks.
+func addWatermarksGIF(mainImagePath string, specs []WatermarkSpec, outPath string, o *options) error {
+	file, err := os.Open(mainImagePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if o.maxDim > 0 {
+		config, err := gif.DecodeConfig(file)
+		if err != nil {
+			return err
+		}
+		err = checkDimensions(config.Width, config.Height, o.maxDim)
+		if err != nil {
+			return fmt.Errorf("%s: %w", mainImagePath, err)
+		}
+
+		_, err = file.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+	}
+
+	// a truncated animation fails as ErrCorrupt, as ReadImage does
+	er := &eofReader{r: file}
+	anim, err := gif.DecodeAll(er)
+	if err != nil {
+		return fmt.Errorf("%s: %w", mainImagePath, decodeError(err, er))
+	}
+
+	mainBounds := image.Rect(0, 0, anim.Config.Width, anim.Config.Height)
+	o.stats.observe(mainBounds)
+	o.logf("read %s: %dx%d gif, %d frames", mainImagePath, mainBounds.Dx(), mainBounds.Dy(), len(anim.Image))
+
+	if o.crop {
+		err = checkCrop(o.cropRect, mainBounds)
+		if err != nil {
+			return err
+		}
+		mainBounds = image.Rect(0, 0, o.cropRect.Dx(), o.cropRect.Dy())
+	}
+
+	specs, err = prepareWatermarks(specs, mainBounds, o)
+	if err != nil {
+		return err
+	}
+
+	err = watermarkFrames(anim, specs, o)
+	if err != nil {
+		return err
+	}
+
+	err = writeOutput(outPath, o, func(w io.Writer) error {
+		return gif.EncodeAll(w, anim)
+	})
+	if err != nil {
+		return err
+	}
+	o.logf("wrote %s: %dx%d gif, %d frames", outPath, anim.Config.Width, anim.Config.Height, len(anim.Image))
+
+	return nil
+}
+
+// watermarkFrames replaces every frame of anim with a full-canvas copy of
+// how it looks when played, with the watermarks blended on top. Frames are
+// typically only the region that changed since the previous frame, so
+// they are composited onto a running canvas following each frame's
+// disposal method before blending. Whatever is chosen from the pixels, see
+// settleSpecs, is chosen on the first frame and kept for the others. When
+// cropping, the frames and the logical screen of anim are reduced to the
+// crop.
+func watermarkFrames(anim *gif.GIF, specs []WatermarkSpec, o *options) error {
+	bounds := image.Rect(0, 0, anim.Config.Width, anim.Config.Height)
+	canvas := image.NewNRGBA(bounds)
+
+	stamp, err := prepareStamp(o)
+	if err != nil {
+		return err
+	}
+
+	outBounds := bounds
+	if o.crop {
+		outBounds = image.Rect(0, 0, o.cropRect.Dx(), o.cropRect.Dy())
+	}
+
+	for i, frame := range anim.Image {
+		if err := o.ctx.Err(); err != nil {
+			return err
+		}
+
+		disposal := byte(gif.DisposalNone)
+		if i < len(anim.Disposal) {
+			disposal = anim.Disposal[i]
+		}
+
+		var previous *image.NRGBA
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewNRGBA(bounds)
+			copy(previous.Pix, canvas.Pix)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+
+		var composed *image.NRGBA
+		if o.crop {
+			cropped, err := CropImage(canvas, o.cropRect)
+			if err != nil {
+				return err
+			}
+			composed = toNRGBA(cropped)
+		} else {
+			composed = image.NewNRGBA(bounds)
+			copy(composed.Pix, canvas.Pix)
+		}
+		if o.grayscale {
+			composed = toNRGBA(ToGrayscale(composed))
+		}
+		if o.brightness != 0 || o.contrast != 0 {
+			composed = toNRGBA(AdjustBrightnessContrast(composed, o.brightness, o.contrast))
+		}
+		if stamp != nil {
+			err = tileWatermark(composed, stamp, o.stampOptions.Gap, o.stampOptions.rowGap(), o)
+			if err != nil {
+				return err
+			}
+		}
+		if i == 0 {
+			specs, err = settleSpecs(composed, specs, o)
+			if err != nil {
+				return err
+			}
+		}
+		for _, spec := range specs {
+			err := applyWatermark(composed, spec, o)
+			if err != nil {
+				return err
+			}
+		}
+
+		palette := frame.Palette
+		if o.cornerRadius > 0 {
+			roundCorners(composed, o.cornerRadius, nil)
+			palette, err = transparentPalette(palette)
+			if err != nil {
+				return err
+			}
+		}
+
+		// map the blended pixels back onto the frame's own palette
+		paletted := image.NewPaletted(outBounds, palette)
+		draw.Draw(paletted, outBounds, composed, image.Point{}, draw.Src)
+		anim.Image[i] = paletted
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+
+	anim.Config.Width, anim.Config.Height = outBounds.Dx(), outBounds.Dy()
+
+	return nil
+}
+
+// transparentPalette returns p, or a copy of it with a fully transparent
+// color added when it has none, so rounded corners can be cleared. A full
+// palette without a transparent color cannot be given one.
+func transparentPalette(p color.Palette) (color.Palette, error) {
+	for _, c := range p {
+		if _, _, _, a := c.RGBA(); a == 0 {
+			return p, nil
+		}
+	}
+
+	if len(p) >= 256 {
+		return nil, errors.New("rounded corners need a transparent color, but the gif palette is full")
+	}
+
+	withTransparent := make(color.Palette, len(p), len(p)+1)
+	copy(withTransparent, p)
+	return append(withTransparent, color.Transparent), nil
+}
@@ -1,3 +1,15 @@
 module watermark-generator
 
 go 1.19
+
+require (
+	github.com/chai2010/webp v1.4.0
+	github.com/srwiley/oksvg v0.0.0-20221011165216-be6e8873101c
+	github.com/srwiley/rasterx v0.0.0-20220730225603-2ab79fcdd4ef
+	golang.org/x/image v0.24.0
+)
+
+require (
+	golang.org/x/net v0.0.0-20211118161319-6a13c67c3ce4 // indirect
+	golang.org/x/text v0.22.0 // indirect
+)
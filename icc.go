@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+)
+
+const (
+	// iccTag starts every APP2 segment of a JPEG holding a piece of its
+	// ICC profile, followed by the piece number and the number of pieces.
+	iccTag = "ICC_PROFILE\x00"
+	// maxICCPiece is the most profile data that fits in one APP2 segment.
+	maxICCPiece = 0xffff - 2 - len(iccTag) - 2
+	// maxPNGChunk bounds the PNG header chunks read for the ICC profile,
+	// and the decompressed profile, so a corrupt length cannot exhaust
+	// memory.
+	maxPNGChunk = 16 << 20
+)
+
+// pngSignature starts every PNG stream.
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// iccProfile returns the ICC profile held by m, or nil when there is none.
+func (m *Metadata) iccProfile() []byte {
+	if m == nil {
+		return nil
+	}
+	if m.icc != nil {
+		return m.icc
+	}
+
+	// a JPEG spreads the profile over APP2 segments, which come in order
+	var profile []byte
+	for _, segment := range m.segments {
+		if segment.marker == 0xe2 && len(segment.data) >= len(iccTag)+2 && string(segment.data[:len(iccTag)]) == iccTag {
+			profile = append(profile, segment.data[len(iccTag)+2:]...)
+		}
+	}
+	return profile
+}
+
+// jpegSegments returns the segments of m to write into a JPEG, with its
+// text fields as comments and the ICC profile of a PNG split into APP2
+// segments.
+func (m *Metadata) jpegSegments() []jpegSegment {
+	segments := m.segments[:len(m.segments):len(m.segments)]
+	for _, f := range m.text {
+		segments = append(segments, jpegSegment{marker: 0xfe, data: f.comment()})
+	}
+	if m.icc == nil {
+		return segments
+	}
+
+	count := (len(m.icc) + maxICCPiece - 1) / maxICCPiece
+	for i := 0; i < count; i++ {
+		end := (i + 1) * maxICCPiece
+		if end > len(m.icc) {
+			end = len(m.icc)
+		}
+
+		data := append([]byte(iccTag), byte(i+1), byte(count))
+		data = append(data, m.icc[i*maxICCPiece:end]...)
+		segments = append(segments, jpegSegment{marker: 0xe2, data: data})
+	}
+	return segments
+}
+
+// readPNGHeader reads the chunks of the PNG stream r up to its image data
+// and returns the ICC profile of its iCCP chunk, or nil, along with a
+// reader that replays the whole stream from the start. A malformed header
+// ends the search early and is left for the PNG decoder to report.
+func readPNGHeader(r io.Reader) ([]byte, io.Reader) {
+	hr := newHeaderReader(r)
+
+	var signature [8]byte
+	if _, err := io.ReadFull(hr, signature[:]); err != nil || string(signature[:]) != pngSignature {
+		return nil, hr.replay()
+	}
+
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(hr, header[:]); err != nil {
+			return nil, hr.replay()
+		}
+		n := binary.BigEndian.Uint32(header[:4])
+		chunkType := string(header[4:])
+		if chunkType == "IDAT" || chunkType == "IEND" || n > maxPNGChunk {
+			return nil, hr.replay()
+		}
+
+		// the data is followed by its CRC
+		data := make([]byte, n+4)
+		if _, err := io.ReadFull(hr, data); err != nil {
+			return nil, hr.replay()
+		}
+		if chunkType == "iCCP" {
+			return parseICCP(data[:n]), hr.replay()
+		}
+	}
+}
+
+// parseICCP returns the decompressed profile of the data of an iCCP chunk,
+// which is a name, a compression method and the zlib stream, or nil when
+// it is malformed.
+func parseICCP(data []byte) []byte {
+	name := bytes.IndexByte(data, 0)
+	if name < 0 || name+2 > len(data) || data[name+1] != 0 {
+		return nil
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(data[name+2:]))
+	if err != nil {
+		return nil
+	}
+	defer zr.Close()
+
+	profile, err := io.ReadAll(io.LimitReader(zr, maxPNGChunk))
+	if err != nil || len(profile) == 0 {
+		return nil
+	}
+	return profile
+}
+
+// decodePNG is png.Decode that also returns the ICC profile of the image
+// as metadata, which is nil when it has none.
+func decodePNG(r io.Reader) (image.Image, *Metadata, error) {
+	profile, r := readPNGHeader(r)
+
+	img, err := png.Decode(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if profile == nil {
+		return img, nil, nil
+	}
+	return img, &Metadata{icc: profile}, nil
+}
+
+// encodePNG encodes img with encoder and inserts the ICC profile of meta as
+// an iCCP chunk and its text fields as iTXt chunks right after the image
+// header, where the PNG specification requires the profile to be.
+func encodePNG(w io.Writer, img image.Image, encoder *png.Encoder, meta *Metadata) error {
+	var chunks []byte
+	if profile := meta.iccProfile(); profile != nil {
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		_, err := zw.Write(profile)
+		if err != nil {
+			return err
+		}
+		err = zw.Close()
+		if err != nil {
+			return err
+		}
+		chunks = appendPNGChunk(chunks, "iCCP", append([]byte("ICC Profile\x00\x00"), compressed.Bytes()...))
+	}
+	if meta != nil {
+		for _, f := range meta.text {
+			// uncompressed UTF-8 text without a language tag
+			data := append([]byte(f.key), 0, 0, 0, 0, 0)
+			chunks = appendPNGChunk(chunks, "iTXt", append(data, f.value...))
+		}
+	}
+
+	if chunks == nil {
+		return encoder.Encode(w, img)
+	}
+
+	var buf bytes.Buffer
+	err := encoder.Encode(&buf, img)
+	if err != nil {
+		return err
+	}
+	encoded := buf.Bytes()
+
+	// the signature and the image header chunk, with its 13 bytes of data
+	headerEnd := len(pngSignature) + 8 + 13 + 4
+	_, err = w.Write(encoded[:headerEnd])
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(chunks)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(encoded[headerEnd:])
+	return err
+}
+
+// appendPNGChunk appends a chunk of the type typ holding data, with its
+// length and checksum, to b.
+func appendPNGChunk(b []byte, typ string, data []byte) []byte {
+	start := len(b)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(data)))
+	b = append(b, typ...)
+	b = append(b, data...)
+	return binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b[start+4:]))
+}
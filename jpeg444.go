@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+)
+
+// Subsampling selects the chroma subsampling of JPEG output.
+type Subsampling int
+
+const (
+	// Subsampling420 stores the color at half the resolution on both axes,
+	// which image/jpeg always uses. It gives the smallest files.
+	Subsampling420 Subsampling = iota
+	// Subsampling444 stores the color at full resolution, which keeps the
+	// colored edges of text and thin lines from bleeding.
+	Subsampling444
+)
+
+// subsamplings maps the -subsampling flag values to their Subsampling.
+var subsamplings = map[string]Subsampling{
+	"420": Subsampling420,
+	"444": Subsampling444,
+}
+
+// zigzag maps the position of a coefficient in the order JPEG stores them
+// to its position in the 8x8 block.
+var zigzag = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10, 17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34, 27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36, 29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46, 53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// baseQuant holds the example luminance and chrominance quantization
+// tables of the JPEG specification, which image/jpeg scales by quality too.
+var baseQuant = [2][64]int{
+	{
+		16, 11, 10, 16, 24, 40, 51, 61,
+		12, 12, 14, 19, 26, 58, 60, 55,
+		14, 13, 16, 24, 40, 57, 69, 56,
+		14, 17, 22, 29, 51, 87, 80, 62,
+		18, 22, 37, 56, 68, 109, 103, 77,
+		24, 35, 55, 64, 81, 104, 113, 92,
+		49, 64, 78, 87, 103, 121, 120, 101,
+		72, 92, 95, 98, 112, 100, 103, 99,
+	},
+	{
+		17, 18, 24, 47, 99, 99, 99, 99,
+		18, 21, 26, 66, 99, 99, 99, 99,
+		24, 26, 56, 99, 99, 99, 99, 99,
+		47, 66, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+	},
+}
+
+// huffmanSpec is a Huffman table as stored in a JPEG: the number of codes
+// of each length from 1 to 16 bits and the values in order of their codes.
+type huffmanSpec struct {
+	counts [16]byte
+	values []byte
+}
+
+// huffmanSpecs holds the example DC luminance, AC luminance, DC
+// chrominance and AC chrominance tables of the JPEG specification.
+var huffmanSpecs = [4]huffmanSpec{
+	{
+		[16]byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		[16]byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 0x7d},
+		[]byte{
+			0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12, 0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+			0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08, 0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+			0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+			0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+			0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+			0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79, 0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+			0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+			0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+			0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+			0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea, 0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+	{
+		[16]byte{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		[16]byte{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 0x77},
+		[]byte{
+			0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21, 0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+			0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91, 0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+			0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34, 0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+			0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38, 0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+			0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+			0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+			0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+			0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+			0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+			0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+}
+
+// huffmanCode is the code of a value and its length in bits.
+type huffmanCode struct {
+	code uint32
+	size uint
+}
+
+// huffmanCodes holds the codes of every value of each of huffmanSpecs.
+var huffmanCodes = func() (tables [4][256]huffmanCode) {
+	for i, spec := range huffmanSpecs {
+		var code uint32
+		k := 0
+		for length, count := range spec.counts {
+			for j := 0; j < int(count); j++ {
+				tables[i][spec.values[k]] = huffmanCode{code: code, size: uint(length + 1)}
+				code++
+				k++
+			}
+			code <<= 1
+		}
+	}
+	return tables
+}()
+
+// dctCos holds C(u)/2 * cos((2x+1)uπ/16) of the forward DCT at [u][x].
+var dctCos = func() (table [8][8]float64) {
+	for u := 0; u < 8; u++ {
+		scale := 0.5
+		if u == 0 {
+			scale = 0.5 / math.Sqrt2
+		}
+		for x := 0; x < 8; x++ {
+			table[u][x] = scale * math.Cos(float64(2*x+1)*float64(u)*math.Pi/16)
+		}
+	}
+	return table
+}()
+
+// scaledQuant returns the quantization tables for quality from 1 to 100,
+// scaled like image/jpeg does.
+func scaledQuant(quality int) (tables [2][64]int) {
+	scale := 200 - 2*quality
+	if quality < 50 {
+		scale = 5000 / quality
+	}
+
+	for i := range baseQuant {
+		for j, q := range baseQuant[i] {
+			v := (q*scale + 50) / 100
+			if v < 1 {
+				v = 1
+			} else if v > 255 {
+				v = 255
+			}
+			tables[i][j] = v
+		}
+	}
+	return tables
+}
+
+// jpegWriter writes the markers and the entropy coded data of a JPEG.
+type jpegWriter struct {
+	w     *bufio.Writer
+	bits  uint32
+	nBits uint
+	err   error
+}
+
+func (jw *jpegWriter) write(p []byte) {
+	if jw.err == nil {
+		_, jw.err = jw.w.Write(p)
+	}
+}
+
+// writeMarker writes a marker segment with its length.
+func (jw *jpegWriter) writeMarker(marker byte, data []byte) {
+	n := len(data) + 2
+	jw.write([]byte{0xff, marker, byte(n >> 8), byte(n)})
+	jw.write(data)
+}
+
+// writeBits appends the low size bits of code to the entropy coded data,
+// stuffing a zero byte after every 0xff as JPEG requires.
+func (jw *jpegWriter) writeBits(code uint32, size uint) {
+	jw.bits = jw.bits<<size | code&(1<<size-1)
+	jw.nBits += size
+	for jw.nBits >= 8 {
+		b := byte(jw.bits >> (jw.nBits - 8))
+		jw.write([]byte{b})
+		if b == 0xff {
+			jw.write([]byte{0})
+		}
+		jw.nBits -= 8
+	}
+	jw.bits &= 1<<jw.nBits - 1
+}
+
+// flushBits pads the entropy coded data to a whole byte with one bits.
+func (jw *jpegWriter) flushBits() {
+	if jw.nBits > 0 {
+		jw.writeBits(1<<(8-jw.nBits)-1, 8-jw.nBits)
+	}
+}
+
+// writeValue writes the Huffman code from table of the run of zeros before
+// value and its size in bits, followed by the bits of value.
+func (jw *jpegWriter) writeValue(table int, run byte, value int) {
+	size := uint(0)
+	for v := abs(value); v > 0; v >>= 1 {
+		size++
+	}
+
+	code := huffmanCodes[table][run<<4|byte(size)]
+	jw.writeBits(code.code, code.size)
+	if value < 0 {
+		value--
+	}
+	jw.writeBits(uint32(value), size)
+}
+
+// abs returns the absolute value of v.
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// encodeJPEG444 encodes img as a baseline JPEG with full resolution color,
+// which image/jpeg cannot write, using the tables image/jpeg uses for
+// quality. Transparent pixels are written as their color on black, like
+// image/jpeg does. With progressive the JPEG is written as a progressive
+// one instead, with a scan of the DC coefficients of all components first
+// and then one of the AC coefficients of each component, so browsers can
+// show a coarse version of it before it has all been loaded.
+func encodeJPEG444(w io.Writer, img image.Image, quality int, progressive bool) error {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width <= 0 || height <= 0 || width > 0xffff || height > 0xffff {
+		return fmt.Errorf("jpeg size %dx%d must be between 1 and 65535 pixels", width, height)
+	}
+
+	jw := &jpegWriter{w: bufio.NewWriter(w)}
+	quant := scaledQuant(quality)
+
+	jw.write([]byte{0xff, 0xd8})
+
+	dqt := make([]byte, 0, 2*65)
+	for i := range quant {
+		dqt = append(dqt, byte(i))
+		for _, pos := range zigzag {
+			dqt = append(dqt, byte(quant[i][pos]))
+		}
+	}
+	jw.writeMarker(0xdb, dqt)
+
+	// three components sampled 1x1, the chroma ones with the second table,
+	// in a baseline or a progressive frame
+	sof := byte(0xc0)
+	if progressive {
+		sof = 0xc2
+	}
+	jw.writeMarker(sof, []byte{
+		8, byte(height >> 8), byte(height), byte(width >> 8), byte(width), 3,
+		1, 0x11, 0,
+		2, 0x11, 1,
+		3, 0x11, 1,
+	})
+
+	var dht []byte
+	for i, spec := range huffmanSpecs {
+		// DC tables are class 0, AC tables class 1
+		dht = append(dht, byte(i%2)<<4|byte(i/2))
+		dht = append(dht, spec.counts[:]...)
+		dht = append(dht, spec.values...)
+	}
+	jw.writeMarker(0xc4, dht)
+
+	blocks := quantizeImage(img, &quant)
+
+	if !progressive {
+		jw.writeMarker(0xda, []byte{3, 1, 0x00, 2, 0x11, 3, 0x11, 0, 63, 0})
+		var prevDC [3]int
+		for i := range blocks[0] {
+			for c := range blocks {
+				prevDC[c] = jw.writeDC(&blocks[c][i], prevDC[c], 2*componentTable(c))
+				jw.writeAC(&blocks[c][i], 2*componentTable(c)+1)
+			}
+		}
+		jw.flushBits()
+	} else {
+		// the scans end on a whole byte as the next marker follows them
+		jw.writeMarker(0xda, []byte{3, 1, 0x00, 2, 0x10, 3, 0x10, 0, 0, 0})
+		var prevDC [3]int
+		for i := range blocks[0] {
+			for c := range blocks {
+				prevDC[c] = jw.writeDC(&blocks[c][i], prevDC[c], 2*componentTable(c))
+			}
+		}
+		jw.flushBits()
+
+		for c := range blocks {
+			jw.writeMarker(0xda, []byte{1, byte(c + 1), byte(componentTable(c)), 1, 63, 0})
+			for i := range blocks[c] {
+				jw.writeAC(&blocks[c][i], 2*componentTable(c)+1)
+			}
+			jw.flushBits()
+		}
+	}
+
+	jw.write([]byte{0xff, 0xd9})
+	if jw.err != nil {
+		return jw.err
+	}
+	return jw.w.Flush()
+}
+
+// componentTable returns the quantization and Huffman table index of the
+// component c: 0 for luma and 1 for chroma.
+func componentTable(c int) int {
+	if c > 0 {
+		return 1
+	}
+	return 0
+}
+
+// quantizeImage returns the quantized DCT coefficients of the 8x8 blocks
+// of the Y, Cb and Cr components of img, in the order the blocks are
+// coded.
+func quantizeImage(img image.Image, quant *[2][64]int) [3][][64]int16 {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+
+	var coeffs [3][][64]int16
+	var blocks [3][64]float64
+	for by := 0; by < height; by += 8 {
+		for bx := 0; bx < width; bx += 8 {
+			for y := 0; y < 8; y++ {
+				// blocks past the edge repeat the last row and column
+				sy := by + y
+				if sy >= height {
+					sy = height - 1
+				}
+				for x := 0; x < 8; x++ {
+					sx := bx + x
+					if sx >= width {
+						sx = width - 1
+					}
+					r, g, bl, _ := img.At(b.Min.X+sx, b.Min.Y+sy).RGBA()
+					yy, cb, cr := color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(bl>>8))
+					blocks[0][y*8+x] = float64(yy) - 128
+					blocks[1][y*8+x] = float64(cb) - 128
+					blocks[2][y*8+x] = float64(cr) - 128
+				}
+			}
+
+			for c := range blocks {
+				q := quantizeBlock(&blocks[c], &quant[componentTable(c)])
+				var block [64]int16
+				for k, v := range q {
+					block[k] = int16(v)
+				}
+				coeffs[c] = append(coeffs[c], block)
+			}
+		}
+	}
+	return coeffs
+}
+
+// quantizeBlock returns the quantized DCT coefficients of an 8x8 block of
+// level-shifted samples, in the order of the block.
+func quantizeBlock(block *[64]float64, quant *[64]int) (coeffs [64]int) {
+	var rows [64]float64
+	for y := 0; y < 8; y++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for x := 0; x < 8; x++ {
+				sum += dctCos[u][x] * block[y*8+x]
+			}
+			rows[y*8+u] = sum
+		}
+	}
+
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for y := 0; y < 8; y++ {
+				sum += dctCos[v][y] * rows[y*8+u]
+			}
+			coeffs[v*8+u] = int(math.Round(sum / float64(quant[v*8+u])))
+		}
+	}
+	return coeffs
+}
+
+// writeDC writes the DC coefficient of a block with the Huffman table
+// dcTable and returns it, as the next block of the component is coded
+// against it.
+func (jw *jpegWriter) writeDC(coeffs *[64]int16, prevDC, dcTable int) int {
+	dc := int(coeffs[0])
+	jw.writeValue(dcTable, 0, dc-prevDC)
+	return dc
+}
+
+// writeAC writes the AC coefficients of a block with the Huffman table
+// acTable.
+func (jw *jpegWriter) writeAC(coeffs *[64]int16, acTable int) {
+	run := byte(0)
+	for _, pos := range zigzag[1:] {
+		v := int(coeffs[pos])
+		if v == 0 {
+			run++
+			continue
+		}
+		for run > 15 {
+			// a run of sixteen zeros
+			code := huffmanCodes[acTable][0xf0]
+			jw.writeBits(code.code, code.size)
+			run -= 16
+		}
+		jw.writeValue(acTable, run, v)
+		run = 0
+	}
+	if run > 0 {
+		// end of block, a run of one in progressive scans
+		code := huffmanCodes[acTable][0x00]
+		jw.writeBits(code.code, code.size)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// chromaError returns the summed distance of the colors of got from those
+// of want.
+func chromaError(got, want image.Image) int {
+	total := 0
+	b := want.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			g := color.NRGBAModel.Convert(got.At(x, y)).(color.NRGBA)
+			w := color.NRGBAModel.Convert(want.At(x, y)).(color.NRGBA)
+			total += abs(int(g.R)-int(w.R)) + abs(int(g.G)-int(w.G)) + abs(int(g.B)-int(w.B))
+		}
+	}
+	return total
+}
+
+// jpegFrame returns the marker of the frame header of the JPEG in data,
+// 0xc0 for baseline and 0xc2 for progressive, and the data of the header,
+// walking the segments before the first scan.
+func jpegFrame(data []byte) (byte, []byte) {
+	for i := 2; i+4 <= len(data) && data[i] == 0xff; {
+		marker := data[i+1]
+		n := int(binary.BigEndian.Uint16(data[i+2:]))
+		if i+2+n > len(data) || marker == 0xda {
+			break
+		}
+		if marker >= 0xc0 && marker <= 0xcf && marker != 0xc4 && marker != 0xc8 && marker != 0xcc {
+			return marker, data[i+4 : i+2+n]
+		}
+		i += 2 + n
+	}
+	return 0, nil
+}
+
+func TestSubsamplingColorBleed(t *testing.T) {
+	// one pixel wide red strokes, as thin text gives, on blue
+	main := image.NewNRGBA(image.Rect(0, 0, 32, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			main.SetNRGBA(x, y, color.NRGBA{0, 0, 255, 255})
+		}
+	}
+	strokes := image.NewNRGBA(image.Rect(0, 0, 32, 32))
+	for y := 4; y < 28; y++ {
+		for x := 4; x < 28; x += 3 {
+			strokes.SetNRGBA(x, y, color.NRGBA{255, 0, 0, 255})
+		}
+	}
+	want, err := WatermarkImage(main, []WatermarkSpec{{Image: strokes}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		subsampling Subsampling
+		// the horizontal and vertical sampling factors of the luma
+		// component in the frame header
+		luma byte
+	}{
+		{"420", Subsampling420, 0x22},
+		{"444", Subsampling444, 0x11},
+	}
+
+	errs := map[Subsampling]int{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "out.jpg")
+			err := SaveImage(want, path, WithQuality(90), WithSubsampling(tt.subsampling))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the frame header holds the precision, the size and the
+			// component count before the first component
+			marker, frame := jpegFrame(data)
+			if marker != 0xc0 || len(frame) < 8 {
+				t.Fatalf("frame header marker = %#x, want a baseline 0xc0", marker)
+			}
+			if got := frame[7]; got != tt.luma {
+				t.Errorf("luma sampling factors = %#x, want %#x", got, tt.luma)
+			}
+
+			got, err := ReadImage(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			errs[tt.subsampling] = chromaError(got, want)
+		})
+	}
+
+	if errs[Subsampling444] >= errs[Subsampling420] {
+		t.Errorf("4:4:4 is off by %d, want less than the %d of 4:2:0", errs[Subsampling444], errs[Subsampling420])
+	}
+}
+
+func TestSaveImageProgressive(t *testing.T) {
+	// smooth, so even the baseline 4:2:0 output keeps the colors
+	img := image.NewNRGBA(image.Rect(0, 0, 32, 24))
+	for y := 0; y < 24; y++ {
+		for x := 0; x < 32; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 8), uint8(y * 10), 128, 255})
+		}
+	}
+
+	tests := []struct {
+		name string
+		opts []Option
+		want byte
+	}{
+		{"baseline", nil, 0xc0},
+		{"progressive", []Option{WithProgressive()}, 0xc2},
+		{"progressive ignores 4:2:0", []Option{WithProgressive(), WithSubsampling(Subsampling420)}, 0xc2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "out.jpg")
+			err := SaveImage(img, path, append(tt.opts, WithQuality(100))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			marker, frame := jpegFrame(data)
+			if marker != tt.want || len(frame) < 8 {
+				t.Fatalf("frame header marker = %#x, want %#x", marker, tt.want)
+			}
+			if marker == 0xc2 && frame[7] != 0x11 {
+				t.Errorf("progressive luma sampling factors = %#x, want the full resolution 0x11", frame[7])
+			}
+
+			// it still decodes to the image
+			got, err := ReadImage(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Bounds() != img.Rect {
+				t.Fatalf("bounds = %v, want %v", got.Bounds(), img.Rect)
+			}
+			if c, want := color.NRGBAModel.Convert(got.At(5, 5)).(color.NRGBA), img.NRGBAAt(5, 5); !closeNRGBA(c, want, 8) {
+				t.Errorf("pixel = %v, want about %v", c, want)
+			}
+		})
+	}
+
+	err := SaveImage(img, filepath.Join(t.TempDir(), "out.png"), WithProgressive())
+	if err == nil {
+		t.Error("progressive PNG output was accepted")
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"image/color"
+	"math"
+)
+
+// srgbToLinear converts an sRGB channel value from 0 to 1 to linear light.
+func srgbToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// linearToSrgb converts a linear light channel value from 0 to 1 to sRGB.
+func linearToSrgb(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+// linear8 holds srgbToLinear for every 8-bit channel value, which is all
+// blendNRGBAPixelLinear needs.
+var linear8 = func() (table [256]float64) {
+	for i := range table {
+		table[i] = srgbToLinear(float64(i) / 0xff)
+	}
+	return table
+}()
+
+// BlendLinear is Blend carried out in linear light rather than in sRGB.
+// Mixing the gamma-encoded values, as Blend does, makes the edges and
+// translucent parts of a watermark darker than they should look; here
+// the colors are converted to linear light, composited and converted back.
+// The result is a non-premultiplied color.NRGBA64.
+func BlendLinear(watermark color.Color, main color.Color) color.Color {
+	w := color.NRGBA64Model.Convert(watermark).(color.NRGBA64)
+	if w.A == 0 {
+		return main
+	}
+	if w.A == 0xffff {
+		return watermark
+	}
+	m := color.NRGBA64Model.Convert(main).(color.NRGBA64)
+
+	var wc, mc [3]float64
+	for c, v := range [3]uint16{w.R, w.G, w.B} {
+		wc[c] = srgbToLinear(float64(v) / 0xffff)
+	}
+	for c, v := range [3]uint16{m.R, m.G, m.B} {
+		mc[c] = srgbToLinear(float64(v) / 0xffff)
+	}
+
+	out, a := blendLinear(wc, float64(w.A)/0xffff, mc, float64(m.A)/0xffff)
+	return color.NRGBA64{
+		R: uint16(math.Round(out[0] * 0xffff)),
+		G: uint16(math.Round(out[1] * 0xffff)),
+		B: uint16(math.Round(out[2] * 0xffff)),
+		A: uint16(math.Round(a * 0xffff)),
+	}
+}
+
+// blendLinear composites the linear watermark color wc with alpha wa over
+// the linear main color mc with alpha ma. It returns the sRGB color, not
+// premultiplied, and the alpha of the result, all from 0 to 1.
+func blendLinear(wc [3]float64, wa float64, mc [3]float64, ma float64) ([3]float64, float64) {
+	var out [3]float64
+	a := wa + ma*(1-wa)
+	if a == 0 {
+		return out, 0
+	}
+
+	for c := range out {
+		out[c] = linearToSrgb((wc[c]*wa + mc[c]*ma*(1-wa)) / a)
+	}
+	return out, a
+}
+
+// blendNRGBAPixelLinear is blendNRGBAPixel with the arithmetic of
+// BlendLinear.
+func blendNRGBAPixelLinear(d, s []uint8) {
+	if s[3] == 0xff {
+		copy(d, s)
+		return
+	}
+
+	wc := [3]float64{linear8[s[0]], linear8[s[1]], linear8[s[2]]}
+	mc := [3]float64{linear8[d[0]], linear8[d[1]], linear8[d[2]]}
+	out, a := blendLinear(wc, float64(s[3])/0xff, mc, float64(d[3])/0xff)
+	d[0] = uint8(math.Round(out[0] * 0xff))
+	d[1] = uint8(math.Round(out[1] * 0xff))
+	d[2] = uint8(math.Round(out[2] * 0xff))
+	d[3] = uint8(math.Round(a * 0xff))
+}
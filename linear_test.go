@@ -0,0 +1,60 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"testing"
+)
+
+func TestSRGBLinearRoundTrip(t *testing.T) {
+	for v := 0; v <= 255; v++ {
+		s := float64(v) / 255
+		if got := linearToSrgb(srgbToLinear(s)); math.Abs(got-s) > 1e-9 {
+			t.Fatalf("linearToSrgb(srgbToLinear(%v)) = %v", s, got)
+		}
+	}
+	// half the light is well above half the sRGB level
+	if got := linearToSrgb(0.5); math.Abs(got-0.7354) > 1e-3 {
+		t.Errorf("linearToSrgb(0.5) = %v, want about 0.7354", got)
+	}
+}
+
+func TestLinearBlendBrighter(t *testing.T) {
+	black := color.NRGBA{0, 0, 0, 128}
+	white := color.NRGBA{255, 255, 255, 255}
+
+	srgb := color.NRGBAModel.Convert(Blend(black, white)).(color.NRGBA)
+	linear := color.NRGBAModel.Convert(BlendLinear(black, white)).(color.NRGBA)
+	if srgb.R < 126 || srgb.R > 128 {
+		t.Errorf("Blend = %v, want gray about 127", srgb)
+	}
+	// about half the light of white, 0.7354 in sRGB
+	if linear.R < 186 || linear.R > 189 {
+		t.Errorf("BlendLinear = %v, want gray about 188", linear)
+	}
+
+	// and the same through WithLinearBlend
+	main := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	draw.Draw(main, main.Rect, image.NewUniform(white), image.Point{}, draw.Src)
+	wm := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	draw.Draw(wm, wm.Rect, image.NewUniform(black), image.Point{}, draw.Src)
+	tests := []struct {
+		name string
+		opts []Option
+		want color.NRGBA
+	}{
+		{"sRGB", nil, srgb},
+		{"linear", []Option{WithLinearBlend()}, linear},
+	}
+	for _, tt := range tests {
+		out, err := WatermarkImage(main, []WatermarkSpec{{Image: wm}}, tt.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := color.NRGBAModel.Convert(out.At(2, 2)).(color.NRGBA); !closeNRGBA(got, tt.want, 1) {
+			t.Errorf("%s blend of a watermark = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
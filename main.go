@@ -1,114 +1,556 @@
 package main
 
 import (
+	"bytes"
+	"crypto/rand"
 	"errors"
 	"flag"
 	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
-	"image/gif"
 	"image/jpeg"
 	"image/png"
+	"io"
+	"io/fs"
 	"math"
 	"os"
 	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/tiff"
 )
 
-// ReadImage Reads an image file and returns a *image.NRGBA struct
-func ReadImage(path string) (image.Image, error) {
-	var extension string
-	var imgI image.Image // image.Image interface
+// pngCompressionLevels maps the -pngcompression flag values to their
+// compression level.
+var pngCompressionLevels = map[string]png.CompressionLevel{
+	"default": png.DefaultCompression,
+	"none":    png.NoCompression,
+	"speed":   png.BestSpeed,
+	"best":    png.BestCompression,
+}
+
+// supportedFormats lists the image formats in error messages.
+const supportedFormats = "png, jpeg, gif, webp, tiff or bmp"
+
+// readableFormats lists the formats that can be read but not written, as
+// well as supportedFormats, in error messages.
+const readableFormats = "png, jpeg, gif, webp, tiff, bmp, svg or avif"
+
+// ReadImage Reads an image file and returns a *image.NRGBA struct. http and
+// https URLs are downloaded instead of opened. The format is taken from the
+// extension, or from the content when the extension is missing or not an
+// image format. Of the options only WithMaxDimension applies.
+func ReadImage(path string, opts ...Option) (image.Image, error) {
+	imgI, _, err := ReadImageWithMetadata(path, opts...)
+	return imgI, err
+}
+
+// ReadImageWithMetadata is ReadImage that also returns the metadata of a
+// JPEG or the ICC profile of a PNG, which is nil for other formats or an
+// image without any. Pass it to SaveImage with WithMetadata to keep it in
+// the output.
+func ReadImageWithMetadata(path string, opts ...Option) (image.Image, *Metadata, error) {
+	o := newOptions(opts)
+	if isURL(path) {
+		return readImageURL(path, o)
+	}
 
 	// read raw file
 	file, err := os.Open(path)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	defer file.Close()
 
-	// Parse file extension
-	extension = filepath.Ext(path)
-	if extension == "" {
-		return nil, fmt.Errorf("%s has to be of type png, jpeg or gif", path)
+	format, r := detectFormat(path, file)
+	if format == "" {
+		return nil, nil, fmt.Errorf("%s: %w, has to be %s", path, ErrUnsupportedFormat, readableFormats)
 	}
 
-	switch extension {
-	case ".jpg", ".jpeg":
-		imgI, err = jpeg.Decode(file)
-		break
-	case ".png":
-		imgI, err = png.Decode(file)
-		break
-	case ".gif":
-		imgI, err = gif.Decode(file)
-		break
-	default:
-		return nil, fmt.Errorf("%s has to be of type png, jpeg or gif", path)
+	imgI, meta, err := readImageFrom(r, format, o)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
 	}
+	o.logf("read %s: %dx%d %s", path, imgI.Bounds().Dx(), imgI.Bounds().Dy(), format)
+
+	return imgI, meta, nil
+}
 
+// ReadImageFS is ReadImage for a file of fsys, such as a logo embedded in
+// the binary with //go:embed. The format is found from name and the
+// content like ReadImage does.
+func ReadImageFS(fsys fs.FS, name string, opts ...Option) (image.Image, error) {
+	file, err := fsys.Open(name)
 	if err != nil {
 		return nil, err
 	}
+	defer file.Close()
+
+	format, r := detectFormat(name, file)
+	if format == "" {
+		return nil, fmt.Errorf("%s: %w, has to be %s", name, ErrUnsupportedFormat, readableFormats)
+	}
+
+	imgI, _, err := readImageFrom(r, format, newOptions(opts))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
 
-	// Cast the interface to struct
 	return imgI, nil
 }
 
-// SaveImage Saves an image file into the secondary storage
-func SaveImage(img image.Image, path string) error {
-	var extension string
+// ReadImageConfig returns the dimensions and color model of an image file
+// without decoding the whole image. Of the options only WithPage applies.
+func ReadImageConfig(path string, opts ...Option) (image.Config, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return image.Config{}, err
+	}
+	defer file.Close()
+
+	format, r := detectFormat(path, file)
+	if format == "" {
+		return image.Config{}, fmt.Errorf("%s: %w, has to be %s", path, ErrUnsupportedFormat, readableFormats)
+	}
 
-	// read raw file
-	file, err := os.Create(path)
+	config, err := readImageConfigFrom(r, format, newOptions(opts))
 	if err != nil {
-		return err
+		return image.Config{}, fmt.Errorf("%s: %w", path, err)
 	}
 
-	// Parse file extension
-	extension = filepath.Ext(path)
-	if extension == "" {
-		return fmt.Errorf("%s has to be of type png, jpeg or gif", path)
+	return config, nil
+}
+
+// ReadImageConfigFrom returns the dimensions and color model of an image of
+// the given format read from r, as accepted by ReadImageFrom.
+func ReadImageConfigFrom(r io.Reader, format string) (image.Config, error) {
+	return readImageConfigFrom(r, format, newOptions(nil))
+}
+
+// readImageConfigFrom is ReadImageConfigFrom with the options of the
+// decoder applied.
+func readImageConfigFrom(r io.Reader, format string, o *options) (image.Config, error) {
+	dec, ok := registeredDecoder(format)
+	if !ok {
+		return image.Config{}, fmt.Errorf("%w %q, has to be %s", ErrUnsupportedFormat, format, readableFormats)
 	}
 
-	switch extension {
-	case ".jpg", ".jpeg":
-		err = jpeg.Encode(file, img, nil)
-		break
-	case ".png":
-		err = png.Encode(file, img)
-		break
-	case ".gif":
-		err = gif.Encode(file, img, nil)
-		break
-	default:
-		return fmt.Errorf("%s has to be of type png, jpeg or gif", path)
+	var err error
+	if dec.prepare != nil {
+		r, err = dec.prepare(r, o)
+		if err != nil {
+			return image.Config{}, err
+		}
+	}
+
+	// registered decoders only give whole images
+	if dec.config == nil {
+		img, _, err := dec.decode(r)
+		if err != nil {
+			return image.Config{}, err
+		}
+		return image.Config{ColorModel: img.ColorModel(), Width: img.Bounds().Dx(), Height: img.Bounds().Dy()}, nil
+	}
+
+	return dec.config(r)
+}
+
+// ReadImageFrom decodes an image of the given format ("jpg", "jpeg", "png",
+// "gif", "webp", "tif", "tiff", "bmp", "svg" or "avif") from r. The format
+// is matched case-insensitively. AVIF needs a build with the avif tag.
+// Formats registered with RegisterDecoder are read as well. JPEGs are
+// turned upright according to their EXIF orientation. SVGs are rasterized
+// at the size of their view box, and again at the new size by ResizeImage.
+// CMYK and paletted images are converted to *image.NRGBA so that watermarks
+// blend onto them in RGB like onto any other image.
+func ReadImageFrom(r io.Reader, format string, opts ...Option) (image.Image, error) {
+	imgI, _, err := readImageFrom(r, format, newOptions(opts))
+	return imgI, err
+}
+
+// readImageFrom is ReadImageFrom that also returns the metadata of a JPEG
+// or PNG.
+func readImageFrom(r io.Reader, format string, o *options) (image.Image, *Metadata, error) {
+	dec, ok := registeredDecoder(format)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w %q, has to be %s", ErrUnsupportedFormat, format, readableFormats)
+	}
+
+	var err error
+	if dec.prepare != nil {
+		r, err = dec.prepare(r, o)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// decoders that run out of data report it in all sorts of ways
+	er := &eofReader{r: r}
+	r = er
+
+	if o.maxDim > 0 && dec.config != nil {
+		// check the size in the header before the pixels are allocated,
+		// then decode from the start again
+		var header bytes.Buffer
+		config, err := dec.config(io.TeeReader(r, &header))
+		if err != nil {
+			return nil, nil, decodeError(err, er)
+		}
+
+		err = checkDimensions(config.Width, config.Height, o.maxDim)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		r = io.MultiReader(&header, r)
+	}
+
+	imgI, meta, err := dec.decode(r)
+	if err != nil {
+		return nil, nil, decodeError(err, er)
+	}
+
+	// without a header decoder the size is only known now
+	if o.maxDim > 0 && dec.config == nil {
+		err = checkDimensions(imgI.Bounds().Dx(), imgI.Bounds().Dy(), o.maxDim)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	o.stats.observe(imgI.Bounds())
+
+	return normalizeColorModel(imgI), meta, nil
+}
+
+// eofReader is an io.Reader that remembers whether the end of the data was
+// reached.
+type eofReader struct {
+	r   io.Reader
+	eof bool
+}
+
+func (er *eofReader) Read(p []byte) (int, error) {
+	n, err := er.r.Read(p)
+	if err == io.EOF {
+		er.eof = true
+	}
+	return n, err
+}
+
+// decodeError returns the error err of a decoder reading from er, marked as
+// ErrCorrupt when the decoder ran out of data, which is how a truncated
+// file fails. Features the decoders do not support are reported as they
+// are, since some decoders read all of the data before they find them.
+func decodeError(err error, er *eofReader) error {
+	var pngErr png.UnsupportedError
+	var jpegErr jpeg.UnsupportedError
+	var tiffErr tiff.UnsupportedError
+	if errors.As(err, &pngErr) || errors.As(err, &jpegErr) || errors.As(err, &tiffErr) {
+		return err
 	}
 
+	if er.eof || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
 	return err
 }
 
-func ResizeImage(img image.Image, height, width int) (image.Image, error) {
+// checkDimensions checks that a width x height image is no wider or taller
+// than maxDim pixels.
+func checkDimensions(width, height, maxDim int) error {
+	if width > maxDim || height > maxDim {
+		return fmt.Errorf("%dx%d %w, the maximum is %d pixels per side", width, height, ErrTooLarge, maxDim)
+	}
+	return nil
+}
+
+// normalizeColorModel converts CMYK and paletted images to *image.NRGBA
+// with the same bounds and returns other images unchanged.
+func normalizeColorModel(img image.Image) image.Image {
+	switch img.(type) {
+	case *image.CMYK, *image.Paletted:
+		newImg := image.NewNRGBA(img.Bounds())
+		draw.Draw(newImg, newImg.Bounds(), img, img.Bounds().Min, draw.Src)
+		return newImg
+	}
+
+	return img
+}
+
+// SaveImage Saves an image file into the secondary storage. The format
+// is taken from the extension of path unless set with WithFormat. An
+// existing file at path is only replaced with WithOverwrite, and only once
+// the new one has been encoded completely.
+func SaveImage(img image.Image, path string, opts ...Option) error {
+	if img == nil {
+		return ErrNilImage
+	}
+
+	o := newOptions(opts)
+	format := outputFormat(path, o)
+	if !isWritableFormat(format) {
+		if o.format != "" {
+			return fmt.Errorf("%w %q, has to be %s", ErrUnsupportedFormat, format, supportedFormats)
+		}
+		return fmt.Errorf("%s: %w, has to be %s", path, ErrUnsupportedFormat, supportedFormats)
+	}
+
+	err := writeOutput(path, o, func(w io.Writer) error {
+		return WriteImageTo(w, img, format, opts...)
+	})
+	if err != nil {
+		return err
+	}
+	o.logf("wrote %s: %dx%d %s", path, img.Bounds().Dx(), img.Bounds().Dy(), format)
+
+	return nil
+}
+
+// writeOutput writes the output file at path with write. The data goes to
+// a temporary file next to path, which is renamed to path once it is
+// complete, so a failed or interrupted encode never leaves a truncated file
+// behind nor touches an existing one. An existing file is only replaced
+// with WithOverwrite, and a symlink at path is followed so its target is
+// replaced rather than the link.
+func writeOutput(path string, o *options, write func(w io.Writer) error) error {
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+
+	// checked up front too, so nothing is encoded for a file that stays
+	_, err := os.Lstat(path)
+	if err == nil && !o.overwrite {
+		return fmt.Errorf("%s: %w", path, fs.ErrExist)
+	}
+
+	// an existing file keeps its permissions, a new one gets what the
+	// umask leaves of 0666 as with os.Create
+	var mode fs.FileMode
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := createTemp(path)
+	if err != nil {
+		return err
+	}
+	// fails harmlessly once the file has been renamed
+	defer os.Remove(tmp.Name())
+
+	err = write(tmp)
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	// the data may only reach the disk on close, so its error counts too
+	err = tmp.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	if mode != 0 {
+		err = os.Chmod(tmp.Name(), mode)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !o.overwrite {
+		// a hard link never replaces a file created in the meantime
+		err = os.Link(tmp.Name(), path)
+		if errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("%s: %w", path, fs.ErrExist)
+		}
+		if err == nil {
+			return nil
+		}
+		// not every file system has hard links, rename there
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// createTemp creates a new temporary file next to path to write it through.
+// Unlike os.CreateTemp, which always uses 0600, the file is created with
+// 0666 so the umask applies.
+func createTemp(path string) (*os.File, error) {
+	for try := 0; ; try++ {
+		var suffix [4]byte
+		_, err := rand.Read(suffix[:])
+		if err != nil {
+			return nil, err
+		}
+
+		name := filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.%x.tmp", filepath.Base(path), suffix))
+		file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o666)
+		if errors.Is(err, fs.ErrExist) && try < 100 {
+			continue
+		}
+		return file, err
+	}
+}
+
+// outputFormat returns the format an image saved to path is written in.
+func outputFormat(path string, o *options) string {
+	if o.format != "" {
+		return o.format
+	}
+	return strings.TrimPrefix(filepath.Ext(path), ".")
+}
+
+// WriteImageTo encodes img to w in the given format ("jpg", "jpeg", "png",
+// "gif", "webp", "tif", "tiff" or "bmp"), or one registered with
+// RegisterEncoder. The format is matched case-insensitively.
+func WriteImageTo(w io.Writer, img image.Image, format string, opts ...Option) error {
+	if img == nil {
+		return ErrNilImage
+	}
+
+	encode, ok := registeredEncoder(format)
+	if !ok {
+		return fmt.Errorf("%w %q, has to be %s", ErrUnsupportedFormat, format, supportedFormats)
+	}
+
+	o := newOptions(opts)
+
+	if o.progressive && !strings.EqualFold(format, "jpg") && !strings.EqualFold(format, "jpeg") {
+		return fmt.Errorf("progressive encoding is only supported for jpeg, not %q", format)
+	}
+
+	return encode(w, img, o)
+}
+
+// ResizeImage scales img to width x height pixels. Like image.Rect, the
+// width comes before the height. Nearest-neighbour sampling is used unless
+// another Resample is selected with WithResample. With WithPreserveDepth a
+// 16-bit image is resized to an *image.NRGBA64, other images are resized
+// to an *image.NRGBA.
+func ResizeImage(img image.Image, width, height int, opts ...Option) (image.Image, error) {
 	if img == nil {
-		return nil, errors.New("image is nil")
+		return nil, ErrNilImage
+	}
+
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("%w %dx%d, width and height must be positive", ErrInvalidSize, width, height)
+	}
+
+	o := newOptions(opts)
+
+	// vectors are drawn again at the new size rather than resampled
+	if svg, ok := img.(*svgImage); ok {
+		return &svgImage{NRGBA: rasterizeSVG(svg.icon, width, height), icon: svg.icon}, nil
 	}
 
 	currentBounds := img.Bounds()
 	newBounds := image.Rect(0, 0, width, height)
-	newImage := image.NewNRGBA(newBounds)
+	var newImage draw.Image = image.NewNRGBA(newBounds)
+	if o.preserveDepth && is16Bit(img) {
+		newImage = image.NewNRGBA64(newBounds)
+	}
+
+	// an empty source has no pixels to sample, so nothing shows
+	if currentBounds.Empty() {
+		return newImage, nil
+	}
+
+	if o.resample == Lanczos {
+		resizeLanczos(newImage, img)
+		return newImage, nil
+	}
+
+	scaleX := float64(currentBounds.Dx()) / float64(newBounds.Dx())
+	scaleY := float64(currentBounds.Dy()) / float64(newBounds.Dy())
+
+	// nearest sampling of an NRGBA image is a plain copy of pixels
+	if src, ok := img.(*image.NRGBA); ok && o.resample == Nearest {
+		newImage := newImage.(*image.NRGBA)
+		for j := 0; j < newBounds.Dy(); j++ {
+			si := src.PixOffset(currentBounds.Min.X, currentBounds.Min.Y+int(float64(j)*scaleY))
+			di := newImage.PixOffset(0, j)
+			for i := 0; i < newBounds.Dx(); i, di = i+1, di+4 {
+				s := si + int(float64(i)*scaleX)*4
+				copy(newImage.Pix[di:di+4], src.Pix[s:s+4])
+			}
+		}
+		return newImage, nil
+	}
+
 	for i := 0; i < newBounds.Dx(); i++ {
 		for j := 0; j < newBounds.Dy(); j++ {
-			atX := int(float64(i) * float64(currentBounds.Dx()) / float64(newBounds.Dx()))
-			atY := int(float64(j) * float64(currentBounds.Dy()) / float64(newBounds.Dy()))
-			colorAt := img.At(atX, atY)
-			R, G, B, A := colorAt.RGBA()
-			colorAtRGBA := color.NRGBA{R: uint8(R), G: uint8(G), B: uint8(B), A: uint8(A)}
-			newImage.SetNRGBA(i, j, colorAtRGBA)
+			var colorAt color.Color
+			switch o.resample {
+			case Bilinear:
+				// sample at the center of the destination pixel
+				colorAt = sampleBilinear(img, (float64(i)+0.5)*scaleX-0.5, (float64(j)+0.5)*scaleY-0.5)
+			default:
+				atX := int(float64(i) * scaleX)
+				atY := int(float64(j) * scaleY)
+				colorAt = img.At(currentBounds.Min.X+atX, currentBounds.Min.Y+atY)
+			}
+			// RGBA() returns 16-bit premultiplied channels; the color
+			// model of newImage un-premultiplies them, down to 8 bits
+			// unless the depth is kept
+			newImage.Set(i, j, colorAt)
 		}
 	}
 
 	return newImage, nil
 }
 
+// fitDimensions returns the target size for an srcW x srcH image. When
+// only one of reqW and reqH is set the other is computed from the source
+// aspect ratio; when both are set they are returned unchanged and when
+// neither is set the source size is kept.
+func fitDimensions(srcW, srcH, reqW, reqH int) (int, int) {
+	switch {
+	case reqW > 0 && reqH > 0:
+		return reqW, reqH
+	case reqW > 0 && srcW > 0:
+		reqH = int(math.Round(float64(srcH) * float64(reqW) / float64(srcW)))
+	case reqH > 0 && srcH > 0:
+		reqW = int(math.Round(float64(srcW) * float64(reqH) / float64(srcH)))
+	default:
+		return srcW, srcH
+	}
+
+	// very wide or tall sources must not round down to nothing
+	if reqW < 1 {
+		reqW = 1
+	}
+	if reqH < 1 {
+		reqH = 1
+	}
+
+	return reqW, reqH
+}
+
+// fitWithin returns the largest size with the aspect ratio of srcW x srcH
+// that fits inside maxW x maxH, or the source size when it already fits.
+func fitWithin(srcW, srcH, maxW, maxH int) (int, int) {
+	if srcW <= maxW && srcH <= maxH {
+		return srcW, srcH
+	}
+
+	// scale by whichever side overflows the most
+	if srcW*maxH > srcH*maxW {
+		return fitDimensions(srcW, srcH, maxW, 0)
+	}
+
+	return fitDimensions(srcW, srcH, 0, maxH)
+}
+
+// Blend composites the watermark color over the main color using the
+// "source over" operator. All arithmetic happens in premultiplied alpha
+// space, which is what color.Color.RGBA() returns, so straight and
+// premultiplied colors of any depth can be mixed. The result is a
+// premultiplied color.RGBA64, except that a fully transparent watermark
+// gives main and a fully opaque one gives watermark, both unchanged.
+// Channels are rounded down to 16 bits; colors stored premultiplied in 8
+// bits, such as color.RGBA, lose precision at low alpha before Blend ever
+// sees them.
 func Blend(watermark color.Color, main color.Color) color.Color {
 	wr, wg, wb, wa := watermark.RGBA()
 	mr, mg, mb, ma := main.RGBA()
@@ -123,111 +565,1052 @@ func Blend(watermark color.Color, main color.Color) color.Color {
 		return watermark
 	}
 
-	// Calculate the blended color using the alpha values of the two pixels.
-	alpha := float64(wa) / float64(0xffff)
-	r := uint16(float64(wr)*alpha + float64(mr)*(1-alpha))
-	g := uint16(float64(wg)*alpha + float64(mg)*(1-alpha))
-	b := uint16(float64(wb)*alpha + float64(mb)*(1-alpha))
-	a := uint16(math.Max(float64(wa), float64(ma)))
+	// The main pixel shows through in proportion to the watermark's
+	// transparency. Since both colors are premultiplied the watermark
+	// channels are added as they are.
+	inv := 0xffff - wa
+	r := uint16(wr + mr*inv/0xffff)
+	g := uint16(wg + mg*inv/0xffff)
+	b := uint16(wb + mb*inv/0xffff)
+	a := uint16(wa + ma*inv/0xffff)
 
 	return color.RGBA64{R: r, G: g, B: b, A: a}
 }
 
-func AddWatermarkImage(mainImagePath, watermarkImagePath, outPath string, x, y, height, width int) error {
-	// get mainImg image from the disk
-	mainImg, err := ReadImage(mainImagePath)
+// ApplyOpacity returns a copy of img with every pixel's alpha scaled by
+// opacity.
+func ApplyOpacity(img image.Image, opacity float64) image.Image {
+	bounds := img.Bounds()
+	newImage := image.NewRGBA64(bounds)
+	for i := bounds.Min.X; i < bounds.Max.X; i++ {
+		for j := bounds.Min.Y; j < bounds.Max.Y; j++ {
+			// the channels are premultiplied so all four scale together
+			r, g, b, a := img.At(i, j).RGBA()
+			newImage.SetRGBA64(i, j, color.RGBA64{
+				R: uint16(float64(r) * opacity),
+				G: uint16(float64(g) * opacity),
+				B: uint16(float64(b) * opacity),
+				A: uint16(float64(a) * opacity),
+			})
+		}
+	}
+
+	return newImage
+}
+
+// CompositeImages returns a copy of base with overlay blended on top with
+// its top-left corner at (x, y), relative to the top-left corner of base.
+// It is the compositing step of AddWatermarkImage without the reading,
+// sizing, placement and saving, so in-memory images can be layered one
+// after the other. Parts of overlay past the edges of base, including at
+// negative offsets, are clipped. Of the options WithOpacity,
+// WithLinearBlend, WithBlendMode, WithOnlyBright, WithOnlyDark, WithMask
+// and WithContext apply. A nil base gives nil, as does a context that is
+// done before the blend is finished, and a nil overlay gives a plain copy
+// of base.
+func CompositeImages(base, overlay image.Image, x, y int, opts ...Option) *image.NRGBA {
+	if base == nil {
+		return nil
+	}
+
+	o := newOptions(opts)
+	if overlay != nil && o.opacity < 1 {
+		overlay = ApplyOpacity(overlay, o.opacity)
+	}
+
+	dst, err := compositeImages(base, overlay, x, y, o)
 	if err != nil {
-		return err
+		return nil
+	}
+	return dst
+}
+
+// compositeImages is CompositeImages for an overlay that already has its
+// opacity, returning the error of the context of o once it is done.
+func compositeImages(base, overlay image.Image, x, y int, o *options) (*image.NRGBA, error) {
+	dst := image.NewNRGBA(image.Rect(0, 0, base.Bounds().Dx(), base.Bounds().Dy()))
+	draw.Draw(dst, dst.Bounds(), base, base.Bounds().Min, draw.Src)
+	if overlay == nil {
+		return dst, nil
+	}
+
+	err := blendWatermark(dst, overlay, x, y, o)
+	if err != nil {
+		return nil, err
 	}
+	return dst, nil
+}
 
+// AddWatermarkImage blends the watermark image file onto the main image and
+// saves the result to outPath. See AddWatermark for the placement rules.
+// The watermark is sized and placed once and blended with CompositeImages;
+// animated GIFs and the options that repeat the watermark or change the
+// main image go through AddWatermark.
+func AddWatermarkImage(mainImagePath, watermarkImagePath, outPath, anchor string, x, y, height, width int, opts ...Option) error {
 	// get the waterMarkImg image from the disk
-	waterMarkImg, err := ReadImage(watermarkImagePath)
+	waterMarkImg, err := ReadImage(watermarkImagePath, opts...)
 	if err != nil {
 		return err
 	}
 
-	// resize image
-	if waterMarkImg.Bounds().Dx() > width || waterMarkImg.Bounds().Dy() > height {
-		waterMarkImg, err = ResizeImage(waterMarkImg, height, width)
-		if err != nil {
-			return err
-		}
+	o := newOptions(opts)
+	if isGIF(mainImagePath) || !o.singlePlacement() {
+		return AddWatermark(mainImagePath, waterMarkImg, outPath, anchor, x, y, height, width, opts...)
+	}
+
+	err = o.ctx.Err()
+	if err != nil {
+		return err
 	}
 
-	mainImageHeight := mainImg.Bounds().Dy()
-	mainImageWidth := mainImg.Bounds().Dx()
+	mainImg, meta, err := ReadImageWithMetadata(mainImagePath, opts...)
+	if err != nil {
+		return err
+	}
 
-	watermarkImageHeight := waterMarkImg.Bounds().Dy()
-	watermarkImageWidth := waterMarkImg.Bounds().Dx()
+	spec := WatermarkSpec{Image: waterMarkImg, Anchor: anchor, X: x, Y: y, Height: height, Width: width}
+	specs, err := prepareWatermarks([]WatermarkSpec{spec}, mainImg.Bounds(), o)
+	if err != nil {
+		return err
+	}
+	x, y, err = watermarkPosition(mainImg, specs[0], o)
+	if err != nil {
+		return err
+	}
+	o.logf("placing watermark at %v", image.Rect(x, y, x+specs[0].Image.Bounds().Dx(), y+specs[0].Image.Bounds().Dy()))
 
-	// Validate the dimensions
-	if x < 0 || y < 0 {
-		return errors.New("dimensions out of bounds")
+	newImg, err := compositeImages(mainImg, specs[0].Image, x, y, o)
+	if err != nil {
+		return err
 	}
 
-	if x > mainImageWidth || y > mainImageHeight {
-		return errors.New("dimensions out of bounds")
+	err = SaveImage(newImg, outPath, withMetadata(opts, meta)...)
+	if err != nil {
+		return err
 	}
 
-	var newImg *image.NRGBA
+	return preserveTime(mainImagePath, outPath, o)
+}
 
-	// convert main image into *image.NRGBA
-	if _, ok := mainImg.(*image.NRGBA); ok {
-		newImg = mainImg.(*image.NRGBA)
-	} else {
-		newImg = image.NewNRGBA(image.Rect(0, 0, mainImageWidth, mainImageHeight))
-		draw.Draw(newImg, newImg.Bounds(), mainImg, mainImg.Bounds().Min, draw.Src)
+// WatermarkSpec describes one watermark to place on the main image. When
+// Anchor is set it selects the position and X/Y are treated as an offset
+// from it, otherwise X/Y are absolute. The "quiet" anchor is the part of
+// the main image with the least detail the watermark fits in. XPercent and
+// YPercent, from 0 to 100, add that percentage of the main image width and
+// height to X and Y so the same spec lands in the same place on images of
+// any size. Height and Width bound the size of the watermark as described
+// for AddWatermark.
+type WatermarkSpec struct {
+	Image  image.Image
+	Anchor string
+	X, Y   int
+	Height int
+	Width  int
+
+	XPercent, YPercent float64
+
+	// shadow is set by prepareWatermarks when WithShadow is used
+	shadow image.Image
+	// quiet is the region found for the quiet anchor and tinted tells that
+	// Image already has the color of WithAutoColor, once settleSpecs has
+	// fixed them for the frames of an animation
+	quiet  *image.Point
+	tinted bool
+}
+
+// AddWatermark blends an in-memory watermark onto the main image and saves
+// the result to outPath. When anchor is set it selects the position and x/y
+// are treated as an offset from it, otherwise x/y are absolute. A negative
+// position hangs the watermark off the top or left edge and only its
+// visible part is blended. Animated GIFs keep all of their frames when
+// outPath is a GIF too.
+func AddWatermark(mainImagePath string, waterMarkImg image.Image, outPath, anchor string, x, y, height, width int, opts ...Option) error {
+	spec := WatermarkSpec{Image: waterMarkImg, Anchor: anchor, X: x, Y: y, Height: height, Width: width}
+	return AddWatermarks(mainImagePath, []WatermarkSpec{spec}, outPath, opts...)
+}
+
+// WatermarkImage blends each watermark in specs onto a copy of mainImg in
+// order and returns the result without reading or writing any file, for
+// callers that process the image further. It is what AddWatermarks does
+// between reading the main image and saving it; mainImg is not modified.
+func WatermarkImage(mainImg image.Image, specs []WatermarkSpec, opts ...Option) (image.Image, error) {
+	if mainImg == nil {
+		return nil, ErrNilImage
 	}
 
-	// Add waterMarkImg to the image
-	for i := x; i < watermarkImageWidth+x; i++ {
-		for j := y; j < watermarkImageHeight+y; j++ {
-			waterMarkPixelColor := waterMarkImg.At(i-x, j-y)
-			mainImagePixelColor := mainImg.At(i, j)
-			blendedColor := Blend(waterMarkPixelColor, mainImagePixelColor)
-			newImg.Set(i, j, blendedColor)
+	o := newOptions(opts)
+
+	// watermark draws straight onto an *image.NRGBA at the origin, which
+	// belongs to the caller here
+	if nrgba, ok := mainImg.(*image.NRGBA); ok && nrgba.Rect.Min == (image.Point{}) && !o.crop {
+		mainImg = copyNRGBA(nrgba)
+	}
+	if nrgba, ok := mainImg.(*image.NRGBA64); ok && nrgba.Rect.Min == (image.Point{}) && !o.crop {
+		mainImg = copyNRGBA64(nrgba, nrgba.Rect)
+	}
+
+	return watermark(mainImg, specs, o)
+}
+
+// AddWatermarks blends each watermark in specs onto the main image in
+// order, so later watermarks are drawn on top of earlier ones, and saves
+// the result to outPath once all of them have been applied.
+func AddWatermarks(mainImagePath string, specs []WatermarkSpec, outPath string, opts ...Option) error {
+	o := newOptions(opts)
+
+	if isGIF(mainImagePath) && strings.EqualFold(outputFormat(outPath, o), "gif") && !isURL(mainImagePath) && !o.compare &&
+		o.thumbWidth == 0 && o.thumbHeight == 0 {
+		err := addWatermarksGIF(mainImagePath, specs, outPath, o)
+		if err != nil {
+			return err
 		}
+		return preserveTime(mainImagePath, outPath, o)
 	}
 
-	err = SaveImage(newImg, outPath)
+	// get mainImg image from the disk
+	mainImg, meta, err := ReadImageWithMetadata(mainImagePath, opts...)
 	if err != nil {
 		return err
 	}
 
-	return nil
-}
+	newImg, err := watermark(mainImg, specs, o)
+	if err != nil {
+		return err
+	}
 
-func ValidatePaths(path ...string) {
-	for _, v := range path {
-		if v == "" {
-			fmt.Fprint(os.Stderr, "invalid usage\n")
-			flag.Usage()
-			os.Exit(1)
-		}
+	err = SaveImage(newImg, outPath, withMetadata(opts, meta)...)
+	if err != nil {
+		return err
 	}
+
+	return preserveTime(mainImagePath, outPath, o)
 }
-func main() {
-	var mainImage, watermarkImage, outPath string
-	var posX, posY, watermarkHeight, watermarkWidth int
 
-	flag.StringVar(&mainImage, "m", "", "main image")
-	flag.StringVar(&watermarkImage, "w", "", "watermark image")
-	flag.StringVar(&outPath, "o", "", "out path")
+// preserveTime gives outPath the modification time of mainImagePath with
+// WithPreserveTime. Images fetched from URLs have none to copy.
+func preserveTime(mainImagePath, outPath string, o *options) error {
+	if !o.preserveTime || isURL(mainImagePath) {
+		return nil
+	}
 
-	flag.IntVar(&posX, "x", 0, "x position on the main image")
-	flag.IntVar(&posY, "y", 0, "y position on the main image")
-	flag.IntVar(&watermarkHeight, "height", 0, "height of watermark")
-	flag.IntVar(&watermarkWidth, "width", 0, "width of watermark")
+	info, err := os.Stat(mainImagePath)
+	if err != nil {
+		return err
+	}
 
-	flag.Parse()
+	return os.Chtimes(outPath, info.ModTime(), info.ModTime())
+}
 
-	ValidatePaths(mainImage, watermarkImage, outPath)
+// validateWatermark checks the placement settings shared by every way of
+// adding a watermark.
+func validateWatermark(spec WatermarkSpec, o *options) error {
+	if _, ok := anchors[spec.Anchor]; spec.Anchor != "" && spec.Anchor != anchorQuiet && !ok {
+		return fmt.Errorf("unknown position %q", spec.Anchor)
+	}
 
-	// create watermark
-	err := AddWatermarkImage(mainImage, watermarkImage, outPath, posX, posY, watermarkHeight, watermarkWidth)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: %s", err)
-		os.Exit(1)
+	if spec.XPercent < 0 || spec.XPercent > 100 || spec.YPercent < 0 || spec.YPercent > 100 {
+		return fmt.Errorf("percentage position %v%%, %v%% must be between 0 and 100", spec.XPercent, spec.YPercent)
 	}
 
+	if o.opacity < 0 || o.opacity > 1 {
+		return fmt.Errorf("opacity %v must be between 0 and 1", o.opacity)
+	}
+
+	if o.threshold < 0 || o.threshold > 255 {
+		return fmt.Errorf("threshold %d must be between 0 and 255", o.threshold)
+	}
+
+	if o.stripRow || o.stripColumn {
+		if o.tile {
+			return errors.New("strips cannot be combined with tiling")
+		}
+		if o.gap < 0 {
+			return fmt.Errorf("gap %d must not be negative", o.gap)
+		}
+	}
+
+	// zero means the side is derived from the other one
+	if spec.Width < 0 || spec.Height < 0 {
+		return fmt.Errorf("%w %dx%d, width and height must not be negative", ErrInvalidSize, spec.Width, spec.Height)
+	}
+	if o.noResize && (spec.Width != 0 || spec.Height != 0 || o.scale != 0 || o.upscale) {
+		return errors.New("a watermark that is not resized cannot have a width, height or scale or be upscaled")
+	}
+	if o.thumbWidth < 0 || o.thumbHeight < 0 {
+		return fmt.Errorf("%w %dx%d, thumbnail width and height must not be negative", ErrInvalidSize, o.thumbWidth, o.thumbHeight)
+	}
+
+	if o.onlyBright && o.onlyDark {
+		return errors.New("a watermark cannot show only on bright and only on dark areas")
+	}
+
+	if o.blendMode != SourceOver && o.linearBlend {
+		return errors.New("blend modes other than source over cannot be combined with linear blending")
+	}
+
+	if o.feather < 0 {
+		return fmt.Errorf("feather %d must not be negative", o.feather)
+	}
+
+	if o.shadow && (o.shadowOpacity < 0 || o.shadowOpacity > 1) {
+		return fmt.Errorf("shadow opacity %v must be between 0 and 1", o.shadowOpacity)
+	}
+
+	if o.scale < 0 {
+		return fmt.Errorf("scale %v must not be negative", o.scale)
+	}
+
+	return nil
+}
+
+// watermark blends the watermarks onto a copy of mainImg in memory, after
+// cropping it when requested. The copy is an *image.NRGBA, or with
+// WithPreserveDepth an *image.NRGBA64 for 16-bit images. mainImg is reused
+// instead of copied when it already is of that type at the origin.
+func watermark(mainImg image.Image, specs []WatermarkSpec, o *options) (draw.Image, error) {
+	err := o.ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	deep := o.preserveDepth && is16Bit(mainImg)
+
+	if o.crop {
+		if deep {
+			err = checkCrop(o.cropRect, mainImg.Bounds())
+			if err != nil {
+				return nil, err
+			}
+			mainImg = copyNRGBA64(mainImg, o.cropRect)
+		} else {
+			mainImg, err = CropImage(mainImg, o.cropRect)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// copied since the watermarks may be drawn onto mainImg itself
+	var original image.Image
+	if o.compare {
+		if deep {
+			original = copyNRGBA64(mainImg, mainImg.Bounds())
+		} else {
+			original = copyNRGBA(toNRGBA(mainImg))
+		}
+	}
+
+	specs, err = prepareWatermarks(specs, mainImg.Bounds(), o)
+	if err != nil {
+		return nil, err
+	}
+
+	stamp, err := prepareStamp(o)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.grayscale {
+		mainImg = ToGrayscale(mainImg)
+	}
+	if o.brightness != 0 || o.contrast != 0 {
+		mainImg = AdjustBrightnessContrast(mainImg, o.brightness, o.contrast)
+	}
+
+	var newImg draw.Image
+	if deep {
+		newImg = toNRGBA64(mainImg)
+	} else {
+		newImg = toNRGBA(mainImg)
+	}
+
+	if stamp != nil {
+		err = tileWatermark(newImg, stamp, o.stampOptions.Gap, o.stampOptions.rowGap(), o)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, spec := range specs {
+		err = applyWatermark(newImg, spec, o)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if o.cornerRadius > 0 {
+		roundCorners(newImg, o.cornerRadius, nil)
+	}
+
+	if o.compare {
+		newImg = sideBySide(original, newImg)
+	}
+
+	if o.thumbWidth > 0 || o.thumbHeight > 0 {
+		b := newImg.Bounds()
+		w, h := fitDimensions(b.Dx(), b.Dy(), o.thumbWidth, o.thumbHeight)
+		o.logf("resizing output from %dx%d to %dx%d", b.Dx(), b.Dy(), w, h)
+		resizeOpts := []Option{WithResample(o.resample)}
+		if deep {
+			resizeOpts = append(resizeOpts, WithPreserveDepth())
+		}
+		thumb, err := ResizeImage(newImg, w, h, resizeOpts...)
+		if err != nil {
+			return nil, err
+		}
+		// ResizeImage only gives another type for vector images
+		newImg = thumb.(draw.Image)
+	}
+
+	return newImg, nil
+}
+
+// prepareWatermarks validates specs and returns a copy of them with each
+// image prepared by prepareWatermark for a main image of mainBounds. With
+// a cache in o, as for a batch, the specs prepared for a main image of the
+// same size are reused.
+func prepareWatermarks(specs []WatermarkSpec, mainBounds image.Rectangle, o *options) ([]WatermarkSpec, error) {
+	if len(specs) == 0 && !o.stamp {
+		return nil, errors.New("no watermark given")
+	}
+
+	if prepared, ok := o.prepared.get(mainBounds.Size()); ok {
+		o.logf("reusing watermarks prepared for %dx%d", mainBounds.Dx(), mainBounds.Dy())
+		return prepared, nil
+	}
+
+	prepared := make([]WatermarkSpec, len(specs))
+	for i, spec := range specs {
+		if spec.Image == nil {
+			return nil, ErrNilImage
+		}
+
+		err := validateWatermark(spec, o)
+		if err != nil {
+			return nil, err
+		}
+
+		spec.Image, err = prepareWatermark(spec.Image, spec.Height, spec.Width, mainBounds, o)
+		if err != nil {
+			return nil, err
+		}
+
+		if o.shadow {
+			spec.shadow = shadowLayer(spec.Image, o.shadowOpacity)
+		}
+
+		prepared[i] = spec
+	}
+
+	o.prepared.put(mainBounds.Size(), prepared)
+	return prepared, nil
+}
+
+// prepareWatermark keys out the color set with WithColorKey, resizes the
+// watermark to fit within height x width and applies the configured
+// mirroring, rotation, feathering, tint, opacity and alpha threshold. A
+// zero height or width is derived from the other one so the watermark
+// keeps its aspect ratio. When both are zero the watermark is sized by
+// WithScale when set, otherwise a watermark larger than mainBounds is
+// shrunk to fit inside it instead of being cut off at the edges.
+func prepareWatermark(waterMarkImg image.Image, height, width int, mainBounds image.Rectangle, o *options) (image.Image, error) {
+	var err error
+
+	// keyed before resizing so resampling does not blend the key color
+	// into the edges
+	if o.colorKey != nil {
+		waterMarkImg = ApplyColorKey(waterMarkImg, o.colorKey, o.colorKeyTolerance)
+	}
+
+	srcW, srcH := waterMarkImg.Bounds().Dx(), waterMarkImg.Bounds().Dy()
+	err = checkFit(srcW, srcH, mainBounds, o)
+	if err != nil {
+		return nil, err
+	}
+	width, height = resizeTarget(srcW, srcH, height, width, mainBounds, o)
+
+	// resize image
+	if width != srcW || height != srcH {
+		o.logf("resizing watermark from %dx%d to %dx%d", srcW, srcH, width, height)
+		waterMarkImg, err = ResizeImage(waterMarkImg, width, height, WithResample(o.resample))
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		o.logf("keeping watermark at %dx%d", srcW, srcH)
+	}
+
+	if o.flip {
+		waterMarkImg = FlipVertical(waterMarkImg)
+	}
+	if o.flop {
+		waterMarkImg = FlipHorizontal(waterMarkImg)
+	}
+
+	if o.rotate != 0 {
+		waterMarkImg, err = RotateImage(waterMarkImg, o.rotate)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if o.feather > 0 {
+		waterMarkImg = FeatherEdges(waterMarkImg, o.feather)
+	}
+
+	if o.tint != nil {
+		waterMarkImg = TintImage(waterMarkImg, o.tint)
+	}
+
+	if o.opacity < 1 {
+		waterMarkImg = ApplyOpacity(waterMarkImg, o.opacity)
+	}
+
+	if o.threshold > 0 {
+		waterMarkImg = thresholdAlpha(waterMarkImg, o.threshold)
+	}
+
+	return waterMarkImg, nil
+}
+
+// checkFit reports an error when a srcW x srcH watermark has to stay at its
+// own size with WithNoResize but is larger than the main image.
+func checkFit(srcW, srcH int, mainBounds image.Rectangle, o *options) error {
+	if o.noResize && (srcW > mainBounds.Dx() || srcH > mainBounds.Dy()) {
+		return fmt.Errorf("watermark %dx%d does not fit on the %dx%d main image without resizing", srcW, srcH, mainBounds.Dx(), mainBounds.Dy())
+	}
+	return nil
+}
+
+// resizeTarget returns the size prepareWatermark resizes a srcW x srcH
+// watermark to, which is the source size when it needs no resizing or
+// WithNoResize is set. Watermarks are only enlarged with WithUpscale, and
+// watermarks scaled relative to the main image are resized either way.
+func resizeTarget(srcW, srcH, height, width int, mainBounds image.Rectangle, o *options) (int, int) {
+	if o.noResize {
+		return srcW, srcH
+	}
+
+	if height == 0 && width == 0 && o.scale > 0 {
+		width = int(math.Round(float64(mainBounds.Dx()) * o.scale))
+		if width < 1 {
+			width = 1
+		}
+		return fitDimensions(srcW, srcH, width, 0)
+	}
+
+	if height == 0 && width == 0 {
+		width, height = fitWithin(srcW, srcH, mainBounds.Dx(), mainBounds.Dy())
+	} else {
+		width, height = fitDimensions(srcW, srcH, width, height)
+	}
+
+	if srcW > width || srcH > height || o.upscale {
+		return width, height
+	}
+
+	return srcW, srcH
+}
+
+// watermarkSize returns the size of a srcW x srcH watermark once
+// prepareWatermark has resized and rotated it.
+func watermarkSize(srcW, srcH, height, width int, mainBounds image.Rectangle, o *options) (int, int) {
+	width, height = resizeTarget(srcW, srcH, height, width, mainBounds, o)
+
+	if o.rotate != 0 {
+		width, height = rotatedSize(width, height, o.rotate)
+	}
+
+	return width, height
+}
+
+// toNRGBA returns img as an *image.NRGBA whose origin is (0, 0) so x and y
+// are relative to the top-left corner of what is visible. img itself is
+// returned when it already has that form.
+func toNRGBA(img image.Image) *image.NRGBA {
+	if nrgba, ok := img.(*image.NRGBA); ok && nrgba.Rect.Min == (image.Point{}) {
+		return nrgba
+	}
+
+	newImg := image.NewNRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(newImg, newImg.Bounds(), img, img.Bounds().Min, draw.Src)
+	return newImg
+}
+
+// copyNRGBA returns a copy of img.
+func copyNRGBA(img *image.NRGBA) *image.NRGBA {
+	newImg := image.NewNRGBA(img.Rect)
+	draw.Draw(newImg, newImg.Rect, img, img.Rect.Min, draw.Src)
+	return newImg
+}
+
+// toNRGBA64 is toNRGBA for *image.NRGBA64.
+func toNRGBA64(img image.Image) *image.NRGBA64 {
+	if nrgba, ok := img.(*image.NRGBA64); ok && nrgba.Rect.Min == (image.Point{}) {
+		return nrgba
+	}
+	return copyNRGBA64(img, img.Bounds())
+}
+
+// copyNRGBA64 copies the region r of img into an *image.NRGBA64 with its
+// origin at (0, 0).
+func copyNRGBA64(img image.Image, r image.Rectangle) *image.NRGBA64 {
+	newImg := image.NewNRGBA64(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(newImg, newImg.Bounds(), img, r.Min, draw.Src)
+	return newImg
+}
+
+// is16Bit reports whether img stores 16 bits per channel.
+func is16Bit(img image.Image) bool {
+	switch img.(type) {
+	case *image.NRGBA64, *image.RGBA64, *image.Gray16:
+		return true
+	}
+	return false
+}
+
+// quietRegion returns the top-left position of the region of dst where the
+// watermark of spec covers the least detail, or the one settleSpecs found.
+func quietRegion(dst image.Image, spec WatermarkSpec) image.Point {
+	if spec.quiet != nil {
+		return *spec.quiet
+	}
+	return findQuietRegion(dst, spec.Image.Bounds().Dx(), spec.Image.Bounds().Dy())
+}
+
+// settleSpecs returns a copy of specs with the choices applyWatermark makes
+// from the pixels of dst made once, on the first frame of an animation, so
+// the watermarks neither move nor change color on the frames after it.
+// That is the region of the quiet anchor and the color of WithAutoColor.
+func settleSpecs(dst image.Image, specs []WatermarkSpec, o *options) ([]WatermarkSpec, error) {
+	settled := make([]WatermarkSpec, len(specs))
+	for i, spec := range specs {
+		if spec.Anchor == anchorQuiet {
+			quiet := quietRegion(dst, spec)
+			spec.quiet = &quiet
+		}
+		if o.autoColor && !spec.tinted {
+			x, y, err := watermarkPosition(dst, spec, o)
+			if err != nil {
+				return nil, err
+			}
+			spec.Image = autoColor(dst, spec.Image, x, y, o)
+			spec.tinted = true
+		}
+		settled[i] = spec
+	}
+	return settled, nil
+}
+
+// watermarkPosition returns the top-left position of the watermark of spec
+// on dst, as applyWatermark places it.
+func watermarkPosition(dst image.Image, spec WatermarkSpec, o *options) (int, int, error) {
+	waterMarkImg := spec.Image
+	x, y := spec.offset(dst.Bounds().Dx(), dst.Bounds().Dy())
+	if spec.Anchor == anchorQuiet {
+		quiet := quietRegion(dst, spec)
+		if o.center {
+			quiet = quiet.Add(image.Pt(waterMarkImg.Bounds().Dx()/2, waterMarkImg.Bounds().Dy()/2))
+		}
+		x, y = x+quiet.X, y+quiet.Y
+	}
+	return placeWatermark(dst.Bounds().Dx(), dst.Bounds().Dy(), waterMarkImg.Bounds().Dx(), waterMarkImg.Bounds().Dy(), spec.Anchor, x, y, o)
+}
+
+// autoColor tints waterMarkImg black or white, whichever contrasts with the
+// part of dst it covers at (x, y), or all of dst when tiled.
+func autoColor(dst image.Image, waterMarkImg image.Image, x, y int, o *options) image.Image {
+	region := dst.Bounds()
+	if !o.tile {
+		region = image.Rect(x, y, x+waterMarkImg.Bounds().Dx(), y+waterMarkImg.Bounds().Dy())
+	}
+	return TintImage(waterMarkImg, contrastColor(averageLuminance(dst, region)))
+}
+
+// applyWatermark resolves the position of the prepared watermark on dst,
+// searching dst for the quiet anchor, validates it and blends the
+// watermark, or tiles it or repeats it in a strip through that position
+// when requested, and dithers the region for WithDither. With WithAutoColor
+// the watermark is first tinted to contrast with dst. The shadow, if any,
+// is blended first so the watermark lies on top of it.
+func applyWatermark(dst draw.Image, spec WatermarkSpec, o *options) error {
+	waterMarkImg := spec.Image
+	x, y, err := watermarkPosition(dst, spec, o)
+	if err != nil {
+		return err
+	}
+	if o.tile {
+		o.logf("tiling watermark of %dx%d over %v", waterMarkImg.Bounds().Dx(), waterMarkImg.Bounds().Dy(), dst.Bounds())
+	} else {
+		o.logf("placing watermark at %v", image.Rect(x, y, x+waterMarkImg.Bounds().Dx(), y+waterMarkImg.Bounds().Dy()))
+	}
+
+	if o.autoColor && !spec.tinted {
+		waterMarkImg = autoColor(dst, waterMarkImg, x, y, o)
+	}
+
+	if o.dither {
+		defer ditherWatermark(dst, image.Rect(x, y, x+waterMarkImg.Bounds().Dx(), y+waterMarkImg.Bounds().Dy()), o)
+	}
+
+	var shadowAt image.Point
+	if spec.shadow != nil {
+		shadowAt = image.Pt(o.shadowOffset, o.shadowOffset).Add(spec.shadow.Bounds().Min)
+	}
+
+	// Add waterMarkImg to the image
+	if o.tile {
+		if spec.shadow != nil && o.gap >= 0 {
+			err = tileImage(dst, spec.shadow, waterMarkImg.Bounds().Dx()+o.gap, waterMarkImg.Bounds().Dy()+o.gap, shadowAt, o)
+			if err != nil {
+				return err
+			}
+		}
+		return tileWatermark(dst, waterMarkImg, o.gap, o.gap, o)
+	}
+
+	if spec.shadow != nil {
+		err = blendWatermark(dst, spec.shadow, x+shadowAt.X, y+shadowAt.Y, o)
+		if err != nil {
+			return err
+		}
+	}
+	if o.stripRow || o.stripColumn {
+		// a zero step leaves that axis without repetitions
+		var stepX, stepY int
+		if o.stripRow {
+			stepX = waterMarkImg.Bounds().Dx() + o.gap
+		}
+		if o.stripColumn {
+			stepY = waterMarkImg.Bounds().Dy() + o.gap
+		}
+
+		if spec.shadow != nil {
+			err = stripImage(dst, spec.shadow, x+shadowAt.X, y+shadowAt.Y, stepX, stepY, o)
+			if err != nil {
+				return err
+			}
+		}
+		return stripImage(dst, waterMarkImg, x, y, stepX, stepY, o)
+	}
+
+	return blendWatermark(dst, waterMarkImg, x, y, o)
+}
+
+// ditherWatermark dithers the part of dst covered by a watermark placed at
+// r, which is all of dst when tiled and the whole row or column of a strip.
+func ditherWatermark(dst draw.Image, r image.Rectangle, o *options) {
+	bounds := dst.Bounds()
+	switch {
+	case o.tile:
+		ditherRegion(dst, bounds, o.ditherSeed)
+	case o.stripRow && o.stripColumn:
+		// the column without the row, so the crossing is dithered once
+		row := image.Rect(bounds.Min.X, r.Min.Y, bounds.Max.X, r.Max.Y)
+		ditherRegion(dst, row, o.ditherSeed)
+		ditherRegion(dst, image.Rect(r.Min.X, bounds.Min.Y, r.Max.X, r.Min.Y), o.ditherSeed)
+		ditherRegion(dst, image.Rect(r.Min.X, r.Max.Y, r.Max.X, bounds.Max.Y), o.ditherSeed)
+	case o.stripRow:
+		ditherRegion(dst, image.Rect(bounds.Min.X, r.Min.Y, bounds.Max.X, r.Max.Y), o.ditherSeed)
+	case o.stripColumn:
+		ditherRegion(dst, image.Rect(r.Min.X, bounds.Min.Y, r.Max.X, bounds.Max.Y), o.ditherSeed)
+	default:
+		ditherRegion(dst, r, o.ditherSeed)
+	}
+}
+
+// offset returns X and Y with the percentage position added for a main
+// image of mainW x mainH.
+func (s WatermarkSpec) offset(mainW, mainH int) (int, int) {
+	x := s.X + int(math.Round(float64(mainW)*s.XPercent/100))
+	y := s.Y + int(math.Round(float64(mainH)*s.YPercent/100))
+	return x, y
+}
+
+// placeWatermark resolves the top-left position of a wmW x wmH watermark on
+// a mainW x mainH image and checks that it lies at least partly on the
+// image. A negative position hangs the watermark off the top or left edge,
+// where it is clipped.
+func placeWatermark(mainW, mainH, wmW, wmH int, anchor string, x, y int, o *options) (int, int, error) {
+	if o.center {
+		x, y = resolveCenter(mainW, mainH, wmW, wmH, anchor, x, y)
+	} else {
+		x, y = resolveAnchor(mainW, mainH, wmW, wmH, anchor, x, y)
+	}
+	x, y = insetAnchor(anchor, x, y, o.marginX, o.marginY)
+
+	// Validate the dimensions, a watermark touching an edge from outside
+	// has no pixel on the image
+	if x <= -wmW || y <= -wmH {
+		return 0, 0, fmt.Errorf("dimensions %w", ErrOutOfBounds)
+	}
+
+	if x >= mainW || y >= mainH {
+		return 0, 0, fmt.Errorf("dimensions %w", ErrOutOfBounds)
+	}
+
+	return x, y, nil
+}
+
+// blendWatermark blends waterMarkImg onto dst with its top-left corner at
+// (x, y). The underlying pixels are read back from dst so that repeated or
+// overlapping blends stack on top of each other. With WithLinearBlend the
+// colors are mixed with BlendLinear instead of Blend, and with
+// WithBlendMode with BlendWithMode. WithOnlyBright and WithOnlyDark fade
+// the watermark by the luminance of dst first, and WithMask by the mask
+// stretched over dst. The context of o is checked every blendRows rows,
+// returning its error once it is done.
+func blendWatermark(dst draw.Image, waterMarkImg image.Image, x, y int, o *options) error {
+	if o.onlyBright || o.onlyDark {
+		// masked at the position now so stacked watermarks see each other
+		waterMarkImg = luminanceMask(dst, waterMarkImg, x, y, o.onlyDark)
+	}
+	if o.mask != nil {
+		// the mask covers all of dst, however large the watermark is
+		waterMarkImg = maskAlpha(waterMarkImg, image.Pt(x, y), dst.Bounds(), o.mask)
+	}
+
+	watermarkBounds := waterMarkImg.Bounds()
+	watermarkImageHeight := watermarkBounds.Dy()
+	watermarkImageWidth := watermarkBounds.Dx()
+
+	// stop at the edges of dst when the watermark hangs over them
+	minX, minY := x, y
+	if minX < dst.Bounds().Min.X {
+		minX = dst.Bounds().Min.X
+	}
+	if minY < dst.Bounds().Min.Y {
+		minY = dst.Bounds().Min.Y
+	}
+	maxX := watermarkImageWidth + x
+	if maxX > dst.Bounds().Max.X {
+		maxX = dst.Bounds().Max.X
+	}
+	maxY := watermarkImageHeight + y
+	if maxY > dst.Bounds().Max.Y {
+		maxY = dst.Bounds().Max.Y
+	}
+	if minX >= maxX || minY >= maxY {
+		// entirely off dst
+		return nil
+	}
+
+	src, srcOK := waterMarkImg.(*image.NRGBA)
+	if dst, ok := dst.(*image.NRGBA); ok && srcOK {
+		blendPixel := blendNRGBAPixel
+		if o.linearBlend {
+			blendPixel = blendNRGBAPixelLinear
+		}
+		if o.blendMode != SourceOver {
+			blendPixel = blendNRGBAPixelMode(o.blendMode)
+		}
+
+		for j := minY; j < maxY; j += blendRows {
+			if err := o.ctx.Err(); err != nil {
+				return err
+			}
+
+			bandMaxY := j + blendRows
+			if bandMaxY > maxY {
+				bandMaxY = maxY
+			}
+			blendNRGBA(dst, src, image.Rect(minX, j, maxX, bandMaxY), image.Pt(x, y), blendPixel)
+		}
+		return nil
+	}
+
+	blend := Blend
+	if o.linearBlend {
+		blend = BlendLinear
+	}
+	if o.blendMode != SourceOver {
+		blend = func(watermark, main color.Color) color.Color {
+			return BlendWithMode(watermark, main, o.blendMode)
+		}
+	}
+
+	for j := minY; j < maxY; j++ {
+		if (j-minY)%blendRows == 0 {
+			if err := o.ctx.Err(); err != nil {
+				return err
+			}
+		}
+
+		for i := minX; i < maxX; i++ {
+			waterMarkPixelColor := waterMarkImg.At(watermarkBounds.Min.X+i-x, watermarkBounds.Min.Y+j-y)
+			mainImagePixelColor := dst.At(i, j)
+			blendedColor := blend(waterMarkPixelColor, mainImagePixelColor)
+			dst.Set(i, j, blendedColor)
+		}
+	}
+
+	return nil
+}
+
+// blendRows is how many rows blendWatermark blends between checks of its
+// context.
+const blendRows = 64
+
+// blendNRGBA is blendWatermark for an *image.NRGBA watermark with its
+// top-left corner at at, limited to the region r of dst. It works on the
+// Pix slices directly instead of going through At and Set for every pixel,
+// and blends each pixel of dst with the watermark pixel over it by
+// blendPixel, skipping the fully transparent ones.
+func blendNRGBA(dst, src *image.NRGBA, r image.Rectangle, at image.Point, blendPixel func(d, s []uint8)) {
+	for j := r.Min.Y; j < r.Max.Y; j++ {
+		di := dst.PixOffset(r.Min.X, j)
+		si := src.PixOffset(src.Rect.Min.X+r.Min.X-at.X, src.Rect.Min.Y+j-at.Y)
+		for i := r.Min.X; i < r.Max.X; i, di, si = i+1, di+4, si+4 {
+			s := src.Pix[si : si+4 : si+4]
+			if s[3] == 0 {
+				continue
+			}
+			blendPixel(dst.Pix[di:di+4:di+4], s)
+		}
+	}
+}
+
+// blendNRGBAPixel blends the NRGBA pixel s over d with the same arithmetic
+// as Blend, so the results are identical.
+func blendNRGBAPixel(d, s []uint8) {
+	wa := uint32(s[3]) * 0x101
+	if wa == 0xffff {
+		copy(d, s)
+		return
+	}
+
+	// premultiply both pixels to 16 bits as color.NRGBA.RGBA does
+	ma := uint32(d[3]) * 0x101
+	inv := 0xffff - wa
+	var out [3]uint32
+	for c := 0; c < 3; c++ {
+		wc := uint32(s[c]) * 0x101 * wa / 0xffff
+		mc := uint32(d[c]) * 0x101 * ma / 0xffff
+		out[c] = (wc + mc*inv/0xffff) & 0xffff
+	}
+	a := (wa + ma*inv/0xffff) & 0xffff
+
+	// and convert back the way color.NRGBAModel does
+	if a == 0 {
+		d[0], d[1], d[2], d[3] = 0, 0, 0, 0
+		return
+	}
+	if a != 0xffff {
+		for c := range out {
+			out[c] = out[c] * 0xffff / a
+		}
+	}
+	d[0], d[1], d[2], d[3] = uint8(out[0]>>8), uint8(out[1]>>8), uint8(out[2]>>8), uint8(a>>8)
+}
+
+// watermarkStream is AddWatermarks where the main image may be read from
+// stdin and the result written to stdout by passing "-" as the path, with
+// Run passing os.Stdin and os.Stdout. Streams have no file extension, so
+// their formats are given explicitly; outFormat defaults to the format of
+// the main image.
+func watermarkStream(stdin io.Reader, stdout io.Writer, mainImagePath, inFormat string, specs []WatermarkSpec, outPath, outFormat string, opts ...Option) error {
+	var mainImg image.Image
+	var meta *Metadata
+	var err error
+
+	if mainImagePath == "-" {
+		if inFormat == "" {
+			return errors.New("-informat is required when reading from stdin")
+		}
+		mainImg, meta, err = readImageFrom(stdin, inFormat, newOptions(opts))
+	} else {
+		inFormat = strings.TrimPrefix(filepath.Ext(mainImagePath), ".")
+		mainImg, meta, err = ReadImageWithMetadata(mainImagePath, opts...)
+	}
+	if err != nil {
+		return err
+	}
+
+	newImg, err := watermark(mainImg, specs, newOptions(opts))
+	if err != nil {
+		return err
+	}
+	opts = withMetadata(opts, meta)
+
+	if outPath != "-" {
+		return SaveImage(newImg, outPath, opts...)
+	}
+
+	if outFormat == "" {
+		outFormat = inFormat
+	}
+
+	return WriteImageTo(stdout, newImg, outFormat, opts...)
+}
+
+func ValidatePaths(path ...string) {
+	for _, v := range path {
+		if v == "" {
+			fmt.Fprint(os.Stderr, "invalid usage\n")
+			flag.Usage()
+			os.Exit(1)
+		}
+	}
+}
+
+// exitOnError reports err on stderr and exits when it is not nil.
+func exitOnError(err error) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func main() {
+	name := filepath.Base(os.Args[0])
+	command, args, err := splitCommand(os.Args[1:])
+	exitOnError(err)
+
+	c := newCLI(name, command)
+	flag.Usage = c.fs.Usage
+	cfg, err := c.parse(args)
+	exitOnError(err)
+
+	if c.version {
+		fmt.Println(versionInfo())
+		return
+	}
+
+	if c.listFormats {
+		for _, format := range SupportedFormats() {
+			if !isWritableFormat(format) {
+				format += " (read only)"
+			}
+			fmt.Println(format)
+		}
+		return
+	}
+
+	if command == "" && len(args) > 0 && !cfg.Quiet {
+		fmt.Fprintf(os.Stderr, "warning: flags without a command are deprecated, use %s image, text or batch\n", name)
+	}
+
+	ValidatePaths(cfg.Main, cfg.Output)
+	if len(cfg.Watermarks) == 0 && cfg.Stamp == nil {
+		fmt.Fprintf(os.Stderr, "invalid usage: %s is required\n", c.required())
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if c.check {
+		errs := cfg.Check()
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		}
+		if len(errs) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	err = cfg.Run()
+	if errors.Is(err, fs.ErrExist) {
+		err = fmt.Errorf("%w, use -force to overwrite it", err)
+	}
+	exitOnError(err)
 }
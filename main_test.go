@@ -0,0 +1,1722 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestBlend(t *testing.T) {
+	blue := color.NRGBA{0, 0, 255, 255}
+	tests := []struct {
+		name  string
+		alpha uint8
+		want  color.NRGBA
+	}{
+		{"transparent", 0, blue},
+		{"quarter", 64, color.NRGBA{64, 0, 191, 255}},
+		{"half", 128, color.NRGBA{128, 0, 127, 255}},
+		{"three quarters", 191, color.NRGBA{191, 0, 64, 255}},
+		{"opaque", 255, color.NRGBA{255, 0, 0, 255}},
+	}
+
+	for _, tt := range tests {
+		red := color.NRGBA{255, 0, 0, tt.alpha}
+		// the same colors stored straight and premultiplied
+		inputs := []struct {
+			name            string
+			watermark, main color.Color
+		}{
+			{"NRGBA", red, blue},
+			{"RGBA", color.RGBAModel.Convert(red), color.RGBAModel.Convert(blue)},
+		}
+
+		for _, in := range inputs {
+			t.Run(tt.name+"/"+in.name, func(t *testing.T) {
+				got := color.NRGBAModel.Convert(Blend(in.watermark, in.main))
+				if got != tt.want {
+					t.Errorf("Blend(%v, %v) = %v, want %v", in.watermark, in.main, got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestResizeImageGradient(t *testing.T) {
+	// a horizontal gray ramp through every 8-bit level, stored in 16 bits
+	// so the generic path converts every channel
+	ramp := image.NewGray16(image.Rect(0, 0, 256, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 256; x++ {
+			ramp.SetGray16(x, y, color.Gray16{Y: uint16(x * 0x101)})
+		}
+	}
+	rampNRGBA := image.NewNRGBA(ramp.Rect)
+	draw.Draw(rampNRGBA, ramp.Rect, ramp, image.Point{}, draw.Src)
+
+	tests := []struct {
+		name      string
+		img       image.Image
+		resample  Resample
+		tolerance int
+	}{
+		{"nearest", ramp, Nearest, 0},
+		{"nearest NRGBA", rampNRGBA, Nearest, 0},
+		{"bilinear", ramp, Bilinear, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resized, err := ResizeImage(tt.img, 128, 1, WithResample(tt.resample))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for x := 0; x < 128; x++ {
+				got := resized.(*image.NRGBA).NRGBAAt(x, 0)
+				want := 2 * x
+				if d := int(got.R) - want; d > tt.tolerance || d < -tt.tolerance || got.R != got.G || got.G != got.B || got.A != 255 {
+					t.Fatalf("pixel %d = %v, want gray %d within %d", x, got, want, tt.tolerance)
+				}
+			}
+		})
+	}
+}
+
+func TestResizeImageLanczosAliasing(t *testing.T) {
+	// stripes with a period of 3 pixels, too fine to survive a downscale
+	// by 4, so all that should be left of them is their mean of 85
+	stripes := image.NewGray(image.Rect(0, 0, 240, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 240; x += 3 {
+			stripes.SetGray(x, y, color.Gray{255})
+		}
+	}
+
+	// energy returns the mean squared distance of the inner pixels of the
+	// middle row from 85, the moiré left by the stripes, away from the
+	// edges where the kernels run off the image
+	energy := func(img image.Image) float64 {
+		var sum float64
+		b := img.Bounds()
+		for x := b.Min.X + 3; x < b.Max.X-3; x++ {
+			d := float64(color.GrayModel.Convert(img.At(x, b.Dy()/2)).(color.Gray).Y) - 85
+			sum += d * d
+		}
+		return sum / float64(b.Dx()-6)
+	}
+
+	energies := map[Resample]float64{}
+	for _, resample := range []Resample{Nearest, Bilinear, Lanczos} {
+		resized, err := ResizeImage(stripes, 60, 2, WithResample(resample))
+		if err != nil {
+			t.Fatal(err)
+		}
+		energies[resample] = energy(resized)
+	}
+
+	if energies[Lanczos] > 4 {
+		t.Errorf("lanczos left a moiré of energy %.1f, want at most 4", energies[Lanczos])
+	}
+	if energies[Lanczos]*100 > energies[Nearest] {
+		t.Errorf("lanczos left a moiré of energy %.1f, want under a hundredth of the %.1f of nearest", energies[Lanczos], energies[Nearest])
+	}
+	if energies[Lanczos] > energies[Bilinear] {
+		t.Errorf("lanczos left a moiré of energy %.1f, want no more than the %.1f of bilinear", energies[Lanczos], energies[Bilinear])
+	}
+}
+
+func TestResizeImageMidTone(t *testing.T) {
+	// 0x8000 used to be truncated to 0 instead of scaled to 128
+	img := image.NewNRGBA64(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			img.SetNRGBA64(x, y, color.NRGBA64{0x8000, 0x8000, 0x8000, 0xffff})
+		}
+	}
+
+	resized, err := ResizeImage(img, 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := color.NRGBA{128, 128, 128, 255}
+	if got := resized.(*image.NRGBA).NRGBAAt(1, 1); got != want {
+		t.Errorf("pixel = %v, want %v", got, want)
+	}
+}
+
+func TestResizeImageDimensions(t *testing.T) {
+	// a 4x2 image with its left half red and its right half blue
+	src := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	draw.Draw(src, src.Rect, image.NewUniform(color.NRGBA{0, 0, 255, 255}), image.Point{}, draw.Src)
+	draw.Draw(src, image.Rect(0, 0, 2, 2), image.NewUniform(color.NRGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+
+	resized, err := ResizeImage(src, 30, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := resized.Bounds(), image.Rect(0, 0, 30, 10); got != want {
+		t.Fatalf("ResizeImage(30, 10) bounds = %v, want %v", got, want)
+	}
+	// the width stretches the halves, which stay left and right
+	if got := redBounds(resized); got != image.Rect(0, 0, 15, 10) {
+		t.Errorf("red half at %v, want %v", got, image.Rect(0, 0, 15, 10))
+	}
+
+	// a spec gives the width and height by name
+	out, err := WatermarkImage(image.NewGray(image.Rect(0, 0, 40, 40)), []WatermarkSpec{{Image: resized, Width: 6, Height: 8}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := redBounds(out); got != image.Rect(0, 0, 3, 8) {
+		t.Errorf("red half of the watermark at %v, want %v", got, image.Rect(0, 0, 3, 8))
+	}
+}
+
+func TestResizeImageBilinear(t *testing.T) {
+	// the destination pixels sample the 2x2 source at -0.25, 0.25, 0.75
+	// and 1.25 along each side, clamped to the edge pixels
+	src := image.NewGray(image.Rect(0, 0, 2, 2))
+	src.Pix = []uint8{0, 160, 80, 240}
+	want := [4][4]uint8{
+		{0, 40, 120, 160},
+		{20, 60, 140, 180},
+		{60, 100, 180, 220},
+		{80, 120, 200, 240},
+	}
+
+	resized, err := ResizeImage(src, 4, 4, WithResample(Bilinear))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			got := resized.(*image.NRGBA).NRGBAAt(x, y)
+			w := want[y][x]
+			if got != (color.NRGBA{w, w, w, 255}) {
+				t.Errorf("pixel (%d, %d) = %v, want gray %d", x, y, got, w)
+			}
+		}
+	}
+}
+
+func TestFitDimensions(t *testing.T) {
+	// a 200x100 source
+	tests := []struct {
+		name         string
+		reqW, reqH   int
+		wantW, wantH int
+	}{
+		{"width only", 50, 0, 50, 25},
+		{"height only", 0, 40, 80, 40},
+		{"both", 30, 90, 30, 90},
+		{"neither", 0, 0, 200, 100},
+		{"rounded", 5, 0, 5, 3},
+		{"too thin to round down to nothing", 1, 0, 1, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, h := fitDimensions(200, 100, tt.reqW, tt.reqH)
+			if w != tt.wantW || h != tt.wantH {
+				t.Errorf("fitDimensions(200, 100, %d, %d) = %dx%d, want %dx%d", tt.reqW, tt.reqH, w, h, tt.wantW, tt.wantH)
+			}
+		})
+	}
+}
+
+func TestCompositeHalfRedOverBlue(t *testing.T) {
+	red := color.NRGBA{255, 0, 0, 128}
+	tests := []struct {
+		name string
+		main color.NRGBA
+		want color.NRGBA
+	}{
+		{"opaque blue", color.NRGBA{0, 0, 255, 255}, color.NRGBA{128, 0, 127, 255}},
+		{"half blue", color.NRGBA{0, 0, 255, 128}, color.NRGBA{170, 0, 85, 192}},
+	}
+
+	for _, tt := range tests {
+		base := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+		base.SetNRGBA(0, 0, tt.main)
+
+		// stored straight and premultiplied, through both blending paths
+		nrgba := image.NewNRGBA(base.Rect)
+		nrgba.SetNRGBA(0, 0, red)
+		rgba := image.NewRGBA(base.Rect)
+		rgba.Set(0, 0, red)
+
+		for _, overlay := range []image.Image{nrgba, rgba} {
+			got := CompositeImages(base, overlay, 0, 0).NRGBAAt(0, 0)
+			if got != tt.want {
+				t.Errorf("%s with a %T overlay = %v, want %v", tt.name, overlay, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestCompositeImagesOffsets(t *testing.T) {
+	red := color.NRGBA{255, 0, 0, 255}
+	blue := color.NRGBA{0, 0, 255, 255}
+	base := image.NewNRGBA(image.Rect(0, 0, 8, 6))
+	draw.Draw(base, base.Rect, image.NewUniform(blue), image.Point{}, draw.Src)
+	overlay := image.NewNRGBA(image.Rect(0, 0, 4, 3))
+	draw.Draw(overlay, overlay.Rect, image.NewUniform(red), image.Point{}, draw.Src)
+
+	tests := []struct {
+		name string
+		x, y int
+		want image.Rectangle
+	}{
+		{"origin", 0, 0, image.Rect(0, 0, 4, 3)},
+		{"inside", 2, 1, image.Rect(2, 1, 6, 4)},
+		{"clipped on the left", -2, 1, image.Rect(0, 1, 2, 4)},
+		{"clipped on the top", 3, -2, image.Rect(3, 0, 7, 1)},
+		{"clipped on the top-left", -3, -1, image.Rect(0, 0, 1, 2)},
+		{"clipped on the bottom-right", 6, 5, image.Rect(6, 5, 8, 6)},
+		{"off the top-left", -4, -3, image.Rectangle{}},
+		{"off the bottom-right", 8, 6, image.Rectangle{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := CompositeImages(base, overlay, tt.x, tt.y)
+			if out.Rect != base.Rect {
+				t.Fatalf("bounds = %v, want %v", out.Rect, base.Rect)
+			}
+			for y := 0; y < 6; y++ {
+				for x := 0; x < 8; x++ {
+					want := blue
+					if image.Pt(x, y).In(tt.want) {
+						want = red
+					}
+					if got := out.NRGBAAt(x, y); got != want {
+						t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+					}
+				}
+			}
+		})
+	}
+
+	if base.NRGBAAt(0, 0) != blue {
+		t.Error("CompositeImages changed base")
+	}
+}
+
+func TestCompositeImagesContext(t *testing.T) {
+	base := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	overlay := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if out := CompositeImages(base, overlay, 0, 0, WithContext(ctx)); out != nil {
+		t.Error("CompositeImages with a cancelled context returned an image")
+	}
+	if out := CompositeImages(base, nil, 0, 0, WithContext(ctx)); out == nil {
+		t.Error("CompositeImages without an overlay returned nil")
+	}
+}
+
+// cancelingImage is an image that calls cancel when a pixel is read for the
+// after-th time.
+type cancelingImage struct {
+	image.Image
+	after  int
+	cancel context.CancelFunc
+	reads  int
+}
+
+func (c *cancelingImage) At(x, y int) color.Color {
+	c.reads++
+	if c.reads == c.after {
+		c.cancel()
+	}
+	return c.Image.At(x, y)
+}
+
+func TestWatermarkImageCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// cancelled a few rows into blending a 1000x1000 watermark
+	wm := &cancelingImage{Image: image.NewNRGBA(image.Rect(0, 0, 1000, 1000)), after: 5000, cancel: cancel}
+	_, err := WatermarkImage(image.NewNRGBA(image.Rect(0, 0, 1000, 1000)), []WatermarkSpec{{Image: wm}}, WithContext(ctx))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("WatermarkImage error = %v, want %v", err, context.Canceled)
+	}
+	if wm.reads > 5000+blendRows*1000 {
+		t.Errorf("%d pixels read after cancelling at 5000, want the blend to stop within %d rows", wm.reads, blendRows)
+	}
+}
+
+func TestAddWatermarkImageMatchesWatermarkImage(t *testing.T) {
+	dir := t.TempDir()
+	main := image.NewNRGBA(image.Rect(0, 0, 40, 30))
+	for i := range main.Pix {
+		main.Pix[i] = uint8(i * 7)
+	}
+	for i := 3; i < len(main.Pix); i += 4 {
+		main.Pix[i] = 255
+	}
+	wm := image.NewNRGBA(image.Rect(0, 0, 10, 8))
+	for i := range wm.Pix {
+		wm.Pix[i] = uint8(i * 13)
+	}
+	mainPath, wmPath := filepath.Join(dir, "main.png"), filepath.Join(dir, "wm.png")
+	for path, img := range map[string]image.Image{mainPath: main, wmPath: wm} {
+		err := SaveImage(img, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		anchor string
+		x, y   int
+		opts   []Option
+	}{
+		{"absolute", "", 5, 4, nil},
+		{"negative offset", "", -3, -2, nil},
+		{"anchored with opacity", "bottom-right", -2, -1, []Option{WithOpacity(0.5)}},
+		{"blend mode", "center", 0, 0, []Option{WithBlendMode(Multiply)}},
+		{"tiled", "", 0, 0, []Option{WithTile(2)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outPath := filepath.Join(t.TempDir(), "out.png")
+			err := AddWatermarkImage(mainPath, wmPath, outPath, tt.anchor, tt.x, tt.y, 0, 0, tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ReadImage(outPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want, err := WatermarkImage(main, []WatermarkSpec{{Image: wm, Anchor: tt.anchor, X: tt.x, Y: tt.y}}, tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for y := 0; y < 30; y++ {
+				for x := 0; x < 40; x++ {
+					g, w := color.NRGBAModel.Convert(got.At(x, y)), color.NRGBAModel.Convert(want.At(x, y))
+					if g != w {
+						t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, g, w)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestThreshold(t *testing.T) {
+	blue := color.NRGBA{0, 0, 255, 255}
+	main := image.NewNRGBA(image.Rect(0, 0, 3, 1))
+	for x := 0; x < 3; x++ {
+		main.SetNRGBA(x, 0, blue)
+	}
+
+	// a faint, a borderline and a strong watermark pixel
+	wm := image.NewNRGBA(main.Rect)
+	wm.SetNRGBA(0, 0, color.NRGBA{255, 0, 0, 100})
+	wm.SetNRGBA(1, 0, color.NRGBA{255, 0, 0, 128})
+	wm.SetNRGBA(2, 0, color.NRGBA{255, 0, 0, 200})
+
+	out, err := WatermarkImage(main, []WatermarkSpec{{Image: wm}}, WithThreshold(128))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		x       int
+		blended bool
+	}{
+		{0, false},
+		{1, true},
+		{2, true},
+	}
+	for _, tt := range tests {
+		got := color.NRGBAModel.Convert(out.At(tt.x, 0)).(color.NRGBA)
+		if (got != blue) != tt.blended {
+			t.Errorf("pixel %d = %v, want blended %v", tt.x, got, tt.blended)
+		}
+	}
+}
+
+func TestWatermarkImage(t *testing.T) {
+	black := color.NRGBA{0, 0, 0, 255}
+	white := color.NRGBA{255, 255, 255, 255}
+	main := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	draw.Draw(main, main.Rect, image.NewUniform(black), image.Point{}, draw.Src)
+	wm := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	draw.Draw(wm, wm.Rect, image.NewUniform(white), image.Point{}, draw.Src)
+
+	tests := []struct {
+		name string
+		spec WatermarkSpec
+		want image.Rectangle
+	}{
+		{"position", WatermarkSpec{Image: wm, X: 1, Y: 1}, image.Rect(1, 1, 3, 3)},
+		{"anchor", WatermarkSpec{Image: wm, Anchor: "bottom-right"}, image.Rect(2, 2, 4, 4)},
+		{"clipped", WatermarkSpec{Image: wm, X: 3, Y: -1}, image.Rect(3, 0, 4, 1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := WatermarkImage(main, []WatermarkSpec{tt.spec})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for y := 0; y < 4; y++ {
+				for x := 0; x < 4; x++ {
+					want := black
+					if image.Pt(x, y).In(tt.want) {
+						want = white
+					}
+					if got := color.NRGBAModel.Convert(out.At(x, y)); got != want {
+						t.Errorf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+					}
+					if got := main.NRGBAAt(x, y); got != black {
+						t.Fatalf("main image was modified at (%d, %d) to %v", x, y, got)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestAddWatermarksTwo(t *testing.T) {
+	dir := t.TempDir()
+	in, out := filepath.Join(dir, "in.png"), filepath.Join(dir, "out.png")
+	err := SaveImage(image.NewGray(image.Rect(0, 0, 20, 10)), in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	red := color.NRGBA{255, 0, 0, 255}
+	blue := color.NRGBA{0, 0, 255, 255}
+	logo := image.NewNRGBA(image.Rect(0, 0, 4, 3))
+	draw.Draw(logo, logo.Rect, image.NewUniform(red), image.Point{}, draw.Src)
+	banner := image.NewNRGBA(image.Rect(0, 0, 8, 2))
+	draw.Draw(banner, banner.Rect, image.NewUniform(blue), image.Point{}, draw.Src)
+
+	specs := []WatermarkSpec{
+		{Image: logo, X: 1, Y: 1},
+		{Image: banner, Anchor: "bottom-right"},
+	}
+	err = AddWatermarks(in, specs, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := ReadImage(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logoAt, bannerAt := image.Rect(1, 1, 5, 4), image.Rect(12, 8, 20, 10)
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 20; x++ {
+			want := color.NRGBA{0, 0, 0, 255}
+			switch p := image.Pt(x, y); {
+			case p.In(logoAt):
+				want = red
+			case p.In(bannerAt):
+				want = blue
+			}
+			if got := color.NRGBAModel.Convert(img.At(x, y)); got != want {
+				t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestWatermarkLargerThanMain(t *testing.T) {
+	tests := []struct {
+		name    string
+		wm      image.Rectangle
+		opts    []Option
+		want    image.Rectangle
+		wantErr bool
+	}{
+		{"scaled to fit", image.Rect(0, 0, 500, 500), nil, image.Rect(0, 0, 100, 100), false},
+		{"scaled keeping its aspect ratio", image.Rect(0, 0, 500, 250), nil, image.Rect(0, 0, 100, 50), false},
+		{"not resized", image.Rect(0, 0, 500, 500), []Option{WithNoResize()}, image.Rectangle{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			red := image.NewNRGBA(tt.wm)
+			draw.Draw(red, red.Rect, image.NewUniform(color.NRGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+
+			out, err := WatermarkImage(image.NewGray(image.Rect(0, 0, 100, 100)), []WatermarkSpec{{Image: red}}, tt.opts...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WatermarkImage error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := redBounds(out); got != tt.want {
+				t.Errorf("watermark covers %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWatermarkNoResize(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.png")
+	err := SaveImage(image.NewGray(image.Rect(0, 0, 40, 30)), in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		wm      image.Rectangle
+		wantErr bool
+	}{
+		{"fits", image.Rect(0, 0, 40, 30), false},
+		{"too wide", image.Rect(0, 0, 41, 10), true},
+		{"too tall", image.Rect(0, 0, 10, 31), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wm, out := filepath.Join(t.TempDir(), "wm.png"), filepath.Join(t.TempDir(), "out.png")
+			red := image.NewNRGBA(tt.wm)
+			draw.Draw(red, red.Rect, image.NewUniform(color.NRGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+			err := SaveImage(red, wm)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = AddWatermarkImage(in, wm, out, "", 0, 0, 0, 0, WithNoResize())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("AddWatermarkImage error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if !strings.Contains(err.Error(), "does not fit") {
+					t.Errorf("error %q does not say the watermark does not fit", err)
+				}
+				if _, statErr := os.Stat(out); !errors.Is(statErr, fs.ErrNotExist) {
+					t.Errorf("output written despite the error: %v", statErr)
+				}
+				return
+			}
+
+			img, err := ReadImage(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := redBounds(img); got != tt.wm {
+				t.Errorf("watermark covers %v, want %v at its own size", got, tt.wm)
+			}
+		})
+	}
+}
+
+func TestWatermarkScale(t *testing.T) {
+	red := image.NewNRGBA(image.Rect(0, 0, 40, 20))
+	draw.Draw(red, red.Rect, image.NewUniform(color.NRGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+
+	tests := []struct {
+		name  string
+		main  image.Rectangle
+		scale float64
+		want  image.Rectangle
+	}{
+		{"half of 200px", image.Rect(0, 0, 200, 100), 0.5, image.Rect(0, 0, 100, 50)},
+		{"quarter of 400px", image.Rect(0, 0, 400, 300), 0.25, image.Rect(0, 0, 100, 50)},
+		{"shrunk", image.Rect(0, 0, 50, 50), 0.4, image.Rect(0, 0, 20, 10)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := WatermarkImage(image.NewGray(tt.main), []WatermarkSpec{{Image: red}}, WithScale(tt.scale))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := redBounds(out); got != tt.want {
+				t.Errorf("watermark covers %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWatermarkUpscale(t *testing.T) {
+	main := image.NewGray(image.Rect(0, 0, 100, 100))
+	spec := WatermarkSpec{Image: redSquare(4), Width: 20, Height: 20}
+
+	tests := []struct {
+		name string
+		opts []Option
+		want image.Rectangle
+	}{
+		{"kept small", nil, image.Rect(0, 0, 4, 4)},
+		{"upscaled", []Option{WithUpscale()}, image.Rect(0, 0, 20, 20)},
+		{"upscaled with nearest", []Option{WithUpscale(), WithResample(Nearest)}, image.Rect(0, 0, 20, 20)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := WatermarkImage(main, []WatermarkSpec{spec}, tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := redBounds(out); got != tt.want {
+				t.Errorf("watermark covers %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLuminanceMask(t *testing.T) {
+	// a gradient from black on the left to white on the right
+	main := image.NewGray(image.Rect(0, 0, 64, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 64; x++ {
+			main.SetGray(x, y, color.Gray{uint8(x * 255 / 63)})
+		}
+	}
+
+	red := image.NewNRGBA(main.Rect)
+	draw.Draw(red, red.Rect, image.NewUniform(color.NRGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+
+	// redness returns the mean of how far red is ahead of green over the
+	// columns from x0 to x1, which is the watermark alpha on a gray pixel
+	redness := func(img image.Image, x0, x1 int) int {
+		total := 0
+		for y := 0; y < 8; y++ {
+			for x := x0; x < x1; x++ {
+				c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+				total += int(c.R) - int(c.G)
+			}
+		}
+		return total / (8 * (x1 - x0))
+	}
+
+	tests := []struct {
+		name         string
+		opt          Option
+		strong, weak [2]int
+		// the columns where the watermark is fully shown and hidden
+		shown, hidden int
+	}{
+		{"only bright", WithOnlyBright(), [2]int{32, 64}, [2]int{0, 32}, 63, 0},
+		{"only dark", WithOnlyDark(), [2]int{0, 32}, [2]int{32, 64}, 0, 63},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := WatermarkImage(main, []WatermarkSpec{{Image: red}}, tt.opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			strong := redness(out, tt.strong[0], tt.strong[1])
+			weak := redness(out, tt.weak[0], tt.weak[1])
+			if strong < 150 || weak > 100 {
+				t.Errorf("watermark alpha = %d on the half it shows on and %d on the other, want over 150 and under 100", strong, weak)
+			}
+			if got := redness(out, tt.shown, tt.shown+1); got != 255 {
+				t.Errorf("watermark alpha at column %d = %d, want 255", tt.shown, got)
+			}
+			if got := redness(out, tt.hidden, tt.hidden+1); got != 0 {
+				t.Errorf("watermark alpha at column %d = %d, want 0", tt.hidden, got)
+			}
+		})
+	}
+}
+
+func TestSaveImageEncodeFailure(t *testing.T) {
+	// an encoder that fails halfway through writing the file
+	errEncode := errors.New("encode failed")
+	RegisterEncoder("failing", func(w io.Writer, img image.Image) error {
+		_, err := w.Write([]byte("partial"))
+		if err != nil {
+			return err
+		}
+		return errEncode
+	})
+
+	tests := []struct {
+		name     string
+		existing []byte
+	}{
+		{"new file", nil},
+		{"existing file", []byte("original")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "out.failing")
+			if tt.existing != nil {
+				err := os.WriteFile(path, tt.existing, 0o644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := SaveImage(image.NewNRGBA(image.Rect(0, 0, 1, 1)), path, WithOverwrite())
+			if !errors.Is(err, errEncode) {
+				t.Fatalf("SaveImage error = %v, want %v", err, errEncode)
+			}
+
+			data, err := os.ReadFile(path)
+			switch {
+			case tt.existing == nil && !errors.Is(err, fs.ErrNotExist):
+				t.Errorf("output was created with %q, err %v", data, err)
+			case tt.existing != nil && !bytes.Equal(data, tt.existing):
+				t.Errorf("existing output = %q, err %v, want %q", data, err, tt.existing)
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// no temporary file is left behind
+			want := 0
+			if tt.existing != nil {
+				want = 1
+			}
+			if len(entries) != want {
+				t.Errorf("directory holds %d files, want %d", len(entries), want)
+			}
+		})
+	}
+}
+
+func TestWatermarkStream(t *testing.T) {
+	var stdin bytes.Buffer
+	err := png.Encode(&stdin, image.NewGray(image.Rect(0, 0, 16, 8)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	red := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	draw.Draw(red, red.Rect, image.NewUniform(color.NRGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+	specs := []WatermarkSpec{{Image: red, Anchor: "bottom-right"}}
+
+	var stdout bytes.Buffer
+	err = watermarkStream(&stdin, &stdout, "-", "png", specs, "-", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the output format defaults to the input format
+	out, err := png.Decode(&stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := redBounds(out), image.Rect(12, 4, 16, 8); got != want {
+		t.Errorf("watermark placed at %v, want %v", got, want)
+	}
+
+	err = watermarkStream(&stdin, &stdout, "-", "", specs, "-", "png")
+	if err == nil {
+		t.Error("reading stdin without a format was accepted")
+	}
+}
+
+func TestReadImageColorModels(t *testing.T) {
+	// testdata/cmyk.jpg is an Adobe CMYK JPEG from the Go image tests and
+	// testdata/cmyk.png the RGB rendering they check it against
+	cmyk, err := ReadImage("testdata/cmyk.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cmyk.(*image.NRGBA); !ok {
+		t.Fatalf("CMYK JPEG read as %T, want *image.NRGBA", cmyk)
+	}
+	ref, err := ReadImage("testdata/cmyk.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmyk.Bounds() != ref.Bounds() {
+		t.Fatalf("bounds = %v, want %v", cmyk.Bounds(), ref.Bounds())
+	}
+	b := cmyk.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			got := cmyk.(*image.NRGBA).NRGBAAt(x, y)
+			want := color.NRGBAModel.Convert(ref.At(x, y)).(color.NRGBA)
+			if !closeNRGBA(got, want, 2) {
+				t.Fatalf("CMYK pixel (%d, %d) = %v, want about %v", x, y, got, want)
+			}
+		}
+	}
+
+	red := color.NRGBA{255, 0, 0, 255}
+	frame := image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{color.Transparent, red})
+	for i := 0; i < 8; i++ {
+		frame.Pix[i] = 1
+	}
+	in := writeTestGIF(t, &gif.GIF{Image: []*image.Paletted{frame}, Delay: []int{0}})
+
+	paletted, err := ReadImage(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nrgba, ok := paletted.(*image.NRGBA)
+	if !ok {
+		t.Fatalf("paletted GIF read as %T, want *image.NRGBA", paletted)
+	}
+	if got := nrgba.NRGBAAt(3, 1); got != red {
+		t.Errorf("opaque GIF pixel = %v, want %v", got, red)
+	}
+	if got := nrgba.NRGBAAt(0, 2); got != (color.NRGBA{}) {
+		t.Errorf("transparent GIF pixel = %v, want transparent", got)
+	}
+}
+
+// openFiles returns the number of file descriptors the process has open,
+// skipping the test where /proc does not list them.
+func openFiles(t *testing.T) int {
+	t.Helper()
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("open file descriptors cannot be counted:", err)
+	}
+	return len(fds)
+}
+
+func TestAddWatermarkImageClosesFiles(t *testing.T) {
+	dir := t.TempDir()
+	mainPath, wmPath := filepath.Join(dir, "main.png"), filepath.Join(dir, "wm.png")
+	err := SaveImage(image.NewGray(image.Rect(0, 0, 16, 16)), mainPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SaveImage(image.NewNRGBA(image.Rect(0, 0, 4, 4)), wmPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out.png")
+
+	before := openFiles(t)
+	for i := 0; i < 2000; i++ {
+		err := AddWatermarkImage(mainPath, wmPath, out, "center", 0, 0, 0, 0, WithOverwrite())
+		if err != nil {
+			t.Fatalf("run %d: %v", i, err)
+		}
+	}
+	if after := openFiles(t); after > before {
+		t.Errorf("%d files open after 2000 runs, %d before", after, before)
+	}
+}
+
+func TestReadImageFS(t *testing.T) {
+	var logo bytes.Buffer
+	err := png.Encode(&logo, redSquare(6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := fstest.MapFS{
+		"assets/logo.png": {Data: logo.Bytes()},
+		"assets/logo.txt": {Data: []byte("not an image")},
+	}
+
+	img, err := ReadImageFS(fsys, "assets/logo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := redBounds(img); got != image.Rect(0, 0, 6, 6) {
+		t.Errorf("red covers %v, want the whole 6x6 logo", got)
+	}
+
+	_, err = ReadImageFS(fsys, "assets/missing.png")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("missing file error = %v, want %v", err, fs.ErrNotExist)
+	}
+	_, err = ReadImageFS(fsys, "assets/logo.txt")
+	if !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("text file error = %v, want %v", err, ErrUnsupportedFormat)
+	}
+}
+
+// hugePNG returns a PNG whose header declares a width x height image,
+// with a valid checksum but no pixel data.
+func hugePNG(width, height uint32) []byte {
+	ihdr := make([]byte, 0, 17)
+	ihdr = append(ihdr, "IHDR"...)
+	ihdr = binary.BigEndian.AppendUint32(ihdr, width)
+	ihdr = binary.BigEndian.AppendUint32(ihdr, height)
+	// 8-bit RGBA, default compression, filter and no interlacing
+	ihdr = append(ihdr, 8, 6, 0, 0, 0)
+
+	data := []byte("\x89PNG\r\n\x1a\n")
+	data = binary.BigEndian.AppendUint32(data, 13)
+	data = append(data, ihdr...)
+	return binary.BigEndian.AppendUint32(data, crc32.ChecksumIEEE(ihdr))
+}
+
+func TestReadImageMaxDimension(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		err := os.WriteFile(path, data, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	// a GIF declaring the largest logical screen it can
+	var small bytes.Buffer
+	err := gif.Encode(&small, image.NewPaletted(image.Rect(0, 0, 1, 1), color.Palette{color.Black}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hugeGIF := small.Bytes()
+	binary.LittleEndian.PutUint16(hugeGIF[6:], 65535)
+	binary.LittleEndian.PutUint16(hugeGIF[8:], 65535)
+
+	tests := []struct {
+		name string
+		path string
+	}{
+		{"wide PNG", write("wide.png", hugePNG(100000, 10))},
+		{"huge PNG", write("huge.png", hugePNG(50000, 50000))},
+		{"huge GIF", write("huge.gif", hugeGIF)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// rejected from the header, before any pixels are allocated
+			_, err := ReadImage(tt.path, WithMaxDimension(20000))
+			if !errors.Is(err, ErrTooLarge) {
+				t.Errorf("ReadImage error = %v, want %v", err, ErrTooLarge)
+			}
+		})
+	}
+
+	err = AddWatermarkGIF(tests[2].path, image.NewNRGBA(image.Rect(0, 0, 1, 1)), filepath.Join(dir, "out.gif"), "", 0, 0, 0, 0, WithMaxDimension(20000))
+	if !errors.Is(err, ErrTooLarge) {
+		t.Errorf("AddWatermarkGIF error = %v, want %v", err, ErrTooLarge)
+	}
+}
+
+func TestAddWatermarksPreserveTime(t *testing.T) {
+	taken := time.Date(2019, 6, 1, 12, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		in, out  string
+		opts     []Option
+		preserve bool
+	}{
+		{"png", "in.png", "out.png", []Option{WithPreserveTime()}, true},
+		{"gif", "in.gif", "out.gif", []Option{WithPreserveTime()}, true},
+		{"without the option", "in.png", "out.png", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			in, out := filepath.Join(dir, tt.in), filepath.Join(dir, tt.out)
+			err := SaveImage(image.NewGray(image.Rect(0, 0, 20, 10)), in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = os.Chtimes(in, taken, taken)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = AddWatermarks(in, []WatermarkSpec{{Image: image.NewNRGBA(image.Rect(0, 0, 4, 4))}}, out, tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			info, err := os.Stat(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := info.ModTime().Equal(taken); got != tt.preserve {
+				t.Errorf("output modified at %v, input at %v", info.ModTime(), taken)
+			}
+		})
+	}
+}
+
+func TestReadImageTruncated(t *testing.T) {
+	// noise, so the compressed data is large enough to cut in half
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 131 >> 3)
+	}
+
+	for _, format := range []string{"png", "jpg", "gif"} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := WriteImageTo(&buf, img, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			path := filepath.Join(t.TempDir(), "half."+format)
+			err = os.WriteFile(path, buf.Bytes()[:buf.Len()/2], 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = ReadImage(path)
+			if !errors.Is(err, ErrCorrupt) {
+				t.Fatalf("ReadImage of a half-written %s = %v, want %v", format, err, ErrCorrupt)
+			}
+			if !strings.Contains(err.Error(), path) {
+				t.Errorf("error %q does not name %s", err, path)
+			}
+		})
+	}
+}
+
+func TestAddWatermarkGIFFrames(t *testing.T) {
+	anim := &gif.GIF{
+		Image:     []*image.Paletted{solidFrame(30, 20, 0), solidFrame(30, 20, 1), solidFrame(30, 20, 0)},
+		Delay:     []int{10, 25, 50},
+		Disposal:  []byte{gif.DisposalNone, gif.DisposalBackground, gif.DisposalPrevious},
+		LoopCount: 3,
+	}
+	in := writeTestGIF(t, anim)
+	out := filepath.Join(t.TempDir(), "out.gif")
+
+	white := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	draw.Draw(white, white.Rect, image.White, image.Point{}, draw.Src)
+	err := AddWatermarkGIF(in, white, out, "", 2, 2, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := readTestGIF(t, out)
+	if len(got.Image) != 3 {
+		t.Fatalf("output has %d frames, want 3", len(got.Image))
+	}
+	if !reflect.DeepEqual(got.Delay, anim.Delay) {
+		t.Errorf("delays = %v, want %v", got.Delay, anim.Delay)
+	}
+	if !reflect.DeepEqual(got.Disposal, anim.Disposal) {
+		t.Errorf("disposal methods = %v, want %v", got.Disposal, anim.Disposal)
+	}
+	if got.LoopCount != anim.LoopCount {
+		t.Errorf("loop count = %d, want %d", got.LoopCount, anim.LoopCount)
+	}
+
+	for i, frame := range got.Image {
+		if c := color.GrayModel.Convert(frame.At(3, 3)); c != (color.Gray{255}) {
+			t.Errorf("frame %d under the watermark is %v, want white", i, c)
+		}
+		if c, want := color.GrayModel.Convert(frame.At(20, 10)), color.GrayModel.Convert(anim.Image[i].At(20, 10)); c != want {
+			t.Errorf("frame %d off the watermark is %v, want %v", i, c, want)
+		}
+	}
+}
+
+func TestAddWatermarkGIFReports(t *testing.T) {
+	in := writeTestGIF(t, &gif.GIF{
+		Image: []*image.Paletted{solidFrame(30, 20, 0), solidFrame(30, 20, 2)},
+		Delay: []int{10, 10},
+	})
+	out := filepath.Join(t.TempDir(), "out.gif")
+
+	var logged bytes.Buffer
+	stats := startStats()
+	wm := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	err := AddWatermarks(in, []WatermarkSpec{{Image: wm}}, out, WithLogger(log.New(&logged, "", 0)), withStats(stats))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"read " + in + ": 30x20 gif, 2 frames", "wrote " + out + ": 30x20 gif, 2 frames"} {
+		if !strings.Contains(logged.String(), want) {
+			t.Errorf("log %q does not report %q", logged.String(), want)
+		}
+	}
+	if stats.peak != image.Pt(30, 20) {
+		t.Errorf("largest image = %v, want 30x20", stats.peak)
+	}
+}
+
+func TestAddWatermarkGIFTruncated(t *testing.T) {
+	frames := []*image.Paletted{solidFrame(64, 64, 0), solidFrame(64, 64, 1), solidFrame(64, 64, 2)}
+	for _, frame := range frames {
+		for i := range frame.Pix {
+			frame.Pix[i] = uint8(i*131>>3) % 3
+		}
+	}
+	in := writeTestGIF(t, &gif.GIF{Image: frames, Delay: []int{10, 10, 10}})
+	data, err := os.ReadFile(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(in, data[:len(data)/2], 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wm := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	err = AddWatermarkGIF(in, wm, filepath.Join(t.TempDir(), "out.gif"), "", 0, 0, 0, 0)
+	if !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("AddWatermarkGIF of a half-written GIF = %v, want %v", err, ErrCorrupt)
+	}
+	if !strings.Contains(err.Error(), in) {
+		t.Errorf("error %q does not name %s", err, in)
+	}
+}
+
+// writeTestGIF encodes anim into a file in a temporary directory and
+// returns its path.
+func writeTestGIF(t *testing.T, anim *gif.GIF) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "in.gif")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	err = gif.EncodeAll(file, anim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// readTestGIF decodes the animation at path.
+func readTestGIF(t *testing.T, path string) *gif.GIF {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	anim, err := gif.DecodeAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return anim
+}
+
+// solidFrame returns a w x h frame of a black, gray and white palette
+// filled with its color at index.
+func solidFrame(w, h int, index uint8) *image.Paletted {
+	frame := image.NewPaletted(image.Rect(0, 0, w, h), color.Palette{color.Black, color.Gray{128}, color.White})
+	for i := range frame.Pix {
+		frame.Pix[i] = index
+	}
+	return frame
+}
+
+func TestWatermarkImageClipped(t *testing.T) {
+	red := color.NRGBA{255, 0, 0, 255}
+	blue := color.NRGBA{0, 0, 255, 255}
+	wm := image.NewNRGBA(image.Rect(0, 0, 20, 4))
+	draw.Draw(wm, wm.Rect, image.NewUniform(red), image.Point{}, draw.Src)
+
+	tests := []struct {
+		name    string
+		x, y    int
+		want    image.Rectangle
+		wantErr bool
+	}{
+		{"past the left edge", -10, 0, image.Rect(0, 0, 10, 4), false},
+		{"past the top edge", 5, -2, image.Rect(5, 0, 25, 2), false},
+		{"one column on", -19, 0, image.Rect(0, 0, 1, 4), false},
+		{"past the right edge", 25, 6, image.Rect(25, 6, 30, 10), false},
+		{"touching the left edge", -20, 0, image.Rectangle{}, true},
+		{"touching the top edge", 0, -4, image.Rectangle{}, true},
+		{"at the right edge", 30, 0, image.Rectangle{}, true},
+		{"at the bottom edge", 0, 10, image.Rectangle{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			main := image.NewNRGBA(image.Rect(0, 0, 30, 10))
+			draw.Draw(main, main.Rect, image.NewUniform(blue), image.Point{}, draw.Src)
+
+			out, err := WatermarkImage(main, []WatermarkSpec{{Image: wm, X: tt.x, Y: tt.y}})
+			if tt.wantErr {
+				if !errors.Is(err, ErrOutOfBounds) {
+					t.Fatalf("error = %v, want %v", err, ErrOutOfBounds)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for y := 0; y < 10; y++ {
+				for x := 0; x < 30; x++ {
+					want := blue
+					if image.Pt(x, y).In(tt.want) {
+						want = red
+					}
+					if got := color.NRGBAModel.Convert(out.At(x, y)); got != want {
+						t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestAddWatermarkPreservesDepth(t *testing.T) {
+	// 0x1234 has no 8-bit equivalent, so it only survives in 16 bits
+	fine := color.NRGBA64{0x1234, 0x5678, 0x9abc, 0xffff}
+	main := image.NewNRGBA64(image.Rect(0, 0, 16, 16))
+	draw.Draw(main, main.Rect, image.NewUniform(fine), image.Point{}, draw.Src)
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.png")
+	err := SaveImage(main, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a half transparent white watermark over the left half
+	wm := image.NewNRGBA(image.Rect(0, 0, 8, 16))
+	draw.Draw(wm, wm.Rect, image.NewUniform(color.NRGBA{255, 255, 255, 128}), image.Point{}, draw.Src)
+	out := filepath.Join(dir, "out.png")
+	err = AddWatermark(in, wm, out, "", 0, 0, 0, 0, WithPreserveDepth())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := ReadImageConfig(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ColorModel != color.NRGBA64Model && config.ColorModel != color.RGBA64Model {
+		t.Fatal("output PNG is not 16-bit")
+	}
+	img, err := ReadImage(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := color.NRGBA64Model.Convert(img.At(12, 8)); got != fine {
+		t.Errorf("pixel off the watermark = %v, want %v", got, fine)
+	}
+	// the blend keeps the low bits too, which 8 bits would round away
+	got := color.NRGBA64Model.Convert(img.At(4, 8)).(color.NRGBA64)
+	want := color.NRGBA64Model.Convert(Blend(color.NRGBA{255, 255, 255, 128}, fine)).(color.NRGBA64)
+	if got != want {
+		t.Errorf("watermarked pixel = %v, want %v", got, want)
+	}
+	if got.R&0xff == got.R>>8 {
+		t.Errorf("watermarked pixel %v only holds 8-bit precision", got)
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	main := image.NewGray(image.Rect(0, 0, 800, 600))
+	out, err := WatermarkImage(main, []WatermarkSpec{{Image: redSquare(80), Anchor: "bottom-right"}}, WithThumbnail(200, 0), WithResample(Nearest))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := out.Bounds(), image.Rect(0, 0, 200, 150); got != want {
+		t.Fatalf("thumbnail bounds = %v, want %v", got, want)
+	}
+	// the watermark is scaled down with the image
+	if got, want := redBounds(out), image.Rect(180, 130, 200, 150); got != want {
+		t.Errorf("watermark covers %v of the thumbnail, want %v", got, want)
+	}
+}
+
+func TestThumbnailPreservesDepth(t *testing.T) {
+	// 0x1234 has no 8-bit equivalent, so it only survives in 16 bits
+	fine := color.NRGBA64{0x1234, 0x5678, 0x9abc, 0xffff}
+	main := image.NewNRGBA64(image.Rect(0, 0, 80, 60))
+	draw.Draw(main, main.Rect, image.NewUniform(fine), image.Point{}, draw.Src)
+	wm := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+
+	for name, resample := range map[string]Resample{"nearest": Nearest, "bilinear": Bilinear, "lanczos": Lanczos} {
+		t.Run(name, func(t *testing.T) {
+			out, err := WatermarkImage(main, []WatermarkSpec{{Image: wm}}, WithPreserveDepth(), WithThumbnail(20, 0), WithResample(resample))
+			if err != nil {
+				t.Fatal(err)
+			}
+			deep, ok := out.(*image.NRGBA64)
+			if !ok {
+				t.Fatalf("thumbnail is a %T, want *image.NRGBA64", out)
+			}
+			if deep.Rect != image.Rect(0, 0, 20, 15) {
+				t.Errorf("thumbnail bounds = %v, want 20x15", deep.Rect)
+			}
+			if got := deep.NRGBA64At(10, 10); got != fine {
+				t.Errorf("thumbnail pixel = %v, want %v", got, fine)
+			}
+		})
+	}
+}
+
+func TestAutoColor(t *testing.T) {
+	red := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	draw.Draw(red, red.Rect, image.NewUniform(color.NRGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+
+	tests := []struct {
+		name       string
+		background color.Color
+		want       color.NRGBA
+	}{
+		{"dark", color.Gray{30}, color.NRGBA{255, 255, 255, 255}},
+		{"light", color.Gray{220}, color.NRGBA{0, 0, 0, 255}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			main := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+			draw.Draw(main, main.Rect, image.NewUniform(tt.background), image.Point{}, draw.Src)
+
+			out, err := WatermarkImage(main, []WatermarkSpec{{Image: red, X: 2, Y: 2}}, WithAutoColor())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := color.NRGBAModel.Convert(out.At(3, 3)); got != tt.want {
+				t.Errorf("watermark = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAutoColorGIF(t *testing.T) {
+	// a dark first frame picks white, which the light frame keeps
+	in := writeTestGIF(t, &gif.GIF{
+		Image: []*image.Paletted{solidFrame(8, 8, 0), solidFrame(8, 8, 2)},
+		Delay: []int{10, 10},
+	})
+	out := filepath.Join(t.TempDir(), "out.gif")
+
+	red := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	draw.Draw(red, red.Rect, image.NewUniform(color.NRGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+	err := AddWatermarkGIF(in, red, out, "", 2, 2, 0, 0, WithAutoColor())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	white := color.NRGBA{255, 255, 255, 255}
+	for i, frame := range readTestGIF(t, out).Image {
+		if got := color.NRGBAModel.Convert(frame.At(3, 3)); got != white {
+			t.Errorf("watermark on frame %d = %v, want %v", i, got, white)
+		}
+	}
+}
+
+// blendTestImages returns a watermark running through every alpha across
+// and a main image with a translucent lower half to blend it onto.
+func blendTestImages() (wm, main *image.NRGBA) {
+	wm = image.NewNRGBA(image.Rect(0, 0, 256, 4))
+	main = image.NewNRGBA(wm.Rect)
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 256; x++ {
+			wm.SetNRGBA(x, y, color.NRGBA{255, uint8(x), uint8(y * 60), uint8(x)})
+			alpha := uint8(255)
+			if y >= 2 {
+				alpha = uint8(64 * y)
+			}
+			main.SetNRGBA(x, y, color.NRGBA{uint8(255 - x), 40, 200, alpha})
+		}
+	}
+	return wm, main
+}
+
+func TestBlendNRGBAMatchesGeneric(t *testing.T) {
+	wm, main := blendTestImages()
+
+	// the watermark as another type keeps blendWatermark off the fast path
+	slow := image.NewRGBA64(wm.Rect)
+	draw.Draw(slow, slow.Rect, wm, image.Point{}, draw.Src)
+
+	fast := copyNRGBA(main)
+	err := blendWatermark(fast, wm, 0, 0, newOptions(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an RGBA main image is only compared where it is opaque, as storing
+	// the translucent rows premultiplied in 8 bits already rounds them
+	dsts := []struct {
+		name string
+		dst  draw.Image
+		rows int
+	}{
+		{"NRGBA", copyNRGBA(main), main.Rect.Dy()},
+		{"RGBA", image.NewRGBA(main.Rect), 2},
+	}
+	draw.Draw(dsts[1].dst, main.Rect, main, image.Point{}, draw.Src)
+
+	for _, d := range dsts {
+		t.Run(d.name, func(t *testing.T) {
+			err := blendWatermark(d.dst, slow, 0, 0, newOptions(nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for y := 0; y < d.rows; y++ {
+				for x := 0; x < main.Rect.Dx(); x++ {
+					want := color.NRGBAModel.Convert(d.dst.At(x, y)).(color.NRGBA)
+					got := fast.NRGBAAt(x, y)
+					if got != want {
+						t.Fatalf("pixel (%d, %d) = %v on the fast path, %v on the generic path", x, y, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestBlendNRGBAOptionsMatchGeneric(t *testing.T) {
+	wm, main := blendTestImages()
+	slow := image.NewRGBA64(wm.Rect)
+	draw.Draw(slow, slow.Rect, wm, image.Point{}, draw.Src)
+
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{"linear", []Option{WithLinearBlend()}},
+		{"multiply", []Option{WithBlendMode(Multiply)}},
+		{"screen", []Option{WithBlendMode(Screen)}},
+		{"overlay", []Option{WithBlendMode(Overlay)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fast, generic := copyNRGBA(main), copyNRGBA(main)
+			err := blendWatermark(fast, wm, 0, 0, newOptions(tt.opts))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = blendWatermark(generic, slow, 0, 0, newOptions(tt.opts))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for y := 0; y < main.Rect.Dy(); y++ {
+				for x := 0; x < main.Rect.Dx(); x++ {
+					// the float arithmetic of the fast path starts from 8
+					// bits, so it may round a channel the other way
+					got, want := fast.NRGBAAt(x, y), generic.NRGBAAt(x, y)
+					if !closeNRGBA(got, want, 1) {
+						t.Fatalf("pixel (%d, %d) = %v on the fast path, %v on the generic path", x, y, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+// closeNRGBA reports whether no channel of a and b differs by more than
+// tolerance.
+func closeNRGBA(a, b color.NRGBA, tolerance int) bool {
+	for _, d := range []int{int(a.R) - int(b.R), int(a.G) - int(b.G), int(a.B) - int(b.B), int(a.A) - int(b.A)} {
+		if d < -tolerance || d > tolerance {
+			return false
+		}
+	}
+	return true
+}
+
+func TestResizeNRGBAMatchesGeneric(t *testing.T) {
+	wm, _ := blendTestImages()
+	slow := image.NewRGBA64(wm.Rect)
+	draw.Draw(slow, slow.Rect, wm, image.Point{}, draw.Src)
+
+	sizes := []image.Point{{100, 3}, {256, 4}, {300, 9}, {1, 1}}
+	for _, size := range sizes {
+		fast, err := ResizeImage(wm, size.X, size.Y)
+		if err != nil {
+			t.Fatal(err)
+		}
+		generic, err := ResizeImage(slow, size.X, size.Y)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for y := 0; y < size.Y; y++ {
+			for x := 0; x < size.X; x++ {
+				// the generic path goes through premultiplied colors, which
+				// rounds the channels of nearly transparent pixels
+				got := color.RGBAModel.Convert(fast.At(x, y))
+				want := color.RGBAModel.Convert(generic.At(x, y))
+				if got != want {
+					t.Fatalf("%dx%d pixel (%d, %d) = %v on the fast path, %v on the generic path", size.X, size.Y, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestResizeImageEmpty(t *testing.T) {
+	sources := []image.Image{image.NewNRGBA(image.Rect(0, 0, 0, 0)), image.NewRGBA64(image.Rect(0, 0, 0, 0))}
+	for _, src := range sources {
+		for _, resample := range []Resample{Nearest, Bilinear, Lanczos} {
+			out, err := ResizeImage(src, 4, 3, WithResample(resample))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out.Bounds() != image.Rect(0, 0, 4, 3) {
+				t.Errorf("%T resized to %v, want 4x3", src, out.Bounds())
+			}
+			if _, _, _, a := out.At(1, 1).RGBA(); a != 0 {
+				t.Errorf("%T resized with resample %d gave alpha %d, want transparent", src, resample, a)
+			}
+		}
+	}
+}
+
+func TestResizeImageZeroSize(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+
+	tests := []struct {
+		name          string
+		width, height int
+	}{
+		{"zero width", 0, 100},
+		{"zero height", 100, 0},
+		{"zero width and height", 0, 0},
+		{"negative width", -4, 100},
+		{"negative height", 100, -4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, resample := range []Resample{Nearest, Bilinear, Lanczos} {
+				out, err := ResizeImage(src, tt.width, tt.height, WithResample(resample))
+				if !errors.Is(err, ErrInvalidSize) {
+					t.Fatalf("ResizeImage(%d, %d) with resample %d error = %v, want %v", tt.width, tt.height, resample, err, ErrInvalidSize)
+				}
+				if out != nil {
+					t.Errorf("ResizeImage(%d, %d) returned an image with its error", tt.width, tt.height)
+				}
+			}
+		})
+	}
+}
+
+// benchmarkImage returns a 4000x3000 translucent NRGBA image, about the
+// size of a photo from a camera.
+func benchmarkImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 4000, 3000))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 7)
+	}
+	return img
+}
+
+func BenchmarkBlend(b *testing.B) {
+	wm := benchmarkImage()
+	slow := image.NewRGBA64(wm.Rect)
+	draw.Draw(slow, slow.Rect, wm, image.Point{}, draw.Src)
+
+	benchmarks := []struct {
+		name string
+		wm   image.Image
+	}{
+		{"NRGBA", wm},
+		{"generic", slow},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			dst := benchmarkImage()
+			o := newOptions(nil)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				err := blendWatermark(dst, bm.wm, 0, 0, o)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkResizeImage(b *testing.B) {
+	img := benchmarkImage()
+	slow := image.NewRGBA64(img.Rect)
+	draw.Draw(slow, slow.Rect, img, image.Point{}, draw.Src)
+
+	benchmarks := []struct {
+		name string
+		img  image.Image
+	}{
+		{"NRGBA", img},
+		{"generic", slow},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, err := ResizeImage(bm.img, 1000, 750)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkAddWatermarkImage(b *testing.B) {
+	// the runs of a benchmark write the same files again
+	opts := []Option{WithPNGCompression(png.NoCompression), WithOverwrite()}
+	dir := b.TempDir()
+	mainPath := filepath.Join(dir, "main.png")
+	err := SaveImage(benchmarkImage(), mainPath, opts...)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	// an 8-bit PNG decodes as NRGBA and a 16-bit one takes the generic path
+	wm, err := ResizeImage(benchmarkImage(), 1000, 750)
+	if err != nil {
+		b.Fatal(err)
+	}
+	slow := image.NewNRGBA64(wm.Bounds())
+	draw.Draw(slow, slow.Rect, wm, image.Point{}, draw.Src)
+
+	benchmarks := []struct {
+		name string
+		wm   image.Image
+	}{
+		{"NRGBA", wm},
+		{"generic", slow},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			wmPath := filepath.Join(dir, bm.name+".png")
+			err := SaveImage(bm.wm, wmPath, opts...)
+			if err != nil {
+				b.Fatal(err)
+			}
+			outPath := filepath.Join(dir, "out.png")
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				err := AddWatermarkImage(mainPath, wmPath, outPath, "center", 0, 0, 0, 0, opts...)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
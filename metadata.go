@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"io"
+)
+
+// Metadata holds the EXIF, XMP, ICC profile and IPTC segments of a JPEG, or
+// the ICC profile of a PNG, so they survive re-encoding, which image/jpeg
+// and image/png would otherwise drop. It is returned by
+// ReadImageWithMetadata and written back with WithMetadata, along with the
+// text set with WithAuthor and WithCopyright.
+type Metadata struct {
+	segments []jpegSegment
+	icc      []byte // profile of a PNG, a JPEG keeps it in its segments
+	text     []textField
+}
+
+// textField is a piece of text such as the author of an image, written as
+// a PNG iTXt chunk or a JPEG comment.
+type textField struct {
+	key, value string
+}
+
+// maxComment is the most text that fits in a JPEG comment segment.
+const maxComment = 0xffff - 2
+
+// withText returns a copy of m, which may be nil, with the author and
+// copyright text fields added. Empty values are left out, and m itself is
+// returned when both are empty.
+func (m *Metadata) withText(author, copyright string) *Metadata {
+	var text []textField
+	if author != "" {
+		text = append(text, textField{"Author", author})
+	}
+	if copyright != "" {
+		text = append(text, textField{"Copyright", copyright})
+	}
+	if text == nil {
+		return m
+	}
+
+	var withText Metadata
+	if m != nil {
+		withText = *m
+	}
+	withText.text = append(withText.text[:len(withText.text):len(withText.text)], text...)
+	return &withText
+}
+
+// comment returns the JPEG comment of the text field f.
+func (f textField) comment() []byte {
+	comment := f.key + ": " + f.value
+	if len(comment) > maxComment {
+		comment = comment[:maxComment]
+	}
+	return []byte(comment)
+}
+
+// jpegMetadata keeps the metadata segments of a JPEG header, or returns nil
+// when there are none. The EXIF thumbnail is dropped, see
+// stripExifThumbnail.
+func jpegMetadata(segments []jpegSegment) *Metadata {
+	var kept []jpegSegment
+	for _, segment := range segments {
+		switch segment.marker {
+		case 0xe1: // APP1 EXIF and XMP
+			kept = append(kept, jpegSegment{marker: segment.marker, data: stripExifThumbnail(segment.data)})
+		case 0xe2, 0xed: // APP2 ICC, APP13 IPTC
+			kept = append(kept, segment)
+		}
+	}
+
+	if len(kept) == 0 {
+		return nil
+	}
+
+	return &Metadata{segments: kept}
+}
+
+// encodeJPEG encodes img like jpeg.Encode, or with encodeJPEG444 for
+// Subsampling444 or progressive, and inserts the segments of meta right
+// after the start of image marker. The decoded image has already been
+// turned upright, so the EXIF orientation is reset to normal.
+func encodeJPEG(w io.Writer, img image.Image, quality int, subsampling Subsampling, progressive bool, meta *Metadata) error {
+	encode := func(w io.Writer) error {
+		if subsampling == Subsampling444 || progressive {
+			return encodeJPEG444(w, img, quality, progressive)
+		}
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	}
+
+	if meta == nil {
+		return encode(w)
+	}
+
+	var buf bytes.Buffer
+	err := encode(&buf)
+	if err != nil {
+		return err
+	}
+	encoded := buf.Bytes()
+
+	_, err = w.Write(encoded[:2])
+	if err != nil {
+		return err
+	}
+
+	for _, segment := range meta.jpegSegments() {
+		data := segment.data
+		if segment.marker == 0xe1 {
+			data = resetExifOrientation(data)
+		}
+
+		header := []byte{0xff, segment.marker, 0, 0}
+		binary.BigEndian.PutUint16(header[2:], uint16(len(data)+2))
+		_, err = w.Write(append(header, data...))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = w.Write(encoded[2:])
+	return err
+}
+
+// resetExifOrientation returns a copy of the APP1 segment data with its
+// EXIF orientation set to 1, or data itself when it has no orientation.
+func resetExifOrientation(data []byte) []byte {
+	offset, order := exifOrientationOffset(data)
+	if offset < 0 {
+		return data
+	}
+
+	reset := append([]byte(nil), data...)
+	order.PutUint16(reset[offset:], 1)
+	return reset
+}
+
+// headerReader reads the header of an image stream ahead of its decoder.
+// Everything pulled from the underlying reader, buffered or parsed, is
+// kept so the decoder can be handed the whole stream from the start.
+type headerReader struct {
+	*bufio.Reader
+	r        io.Reader
+	consumed bytes.Buffer
+}
+
+// newHeaderReader returns a headerReader reading from r.
+func newHeaderReader(r io.Reader) *headerReader {
+	hr := &headerReader{r: r}
+	hr.Reader = bufio.NewReader(io.TeeReader(r, &hr.consumed))
+	return hr
+}
+
+// replay returns a reader of the whole stream from the start, after which
+// hr must not be read from anymore.
+func (hr *headerReader) replay() io.Reader {
+	return io.MultiReader(&hr.consumed, hr.r)
+}
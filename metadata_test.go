@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// exifWithThumbnail returns the data of an APP1 segment with an
+// orientation in its first IFD and thumb as the JPEG thumbnail of its
+// second, with tail after it.
+func exifWithThumbnail(thumb, tail []byte) []byte {
+	le := binary.LittleEndian
+	tiff := []byte("II*\x00\x08\x00\x00\x00")
+
+	// IFD0: the orientation, then the offset of IFD1
+	tiff = le.AppendUint16(tiff, 1)
+	tiff = appendIFDEntry(tiff, 0x0112, 3, 1, 1)
+	ifd1 := len(tiff) + 4
+	tiff = le.AppendUint32(tiff, uint32(ifd1))
+
+	// IFD1: a resolution stored past the entries, and the thumbnail
+	resolution := ifd1 + 2 + 3*12 + 4
+	thumbOffset := resolution + 8
+	tiff = le.AppendUint16(tiff, 3)
+	tiff = appendIFDEntry(tiff, 0x011a, 5, 1, uint32(resolution))
+	tiff = appendIFDEntry(tiff, 0x0201, 4, 1, uint32(thumbOffset))
+	tiff = appendIFDEntry(tiff, 0x0202, 4, 1, uint32(len(thumb)))
+	tiff = le.AppendUint32(tiff, 0)
+	tiff = le.AppendUint32(tiff, 72)
+	tiff = le.AppendUint32(tiff, 1)
+	tiff = append(tiff, thumb...)
+	tiff = append(tiff, tail...)
+
+	return append([]byte("Exif\x00\x00"), tiff...)
+}
+
+// appendIFDEntry appends a little-endian IFD entry to b.
+func appendIFDEntry(b []byte, tag, typ uint16, count, value uint32) []byte {
+	le := binary.LittleEndian
+	b = le.AppendUint16(b, tag)
+	b = le.AppendUint16(b, typ)
+	b = le.AppendUint32(b, count)
+	return le.AppendUint32(b, value)
+}
+
+func TestMetadataDropsExifThumbnail(t *testing.T) {
+	thumb := []byte("\xff\xd8 thumbnail of the original \xff\xd9")
+
+	tests := []struct {
+		name string
+		tail []byte
+	}{
+		{"thumbnail at the end", nil},
+		{"thumbnail before other data", []byte("other data")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var src bytes.Buffer
+			err := jpeg.Encode(&src, image.NewGray(image.Rect(0, 0, 8, 8)), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			app1 := exifWithThumbnail(thumb, tt.tail)
+			header := []byte{0xff, 0xd8, 0xff, 0xe1, 0, 0}
+			binary.BigEndian.PutUint16(header[4:], uint16(len(app1)+2))
+			in := append(append(header, app1...), src.Bytes()[2:]...)
+
+			img, meta, err := decodeJPEG(bytes.NewReader(in))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var out bytes.Buffer
+			err = encodeJPEG(&out, img, 90, Subsampling420, false, meta)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			segments, _ := readJPEGHeader(&out)
+			var exif []byte
+			for _, segment := range segments {
+				if segment.marker == 0xe1 {
+					exif = segment.data
+				}
+			}
+			if exif == nil {
+				t.Fatal("EXIF segment was not kept")
+			}
+
+			if bytes.Contains(exif, thumb[2:10]) {
+				t.Error("EXIF segment still holds the thumbnail")
+			}
+			if !bytes.HasSuffix(exif, tt.tail) {
+				t.Error("EXIF segment lost the data after the thumbnail")
+			}
+			if tt.tail == nil && len(exif) >= len(app1) {
+				t.Errorf("EXIF segment of %d bytes was not cut short of %d", len(exif), len(app1))
+			}
+			if exifOrientation(segments) != 1 {
+				t.Errorf("orientation = %d, want 1", exifOrientation(segments))
+			}
+
+			tiff, ifd, count, order := exifIFD0(exif)
+			if next := order.Uint32(tiff[ifd+2+count*12:]); next != 0 {
+				t.Errorf("IFD0 still links to an IFD at %d", next)
+			}
+		})
+	}
+}
+
+func TestHeaderReaderReplay(t *testing.T) {
+	// longer than the buffer, so part of it is still unread at the replay
+	data := make([]byte, 10000)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+
+	hr := newHeaderReader(bytes.NewReader(data))
+	var header [3]byte
+	if _, err := io.ReadFull(hr, header[:]); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := io.ReadAll(hr.replay())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("replay gave %d bytes, want the %d of the whole stream", len(got), len(data))
+	}
+}
+
+// jpegWithOrientation encodes img as a JPEG with an EXIF segment holding
+// only the orientation.
+func jpegWithOrientation(t *testing.T, img image.Image, orientation int) []byte {
+	t.Helper()
+	var src bytes.Buffer
+	err := jpeg.Encode(&src, img, &jpeg.Options{Quality: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tiff := []byte("II*\x00\x08\x00\x00\x00")
+	tiff = binary.LittleEndian.AppendUint16(tiff, 1)
+	tiff = appendIFDEntry(tiff, 0x0112, 3, 1, uint32(orientation))
+	tiff = binary.LittleEndian.AppendUint32(tiff, 0)
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+
+	header := []byte{0xff, 0xd8, 0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(header[4:], uint16(len(app1)+2))
+	return append(append(header, app1...), src.Bytes()[2:]...)
+}
+
+func TestReadImageOrientation(t *testing.T) {
+	// the upright image has a differently colored quadrant at each corner
+	const w, h = 48, 32
+	quadrants := [2][2]color.NRGBA{
+		{{255, 0, 0, 255}, {0, 255, 0, 255}},
+		{{0, 0, 255, 255}, {255, 255, 255, 255}},
+	}
+	upright := func(x, y int) color.NRGBA {
+		return quadrants[y*2/h][x*2/w]
+	}
+
+	// where each pixel of the stored image comes from in the upright one
+	tests := []struct {
+		orientation int
+		sideways    bool
+		from        func(sx, sy int) (int, int)
+	}{
+		{1, false, func(sx, sy int) (int, int) { return sx, sy }},
+		{2, false, func(sx, sy int) (int, int) { return w - 1 - sx, sy }},
+		{3, false, func(sx, sy int) (int, int) { return w - 1 - sx, h - 1 - sy }},
+		{4, false, func(sx, sy int) (int, int) { return sx, h - 1 - sy }},
+		{5, true, func(sx, sy int) (int, int) { return sy, sx }},
+		{6, true, func(sx, sy int) (int, int) { return w - 1 - sy, sx }},
+		{7, true, func(sx, sy int) (int, int) { return w - 1 - sy, h - 1 - sx }},
+		{8, true, func(sx, sy int) (int, int) { return sy, h - 1 - sx }},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.orientation), func(t *testing.T) {
+			stored := image.NewNRGBA(image.Rect(0, 0, w, h))
+			if tt.sideways {
+				stored = image.NewNRGBA(image.Rect(0, 0, h, w))
+			}
+			for sy := 0; sy < stored.Rect.Dy(); sy++ {
+				for sx := 0; sx < stored.Rect.Dx(); sx++ {
+					stored.SetNRGBA(sx, sy, upright(tt.from(sx, sy)))
+				}
+			}
+
+			path := filepath.Join(t.TempDir(), "photo.jpg")
+			err := os.WriteFile(path, jpegWithOrientation(t, stored, tt.orientation), 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			img, err := ReadImage(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := img.Bounds(), image.Rect(0, 0, w, h); got != want {
+				t.Fatalf("bounds = %v, want %v", got, want)
+			}
+			config, err := ReadImageConfig(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.Width != w || config.Height != h {
+				t.Errorf("ReadImageConfig = %dx%d, want %dx%d", config.Width, config.Height, w, h)
+			}
+
+			// the centers of the quadrants, clear of the JPEG blur at
+			// their edges
+			for _, p := range []image.Point{{12, 8}, {36, 8}, {12, 24}, {36, 24}} {
+				got := color.NRGBAModel.Convert(img.At(p.X, p.Y)).(color.NRGBA)
+				if !closeNRGBA(got, upright(p.X, p.Y), 8) {
+					t.Errorf("pixel %v = %v, want %v", p, got, upright(p.X, p.Y))
+				}
+			}
+		})
+	}
+}
+
+func TestICCProfilePreserved(t *testing.T) {
+	// longer than one APP2 segment holds, so a JPEG splits it
+	profile := make([]byte, maxICCPiece+1000)
+	for i := range profile {
+		profile[i] = byte(i * 13)
+	}
+	img := image.NewGray(image.Rect(0, 0, 16, 16))
+
+	dir := t.TempDir()
+	wm := filepath.Join(dir, "wm.png")
+	err := SaveImage(redSquare(4), wm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pngData, jpgData bytes.Buffer
+	err = encodePNG(&pngData, img, &png.Encoder{}, &Metadata{icc: profile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = encodeJPEG(&jpgData, img, 90, Subsampling420, false, &Metadata{icc: profile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		in, out string
+		data    []byte
+	}{
+		{"in.png", "out.png", pngData.Bytes()},
+		{"in.png", "out.jpg", pngData.Bytes()},
+		{"in.jpg", "out.jpg", jpgData.Bytes()},
+		{"in.jpg", "out.png", jpgData.Bytes()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in+" to "+tt.out, func(t *testing.T) {
+			in, out := filepath.Join(t.TempDir(), tt.in), filepath.Join(t.TempDir(), tt.out)
+			err := os.WriteFile(in, tt.data, 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = AddWatermarkImage(in, wm, out, "center", 0, 0, 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			file, err := os.Open(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+
+			var got []byte
+			if filepath.Ext(out) == ".png" {
+				got, _ = readPNGHeader(file)
+			} else {
+				segments, _ := readJPEGHeader(file)
+				got = (&Metadata{segments: segments}).iccProfile()
+			}
+			if !bytes.Equal(got, profile) {
+				t.Errorf("output holds a profile of %d bytes, want the %d of the input", len(got), len(profile))
+			}
+		})
+	}
+}
+
+// pngChunks returns the data of the chunks of type typ of the PNG in data.
+func pngChunks(data []byte, typ string) [][]byte {
+	var chunks [][]byte
+	for i := len(pngSignature); i+8 <= len(data); {
+		n := int(binary.BigEndian.Uint32(data[i:]))
+		if i+12+n > len(data) {
+			break
+		}
+		if string(data[i+4:i+8]) == typ {
+			chunks = append(chunks, data[i+8:i+8+n])
+		}
+		i += 12 + n
+	}
+	return chunks
+}
+
+func TestSaveImageAuthorCopyright(t *testing.T) {
+	const author, copyright = "Jane Doe", "© 2024 Jane Doe"
+	img := image.NewGray(image.Rect(0, 0, 8, 8))
+
+	for _, name := range []string{"out.png", "out.jpg"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			err := SaveImage(img, path, WithAuthor(author), WithCopyright(copyright))
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// iTXt chunks hold the key, the compression flag and method
+			// and an empty language and translated key before the text,
+			// JPEG comments the key and the text
+			var want, got []string
+			if filepath.Ext(name) == ".png" {
+				want = []string{"Author\x00\x00\x00\x00\x00" + author, "Copyright\x00\x00\x00\x00\x00" + copyright}
+				for _, chunk := range pngChunks(data, "iTXt") {
+					got = append(got, string(chunk))
+				}
+			} else {
+				want = []string{"Author: " + author, "Copyright: " + copyright}
+				segments, _ := readJPEGHeader(bytes.NewReader(data))
+				for _, segment := range segments {
+					if segment.marker == 0xfe {
+						got = append(got, string(segment.data))
+					}
+				}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("text fields = %q, want %q", got, want)
+			}
+
+			// the image still decodes
+			if _, err := ReadImage(path); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
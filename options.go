@@ -0,0 +1,626 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"log"
+)
+
+// options holds the optional settings shared by the watermarking
+// functions.
+type options struct {
+	tile        bool
+	stripRow    bool
+	stripColumn bool
+	gap         int
+	stagger     bool
+	opacity     float64
+	threshold   int
+
+	shadow        bool
+	shadowOffset  int
+	shadowOpacity float64
+
+	format         string
+	overwrite      bool
+	preserveTime   bool
+	quality        int
+	pngCompression png.CompressionLevel
+	subsampling    Subsampling
+	progressive    bool
+
+	resample  Resample
+	rotate    float64
+	scale     float64
+	noResize  bool
+	upscale   bool
+	flip      bool
+	flop      bool
+	tint      color.Color
+	autoColor bool
+
+	colorKey          color.Color
+	colorKeyTolerance uint8
+
+	feather int
+
+	marginX int
+	marginY int
+	center  bool
+
+	workers  int
+	progress func(done, total int)
+
+	maxDim int
+	page   int
+
+	ctx context.Context
+
+	logger *log.Logger
+	stats  *runStats
+	quiet  bool
+
+	prepared *preparedCache
+
+	grayscale     bool
+	brightness    float64
+	contrast      float64
+	linearBlend   bool
+	blendMode     BlendMode
+	onlyBright    bool
+	onlyDark      bool
+	mask          image.Image
+	preserveDepth bool
+
+	dither     bool
+	ditherSeed int64
+
+	crop     bool
+	cropRect image.Rectangle
+
+	compare      bool
+	cornerRadius int
+
+	thumbWidth  int
+	thumbHeight int
+
+	metadata  *Metadata
+	author    string
+	copyright string
+
+	background color.Color
+
+	strokeColor color.Color
+	strokeWidth int
+	dpi         float64
+
+	badgeColor   color.Color
+	badgePadding int
+
+	stamp        bool
+	stampText    string
+	stampOptions StampOptions
+}
+
+// Option configures optional behavior of AddWatermarkImage, SaveImage and
+// ResizeImage.
+type Option func(*options)
+
+// WithTile repeats the watermark over the whole main image, leaving gap
+// pixels between neighbouring tiles.
+func WithTile(gap int) Option {
+	return func(o *options) {
+		o.tile = true
+		o.gap = gap
+	}
+}
+
+// WithStripRow repeats the watermark across the whole width of the main
+// image along the row it is placed on, leaving gap pixels between copies.
+// With WithStripColumn too the strips cross at the placed watermark.
+func WithStripRow(gap int) Option {
+	return func(o *options) {
+		o.stripRow = true
+		o.gap = gap
+	}
+}
+
+// WithStripColumn is WithStripRow down the column the watermark is placed
+// on.
+func WithStripColumn(gap int) Option {
+	return func(o *options) {
+		o.stripColumn = true
+		o.gap = gap
+	}
+}
+
+// WithThreshold drops the pixels of the watermark whose alpha, after
+// WithOpacity, is below threshold out of 255, so soft edges and halos are
+// cut off instead of blended. Zero, the default, keeps every pixel.
+func WithThreshold(threshold int) Option {
+	return func(o *options) {
+		o.threshold = threshold
+	}
+}
+
+// WithStagger shifts every other row of tiles by half a tile, in a brick
+// pattern that is harder to crop around than a grid. It applies to
+// WithTile and to the rows of WithStamp.
+func WithStagger() Option {
+	return func(o *options) {
+		o.stagger = true
+	}
+}
+
+// WithShadow draws a blurred black copy of the watermark offset pixels
+// down and to the right behind it, with its alpha scaled by opacity.
+func WithShadow(offset int, opacity float64) Option {
+	return func(o *options) {
+		o.shadow = true
+		o.shadowOffset = offset
+		o.shadowOpacity = opacity
+	}
+}
+
+// WithOpacity scales the watermark's alpha by opacity, which must be
+// between 0 (invisible) and 1 (unchanged).
+func WithOpacity(opacity float64) Option {
+	return func(o *options) {
+		o.opacity = opacity
+	}
+}
+
+// WithFormat makes SaveImage write the given format, as accepted by
+// WriteImageTo, whatever the extension of the path. ProcessDirectory
+// changes the extension of its output files to match.
+func WithFormat(format string) Option {
+	return func(o *options) {
+		o.format = format
+	}
+}
+
+// WithOverwrite lets SaveImage and the functions saving through it replace
+// an existing output file. Without it they fail with an error wrapping
+// fs.ErrExist rather than truncate the file.
+func WithOverwrite() Option {
+	return func(o *options) {
+		o.overwrite = true
+	}
+}
+
+// WithPreserveTime gives the files written by AddWatermarks, and the
+// functions built on it, the modification time of their main image, which
+// keeps photo libraries in chronological order.
+func WithPreserveTime() Option {
+	return func(o *options) {
+		o.preserveTime = true
+	}
+}
+
+// WithQuality sets the quality, from 1 to 100, used when the output is
+// encoded as JPEG or WebP.
+func WithQuality(quality int) Option {
+	return func(o *options) {
+		o.quality = quality
+	}
+}
+
+// WithPNGCompression sets the zlib compression level used when the output
+// is encoded as PNG.
+func WithPNGCompression(level png.CompressionLevel) Option {
+	return func(o *options) {
+		o.pngCompression = level
+	}
+}
+
+// WithSubsampling selects the chroma subsampling used when the output is
+// encoded as JPEG. The default, Subsampling420, is what image/jpeg writes.
+func WithSubsampling(subsampling Subsampling) Option {
+	return func(o *options) {
+		o.subsampling = subsampling
+	}
+}
+
+// WithProgressive writes JPEG output as a progressive JPEG, which browsers
+// show coarsely before it has been loaded completely. Progressive JPEGs
+// always keep the color at full resolution, whatever WithSubsampling
+// selects. Other output formats give an error.
+func WithProgressive() Option {
+	return func(o *options) {
+		o.progressive = true
+	}
+}
+
+// WithResample selects the sampling used when the watermark is resized.
+func WithResample(resample Resample) Option {
+	return func(o *options) {
+		o.resample = resample
+	}
+}
+
+// WithRotation rotates the watermark clockwise by degrees after it has
+// been resized.
+func WithRotation(degrees float64) Option {
+	return func(o *options) {
+		o.rotate = degrees
+	}
+}
+
+// WithFlip mirrors the watermark top to bottom before it is rotated.
+func WithFlip() Option {
+	return func(o *options) {
+		o.flip = true
+	}
+}
+
+// WithFlop mirrors the watermark left to right before it is rotated.
+func WithFlop() Option {
+	return func(o *options) {
+		o.flop = true
+	}
+}
+
+// WithColorKey makes the pixels of the watermark matching key within
+// tolerance transparent with ApplyColorKey, before it is resized.
+func WithColorKey(key color.Color, tolerance uint8) Option {
+	return func(o *options) {
+		o.colorKey = key
+		o.colorKeyTolerance = tolerance
+	}
+}
+
+// WithFeather fades the watermark out over radius pixels towards its edges
+// with FeatherEdges, after it has been rotated.
+func WithFeather(radius int) Option {
+	return func(o *options) {
+		o.feather = radius
+	}
+}
+
+// WithTint recolors the watermark with TintImage after it has been
+// rotated.
+func WithTint(c color.Color) Option {
+	return func(o *options) {
+		o.tint = c
+	}
+}
+
+// WithAutoColor recolors each watermark black or white depending on the
+// average brightness of the region of the main image it covers, which
+// keeps text and single-color logos legible on light and dark photos
+// alike. It takes precedence over WithTint.
+func WithAutoColor() Option {
+	return func(o *options) {
+		o.autoColor = true
+	}
+}
+
+// WithScale sizes watermarks that have no height or width of their own to
+// scale times the width of the main image, keeping their aspect ratio.
+// Zero, the default, leaves them at their own size.
+func WithScale(scale float64) Option {
+	return func(o *options) {
+		o.scale = scale
+	}
+}
+
+// WithNoResize keeps watermarks at their own size, so no quality is lost
+// to resampling, and makes placing one fail when it is larger than the main
+// image instead of shrinking it to fit.
+func WithNoResize() Option {
+	return func(o *options) {
+		o.noResize = true
+	}
+}
+
+// WithUpscale also enlarges watermarks smaller than their height or width,
+// which are otherwise only ever shrunk to it.
+func WithUpscale() Option {
+	return func(o *options) {
+		o.upscale = true
+	}
+}
+
+// WithMargin insets an anchored watermark from the edges of the main image
+// by marginX pixels horizontally and marginY pixels vertically.
+func WithMargin(marginX, marginY int) Option {
+	return func(o *options) {
+		o.marginX = marginX
+		o.marginY = marginY
+	}
+}
+
+// WithCenter makes the x/y position, or the anchor, give the center of
+// the watermark instead of its top-left corner.
+func WithCenter() Option {
+	return func(o *options) {
+		o.center = true
+	}
+}
+
+// WithWorkers sets how many images ProcessDirectory watermarks at once.
+func WithWorkers(workers int) Option {
+	return func(o *options) {
+		o.workers = workers
+	}
+}
+
+// WithProgress calls progress with the number of files done so far and
+// the total after each file ProcessDirectory watermarks. The calls never
+// overlap, even with several workers.
+func WithProgress(progress func(done, total int)) Option {
+	return func(o *options) {
+		o.progress = progress
+	}
+}
+
+// WithMaxDimension makes the image readers reject images wider or taller
+// than maxDim pixels with ErrTooLarge. The size is read from the header
+// first, so a small file claiming a huge size, a decompression bomb, is
+// refused before its pixels are allocated. Zero, the default, allows any
+// size.
+func WithMaxDimension(maxDim int) Option {
+	return func(o *options) {
+		o.maxDim = maxDim
+	}
+}
+
+// WithPage makes the image readers decode page n, counted from 0, of a
+// multi-page TIFF such as a scanned document instead of the first one. It
+// applies to every TIFF read with it and fails for a TIFF with fewer pages.
+// Other formats are not affected.
+func WithPage(n int) Option {
+	return func(o *options) {
+		o.page = n
+	}
+}
+
+// WithLogger reports on logger which images are read and written, their
+// dimensions, how each watermark is resized and where it is placed.
+func WithLogger(logger *log.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithQuiet stops the warnings printed to stderr, such as for the files
+// ProcessDirectory skips.
+func WithQuiet() Option {
+	return func(o *options) {
+		o.quiet = true
+	}
+}
+
+// WithContext stops the work once ctx is cancelled or its deadline passes,
+// returning ctx.Err(). It is checked while the watermarks are blended and
+// before each file or GIF frame; files ProcessDirectory has not started
+// are skipped.
+func WithContext(ctx context.Context) Option {
+	return func(o *options) {
+		o.ctx = ctx
+	}
+}
+
+// WithGrayscale converts the main image to grayscale before the
+// watermarks, which keep their colors, are blended onto it.
+func WithGrayscale() Option {
+	return func(o *options) {
+		o.grayscale = true
+	}
+}
+
+// WithLinearBlend blends the watermarks in linear light, as BlendLinear
+// does, instead of mixing their sRGB values.
+func WithLinearBlend() Option {
+	return func(o *options) {
+		o.linearBlend = true
+	}
+}
+
+// WithOnlyBright scales the alpha of the watermarks by the luminance of
+// the main image under them, so they show on its light areas and fade out
+// on its dark ones.
+func WithOnlyBright() Option {
+	return func(o *options) {
+		o.onlyBright = true
+	}
+}
+
+// WithOnlyDark is WithOnlyBright the other way around, showing the
+// watermarks on the dark areas of the main image only.
+func WithOnlyDark() Option {
+	return func(o *options) {
+		o.onlyDark = true
+	}
+}
+
+// WithMask multiplies the alpha of the watermarks by the gray level of
+// mask, stretched over the main image, so they only show where the mask is
+// white and fade out where it is gray. Tiled watermarks are restricted to
+// the shape of the mask too.
+func WithMask(mask image.Image) Option {
+	return func(o *options) {
+		o.mask = mask
+	}
+}
+
+// WithBlendMode combines the colors of the watermarks with the main image
+// with mode, as BlendWithMode does. The default is SourceOver.
+func WithBlendMode(mode BlendMode) Option {
+	return func(o *options) {
+		o.blendMode = mode
+	}
+}
+
+// WithBrightnessContrast adjusts the main image with
+// AdjustBrightnessContrast before the watermarks are blended onto it.
+func WithBrightnessContrast(brightness, contrast float64) Option {
+	return func(o *options) {
+		o.brightness = brightness
+		o.contrast = contrast
+	}
+}
+
+// WithPreserveDepth watermarks 16-bit images, such as 16-bit PNGs, in 16
+// bits per channel and writes them out that way, rather than reducing them
+// to 8 bits first. It is slower since the pixels are blended one by one.
+func WithPreserveDepth() Option {
+	return func(o *options) {
+		o.preserveDepth = true
+	}
+}
+
+// WithDither lays a faint ordered dither, chosen by seed, over the part of
+// the main image each watermark covers, so the watermark cannot be cloned
+// away against a smooth background without leaving a trace.
+func WithDither(seed int64) Option {
+	return func(o *options) {
+		o.dither = true
+		o.ditherSeed = seed
+	}
+}
+
+// WithCrop watermarks only the region rect of the main image, given in
+// the main image's coordinates, and saves that region as the output.
+func WithCrop(rect image.Rectangle) Option {
+	return func(o *options) {
+		o.crop = true
+		o.cropRect = rect
+	}
+}
+
+// WithStroke outlines rendered text with col, width pixels wide.
+func WithStroke(col color.Color, width int) Option {
+	return func(o *options) {
+		o.strokeColor = col
+		o.strokeWidth = width
+	}
+}
+
+// WithDPI renders text at dpi, so font sizes in points come out at the
+// right size in print. The default is 72, where a point is a pixel.
+func WithDPI(dpi float64) Option {
+	return func(o *options) {
+		o.dpi = dpi
+	}
+}
+
+// WithBadge draws rendered text on a box of col, usually semi-transparent,
+// reaching padding pixels past the text on each side with corners rounded
+// by the padding.
+func WithBadge(col color.Color, padding int) Option {
+	return func(o *options) {
+		o.badgeColor = col
+		o.badgePadding = padding
+	}
+}
+
+// WithStamp repeats text diagonally across the main image, as StampImage
+// does, before the watermarks are blended on top.
+func WithStamp(text string, so StampOptions) Option {
+	return func(o *options) {
+		o.stamp = true
+		o.stampText = text
+		o.stampOptions = so
+	}
+}
+
+// WithBackground flattens the output onto bg before it is encoded in a
+// format without an alpha channel, which is JPEG. Other formats keep their
+// transparency.
+func WithBackground(bg color.Color) Option {
+	return func(o *options) {
+		o.background = bg
+	}
+}
+
+// WithRoundCorners rounds off the corners of the output with radius using
+// RoundCorners. They are transparent, so in JPEG output they take the
+// WithBackground color.
+func WithRoundCorners(radius int) Option {
+	return func(o *options) {
+		o.cornerRadius = radius
+	}
+}
+
+// WithThumbnail resizes the watermarked output to width x height, to
+// watermark and make a thumbnail in one step. A zero width or height is
+// derived from the other one so the output keeps its aspect ratio. The
+// sampling is the one set with WithResample.
+func WithThumbnail(width, height int) Option {
+	return func(o *options) {
+		o.thumbWidth = width
+		o.thumbHeight = height
+	}
+}
+
+// WithCompare makes the output the original image and the watermarked one
+// side by side, separated by a thin line, to review the watermark. The
+// original is cropped like the output but not otherwise adjusted. An
+// animated GIF is compared on its first frame only.
+func WithCompare() Option {
+	return func(o *options) {
+		o.compare = true
+	}
+}
+
+// WithAuthor writes author into PNG and JPEG output, as an iTXt chunk
+// with the Author keyword or a comment starting with "Author: ".
+func WithAuthor(author string) Option {
+	return func(o *options) {
+		o.author = author
+	}
+}
+
+// WithCopyright is WithAuthor for a copyright notice, written with the
+// Copyright keyword or as a comment starting with "Copyright: ".
+func WithCopyright(copyright string) Option {
+	return func(o *options) {
+		o.copyright = copyright
+	}
+}
+
+// WithMetadata writes the metadata read by ReadImageWithMetadata into JPEG
+// output, and its ICC profile into PNG output. It has no effect on other
+// formats or when meta is nil.
+func WithMetadata(meta *Metadata) Option {
+	return func(o *options) {
+		o.metadata = meta
+	}
+}
+
+// withMetadata returns opts followed by WithMetadata(meta), without
+// modifying the caller's slice.
+func withMetadata(opts []Option, meta *Metadata) []Option {
+	if meta == nil {
+		return opts
+	}
+	return append(opts[:len(opts):len(opts)], WithMetadata(meta))
+}
+
+// singlePlacement reports whether o blends the watermark once onto the main
+// image as it was read, which is all CompositeImages does.
+func (o *options) singlePlacement() bool {
+	return !o.tile && !o.stripRow && !o.stripColumn && !o.shadow && !o.autoColor && !o.dither && !o.stamp &&
+		!o.crop && !o.grayscale && o.brightness == 0 && o.contrast == 0 && !o.preserveDepth &&
+		!o.compare && o.cornerRadius == 0 && o.thumbWidth == 0 && o.thumbHeight == 0
+}
+
+// logf reports on the WithLogger logger, if any.
+func (o *options) logf(format string, args ...interface{}) {
+	if o.logger != nil {
+		o.logger.Printf(format, args...)
+	}
+}
+
+// newOptions applies opts on top of the defaults.
+func newOptions(opts []Option) *options {
+	o := &options{opacity: 1, quality: jpeg.DefaultQuality, workers: 1, ctx: context.Background()}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
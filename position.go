@@ -0,0 +1,161 @@
+package main
+
+import "image"
+
+// anchorQuiet is the -pos value that places the watermark with
+// findQuietRegion rather than at a fixed anchor.
+const anchorQuiet = "quiet"
+
+// anchors maps each supported -pos value to where the watermark sits on
+// the main image, in halves of the space left over on each axis: 0 is
+// flush with the top/left edge, 1 is centered and 2 is flush with the
+// bottom/right edge.
+var anchors = map[string]image.Point{
+	"top-left":     {0, 0},
+	"top":          {1, 0},
+	"top-right":    {2, 0},
+	"left":         {0, 1},
+	"center":       {1, 1},
+	"right":        {2, 1},
+	"bottom-left":  {0, 2},
+	"bottom":       {1, 2},
+	"bottom-right": {2, 2},
+}
+
+// resolveAnchor translates an anchor name into the top-left position of
+// the watermark on the main image. offX and offY are added to the result
+// so -x and -y can nudge an anchored watermark. An empty or unknown anchor
+// behaves like top-left, which leaves the offsets as absolute coordinates.
+func resolveAnchor(mainW, mainH, wmW, wmH int, anchor string, offX, offY int) (int, int) {
+	at := anchors[anchor]
+
+	x := (mainW-wmW)*at.X/2 + offX
+	y := (mainH-wmH)*at.Y/2 + offY
+
+	return x, y
+}
+
+// resolveCenter is resolveAnchor for WithCenter, where the anchor point
+// plus the offsets gives the center of the watermark rather than the
+// position of its edges. It returns the top-left position, which for
+// anchors on the edges of the main image lies half a watermark outside it.
+func resolveCenter(mainW, mainH, wmW, wmH int, anchor string, offX, offY int) (int, int) {
+	at := anchors[anchor]
+
+	x := mainW*at.X/2 + offX - wmW/2
+	y := mainH*at.Y/2 + offY - wmH/2
+
+	return x, y
+}
+
+// WatermarkRect returns where a wmW x wmH watermark placed at anchor with
+// the offsets x and y lands on a main image of mainBounds, clipped to it,
+// as AddWatermark places it without blending anything. The rectangle is
+// empty when the watermark would be rejected as out of bounds.
+func WatermarkRect(mainBounds image.Rectangle, wmW, wmH, x, y int, anchor string) image.Rectangle {
+	x, y, err := placeWatermark(mainBounds.Dx(), mainBounds.Dy(), wmW, wmH, anchor, x, y, newOptions(nil))
+	if err != nil {
+		return image.Rectangle{}
+	}
+
+	r := image.Rect(x, y, x+wmW, y+wmH).Add(mainBounds.Min)
+	return r.Intersect(mainBounds)
+}
+
+// insetAnchor moves an anchored position marginX and marginY pixels away
+// from the edges the anchor is flush against, so bottom-right moves up and
+// to the left. Centered axes and unanchored positions are left alone.
+func insetAnchor(anchor string, x, y, marginX, marginY int) (int, int) {
+	at, ok := anchors[anchor]
+	if !ok {
+		return x, y
+	}
+
+	switch at.X {
+	case 0:
+		x += marginX
+	case 2:
+		x -= marginX
+	}
+
+	switch at.Y {
+	case 0:
+		y += marginY
+	case 2:
+		y -= marginY
+	}
+
+	return x, y
+}
+
+// findQuietRegion returns the top-left position, relative to the bounds of
+// img, of the wmW x wmH region of img with the least variance in luma, so a
+// watermark placed there covers as little detail as possible. Regions are
+// tried every half watermark across and down, plus flush with the right
+// and bottom edges, and the first of equally quiet regions wins. A
+// watermark larger than img is compared over the whole of that axis.
+func findQuietRegion(img image.Image, wmW, wmH int) image.Point {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if wmW > w {
+		wmW = w
+	}
+	if wmH > h {
+		wmH = h
+	}
+	if wmW <= 0 || wmH <= 0 {
+		return image.Point{}
+	}
+
+	// summed-area tables of the luma and its square, one row and column
+	// larger than img so every region is four lookups
+	stride := w + 1
+	sum := make([]int64, stride*(h+1))
+	sumSq := make([]int64, stride*(h+1))
+	for y := 0; y < h; y++ {
+		var rowSum, rowSumSq int64
+		for x := 0; x < w; x++ {
+			r, g, bl, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			lum := int64((299*r + 587*g + 114*bl) / 1000 >> 8)
+			rowSum += lum
+			rowSumSq += lum * lum
+			i := (y+1)*stride + x + 1
+			sum[i] = sum[i-stride] + rowSum
+			sumSq[i] = sumSq[i-stride] + rowSumSq
+		}
+	}
+
+	area := func(table []int64, x, y int) int64 {
+		x0, y0 := y*stride+x, (y+wmH)*stride+x
+		return table[y0+wmW] - table[y0] - table[x0+wmW] + table[x0]
+	}
+
+	n := float64(wmW * wmH)
+	best, bestVariance := image.Point{}, -1.0
+	for _, y := range quietSteps(h, wmH) {
+		for _, x := range quietSteps(w, wmW) {
+			mean := float64(area(sum, x, y)) / n
+			variance := float64(area(sumSq, x, y))/n - mean*mean
+			if bestVariance < 0 || variance < bestVariance {
+				best, bestVariance = image.Pt(x, y), variance
+			}
+		}
+	}
+
+	return best
+}
+
+// quietSteps returns the positions findQuietRegion tries for a window of
+// size win along an axis of length size.
+func quietSteps(size, win int) []int {
+	step := win / 2
+	if step < 1 {
+		step = 1
+	}
+
+	var steps []int
+	for p := 0; p < size-win; p += step {
+		steps = append(steps, p)
+	}
+	return append(steps, size-win)
+}
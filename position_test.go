@@ -0,0 +1,269 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveAnchor(t *testing.T) {
+	// a 100x50 watermark on a 1000x500 main image
+	tests := []struct {
+		anchor string
+		want   image.Point
+	}{
+		{"top-left", image.Pt(0, 0)},
+		{"top", image.Pt(450, 0)},
+		{"top-right", image.Pt(900, 0)},
+		{"left", image.Pt(0, 225)},
+		{"center", image.Pt(450, 225)},
+		{"right", image.Pt(900, 225)},
+		{"bottom-left", image.Pt(0, 450)},
+		{"bottom", image.Pt(450, 450)},
+		{"bottom-right", image.Pt(900, 450)},
+		{"", image.Pt(0, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.anchor, func(t *testing.T) {
+			x, y := resolveAnchor(1000, 500, 100, 50, tt.anchor, 0, 0)
+			if got := image.Pt(x, y); got != tt.want {
+				t.Errorf("resolveAnchor(%q) = %v, want %v", tt.anchor, got, tt.want)
+			}
+
+			// the offsets move the watermark from the anchor
+			x, y = resolveAnchor(1000, 500, 100, 50, tt.anchor, 10, -20)
+			if got, want := image.Pt(x, y), tt.want.Add(image.Pt(10, -20)); got != want {
+				t.Errorf("resolveAnchor(%q) with offsets = %v, want %v", tt.anchor, got, want)
+			}
+		})
+	}
+}
+
+// busyImage returns a paletted w x h image that is a black and white
+// checkerboard where busy reports true and flat gray elsewhere.
+func busyImage(w, h int, busy func(x, y int) bool) *image.Paletted {
+	palette := color.Palette{color.Black, color.White, color.Gray{128}, color.NRGBA{255, 0, 0, 255}}
+	img := image.NewPaletted(image.Rect(0, 0, w, h), palette)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			switch {
+			case !busy(x, y):
+				img.SetColorIndex(x, y, 2)
+			case (x+y)%2 == 0:
+				img.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+	return img
+}
+
+// redBounds returns the smallest rectangle holding the pure red pixels of
+// img.
+func redBounds(img image.Image) image.Rectangle {
+	var r image.Rectangle
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if color.NRGBAModel.Convert(img.At(x, y)) == (color.NRGBA{255, 0, 0, 255}) {
+				r = r.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	return r
+}
+
+// redSquare returns an opaque red size x size watermark.
+func redSquare(size int) *image.NRGBA {
+	red := image.NewNRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(red, red.Rect, image.NewUniform(color.NRGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+	return red
+}
+
+func TestQuietAnchor(t *testing.T) {
+	red := redSquare(10)
+
+	tests := []struct {
+		name string
+		busy func(x, y int) bool
+		want func(r image.Rectangle) bool
+	}{
+		{"busy left", func(x, y int) bool { return x < 20 }, func(r image.Rectangle) bool { return r.Min.X >= 20 }},
+		{"busy right", func(x, y int) bool { return x >= 20 }, func(r image.Rectangle) bool { return r.Max.X <= 20 }},
+		{"busy top", func(x, y int) bool { return y < 10 }, func(r image.Rectangle) bool { return r.Min.Y >= 10 }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			main := busyImage(40, 20, tt.busy)
+			out, err := WatermarkImage(main, []WatermarkSpec{{Image: red, Anchor: anchorQuiet}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := redBounds(out)
+			if got.Dx() != 10 || got.Dy() != 10 || !tt.want(got) {
+				t.Errorf("watermark placed at %v", got)
+			}
+		})
+	}
+}
+
+func TestQuietAnchorGIF(t *testing.T) {
+	// the busy side changes between the frames, the watermark must not
+	anim := &gif.GIF{
+		Image: []*image.Paletted{
+			busyImage(40, 20, func(x, y int) bool { return x < 20 }),
+			busyImage(40, 20, func(x, y int) bool { return x >= 20 }),
+		},
+		Delay: []int{10, 10},
+	}
+	in := writeTestGIF(t, anim)
+	out := filepath.Join(t.TempDir(), "out.gif")
+
+	red := redSquare(10)
+	err := AddWatermarkGIF(in, red, out, anchorQuiet, 0, 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	watermarked := readTestGIF(t, out)
+
+	first := redBounds(watermarked.Image[0])
+	if first.Min.X < 20 || first.Dx() != 10 {
+		t.Fatalf("watermark on the first frame at %v, want on its flat right half", first)
+	}
+	for i, frame := range watermarked.Image[1:] {
+		if got := redBounds(frame); got != first {
+			t.Errorf("watermark on frame %d at %v, want %v as on the first", i+1, got, first)
+		}
+	}
+}
+
+func TestMarginCorners(t *testing.T) {
+	red := redSquare(10)
+
+	// a 10x10 watermark on a 40x20 image, inset by 5 and 3 pixels
+	tests := []struct {
+		anchor string
+		want   image.Rectangle
+	}{
+		{"top-left", image.Rect(5, 3, 15, 13)},
+		{"top-right", image.Rect(25, 3, 35, 13)},
+		{"bottom-left", image.Rect(5, 7, 15, 17)},
+		{"bottom-right", image.Rect(25, 7, 35, 17)},
+		{"top", image.Rect(15, 3, 25, 13)},
+		{"center", image.Rect(15, 5, 25, 15)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.anchor, func(t *testing.T) {
+			main := busyImage(40, 20, func(x, y int) bool { return false })
+			out, err := WatermarkImage(main, []WatermarkSpec{{Image: red, Anchor: tt.anchor}}, WithMargin(5, 3))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := redBounds(out); got != tt.want {
+				t.Errorf("watermark placed at %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPercentPosition(t *testing.T) {
+	tests := []struct {
+		name string
+		main image.Rectangle
+		spec WatermarkSpec
+		want image.Point
+	}{
+		{"center of 200x100", image.Rect(0, 0, 200, 100), WatermarkSpec{XPercent: 50, YPercent: 50}, image.Pt(100, 50)},
+		{"center of 60x300", image.Rect(0, 0, 60, 300), WatermarkSpec{XPercent: 50, YPercent: 50}, image.Pt(30, 150)},
+		{"origin", image.Rect(0, 0, 200, 100), WatermarkSpec{}, image.Pt(0, 0)},
+		{"quarters", image.Rect(0, 0, 200, 100), WatermarkSpec{XPercent: 25, YPercent: 75}, image.Pt(50, 75)},
+		{"with an offset", image.Rect(0, 0, 200, 100), WatermarkSpec{X: -5, Y: 3, XPercent: 50, YPercent: 50}, image.Pt(95, 53)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.spec.Image = redSquare(10)
+			out, err := WatermarkImage(image.NewGray(tt.main), []WatermarkSpec{tt.spec})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := redBounds(out); got.Min != tt.want {
+				t.Errorf("watermark starts at %v, want %v", got.Min, tt.want)
+			}
+		})
+	}
+
+	_, err := WatermarkImage(image.NewGray(image.Rect(0, 0, 10, 10)), []WatermarkSpec{{Image: redSquare(2), XPercent: 101}})
+	if err == nil {
+		t.Error("a percentage over 100 was accepted")
+	}
+}
+
+func TestCenterPosition(t *testing.T) {
+	// a 20x20 watermark on a 100x100 image
+	tests := []struct {
+		name string
+		spec WatermarkSpec
+		want image.Rectangle
+	}{
+		{"position", WatermarkSpec{X: 50, Y: 50}, image.Rect(40, 40, 60, 60)},
+		{"center anchor", WatermarkSpec{Anchor: "center"}, image.Rect(40, 40, 60, 60)},
+		{"anchor with offsets", WatermarkSpec{Anchor: "center", X: -20, Y: 10}, image.Rect(20, 50, 40, 70)},
+		{"top-left corner", WatermarkSpec{Anchor: "top-left"}, image.Rect(0, 0, 10, 10)},
+		{"bottom-right corner", WatermarkSpec{Anchor: "bottom-right"}, image.Rect(90, 90, 100, 100)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.spec.Image = redSquare(20)
+			out, err := WatermarkImage(image.NewGray(image.Rect(0, 0, 100, 100)), []WatermarkSpec{tt.spec}, WithCenter())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := redBounds(out); got != tt.want {
+				t.Errorf("watermark covers %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWatermarkRect(t *testing.T) {
+	// a 20x10 watermark on a 100x50 main image whose bounds start at
+	// (10, 5), as a sub-image's do
+	mainBounds := image.Rect(10, 5, 110, 55)
+
+	tests := []struct {
+		name   string
+		anchor string
+		x, y   int
+		want   image.Rectangle
+	}{
+		{"position", "", 30, 20, image.Rect(40, 25, 60, 35)},
+		{"bottom-right", "bottom-right", 0, 0, image.Rect(90, 45, 110, 55)},
+		{"center", "center", 0, 0, image.Rect(50, 25, 70, 35)},
+		{"anchor with offsets", "top-right", -5, 5, image.Rect(85, 10, 105, 20)},
+		{"clipped at the right", "", 90, 0, image.Rect(100, 5, 110, 15)},
+		{"clipped at the top-left", "", -15, -5, image.Rect(10, 5, 15, 10)},
+		{"clipped past the bottom-right anchor", "bottom-right", 10, 5, image.Rect(100, 50, 110, 55)},
+		{"off the right", "", 100, 0, image.Rectangle{}},
+		{"off the top", "", 0, -10, image.Rectangle{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := WatermarkRect(mainBounds, 20, 10, tt.x, tt.y, tt.anchor); got != tt.want {
+				t.Errorf("WatermarkRect(%d, %d, %q) = %v, want %v", tt.x, tt.y, tt.anchor, got, tt.want)
+			}
+		})
+	}
+}
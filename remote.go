@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// fetchTimeout bounds the whole download of a remote image.
+	fetchTimeout = 30 * time.Second
+	// maxFetchSize is the largest remote image that is downloaded.
+	maxFetchSize = 50 << 20
+)
+
+var httpClient = &http.Client{Timeout: fetchTimeout}
+
+// isURL reports whether path is an http or https URL rather than a file.
+func isURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// readImageURL downloads and decodes the image at rawURL. The format comes
+// from the image/* Content-Type of the response, or from the extension of
+// the URL path when the server does not send one, and from the content
+// when neither names a format that can be read.
+func readImageURL(rawURL string, o *options) (image.Image, *Metadata, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := httpClient.Get(u.String())
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("%s: %s", rawURL, resp.Status)
+	}
+
+	if resp.ContentLength > maxFetchSize {
+		return nil, nil, fmt.Errorf("%s: image is larger than %d bytes", rawURL, maxFetchSize)
+	}
+
+	format := strings.TrimPrefix(path.Ext(u.Path), ".")
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil && strings.HasPrefix(mediaType, "image/") {
+		format = strings.TrimSuffix(strings.TrimPrefix(mediaType, "image/"), "+xml")
+	}
+
+	// read one byte past the limit to tell a body of exactly the
+	// maximum size from a larger one
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFetchSize+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(data) > maxFetchSize {
+		return nil, nil, fmt.Errorf("%s: image is larger than %d bytes", rawURL, maxFetchSize)
+	}
+
+	if !isReadableFormat(format) {
+		header := data
+		if len(header) > sniffLen {
+			header = header[:sniffLen]
+		}
+		if sniffed := sniffFormat(header); sniffed != "" {
+			format = sniffed
+		}
+	}
+	if format == "" {
+		return nil, nil, fmt.Errorf("%s: %w, has to be %s", rawURL, ErrUnsupportedFormat, supportedFormats)
+	}
+
+	img, meta, err := readImageFrom(bytes.NewReader(data), format, o)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", rawURL, err)
+	}
+
+	return img, meta, nil
+}
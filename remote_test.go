@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestReadImageURL(t *testing.T) {
+	var data bytes.Buffer
+	err := png.Encode(&data, image.NewGray(image.Rect(0, 0, 6, 4)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/logo.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(data.Bytes())
+	})
+	mux.HandleFunc("/logo", func(w http.ResponseWriter, r *http.Request) {
+		// neither the type nor the path names the format
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(data.Bytes())
+	})
+	mux.HandleFunc("/huge.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(maxFetchSize+1))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tests := []struct {
+		path    string
+		wantErr bool
+	}{
+		{"/logo.png", false},
+		{"/logo", false},
+		{"/missing.png", true},
+		{"/huge.png", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			img, err := ReadImage(server.URL + tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadImage error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && img.Bounds() != image.Rect(0, 0, 6, 4) {
+				t.Errorf("bounds = %v, want %v", img.Bounds(), image.Rect(0, 0, 6, 4))
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// RotateImage rotates img clockwise by degrees around its center. The
+// bounds grow to fit the rotated corners and the uncovered area is
+// transparent. Multiples of 90 degrees are exact pixel moves, other angles
+// are sampled bilinearly.
+func RotateImage(img image.Image, degrees float64) (image.Image, error) {
+	if img == nil {
+		return nil, ErrNilImage
+	}
+
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	degrees = math.Mod(degrees, 360)
+	if degrees < 0 {
+		degrees += 360
+	}
+
+	if math.Mod(degrees, 90) == 0 {
+		return rotateQuarter(img, int(degrees/90)), nil
+	}
+
+	sin, cos := math.Sincos(degrees * math.Pi / 180)
+	newW, newH := rotatedSize(w, h, degrees)
+
+	newImage := image.NewNRGBA(image.Rect(0, 0, newW, newH))
+	cx, cy := float64(w)/2, float64(h)/2
+	ncx, ncy := float64(newW)/2, float64(newH)/2
+	for i := 0; i < newW; i++ {
+		for j := 0; j < newH; j++ {
+			// map the destination pixel center back onto the source
+			dx := float64(i) + 0.5 - ncx
+			dy := float64(j) + 0.5 - ncy
+			sx := cos*dx + sin*dy + cx - 0.5
+			sy := -sin*dx + cos*dy + cy - 0.5
+			if sx < -0.5 || sy < -0.5 || sx >= float64(w)-0.5 || sy >= float64(h)-0.5 {
+				continue
+			}
+
+			colorAt := sampleBilinear(img, sx, sy)
+			newImage.SetNRGBA(i, j, color.NRGBAModel.Convert(colorAt).(color.NRGBA))
+		}
+	}
+
+	return newImage, nil
+}
+
+// rotatedSize returns the bounds RotateImage gives a w x h image rotated
+// by degrees.
+func rotatedSize(w, h int, degrees float64) (int, int) {
+	degrees = math.Mod(degrees, 360)
+	if degrees < 0 {
+		degrees += 360
+	}
+
+	if math.Mod(degrees, 180) == 0 {
+		return w, h
+	}
+	if math.Mod(degrees, 90) == 0 {
+		return h, w
+	}
+
+	sin, cos := math.Sincos(degrees * math.Pi / 180)
+	newW := int(math.Ceil(math.Abs(float64(w)*cos) + math.Abs(float64(h)*sin)))
+	newH := int(math.Ceil(math.Abs(float64(w)*sin) + math.Abs(float64(h)*cos)))
+
+	return newW, newH
+}
+
+// rotateQuarter rotates img clockwise by turns quarter turns.
+func rotateQuarter(img image.Image, turns int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	newW, newH := w, h
+	if turns%2 == 1 {
+		newW, newH = h, w
+	}
+
+	newImage := image.NewNRGBA(image.Rect(0, 0, newW, newH))
+	for i := 0; i < newW; i++ {
+		for j := 0; j < newH; j++ {
+			var atX, atY int
+			switch turns {
+			case 1:
+				atX, atY = j, h-1-i
+			case 2:
+				atX, atY = w-1-i, h-1-j
+			case 3:
+				atX, atY = w-1-j, i
+			default:
+				atX, atY = i, j
+			}
+			newImage.Set(i, j, img.At(bounds.Min.X+atX, bounds.Min.Y+atY))
+		}
+	}
+
+	return newImage
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestRotateImageQuarters(t *testing.T) {
+	// a 3x2 image with a different color at every pixel
+	src := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{uint8(x * 80), uint8(y * 200), 50, 255})
+		}
+	}
+
+	tests := []struct {
+		degrees float64
+		size    image.Point
+		// where the source pixel at (x, y) goes
+		moved func(x, y int) image.Point
+	}{
+		{90, image.Pt(2, 3), func(x, y int) image.Point { return image.Pt(1-y, x) }},
+		{180, image.Pt(3, 2), func(x, y int) image.Point { return image.Pt(2-x, 1-y) }},
+		{270, image.Pt(2, 3), func(x, y int) image.Point { return image.Pt(y, 2-x) }},
+		{-90, image.Pt(2, 3), func(x, y int) image.Point { return image.Pt(y, 2-x) }},
+		{360, image.Pt(3, 2), func(x, y int) image.Point { return image.Pt(x, y) }},
+	}
+
+	for _, tt := range tests {
+		rotated, err := RotateImage(src, tt.degrees)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := rotated.Bounds().Size(); got != tt.size {
+			t.Errorf("RotateImage(%v) size = %v, want %v", tt.degrees, got, tt.size)
+			continue
+		}
+
+		for y := 0; y < 2; y++ {
+			for x := 0; x < 3; x++ {
+				p := tt.moved(x, y)
+				if got, want := rotated.At(p.X, p.Y), src.NRGBAAt(x, y); got != want {
+					t.Errorf("RotateImage(%v) at %v = %v, want %v from (%d, %d)", tt.degrees, p, got, want, x, y)
+				}
+			}
+		}
+	}
+}
+
+func TestRotateImage45(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	draw.Draw(src, src.Rect, image.NewUniform(color.NRGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+
+	rotated, err := RotateImage(src, 45)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the diagonal of the square, 10 times the square root of 2, rounded up
+	if got, want := rotated.Bounds(), image.Rect(0, 0, 15, 15); got != want {
+		t.Fatalf("bounds = %v, want %v", got, want)
+	}
+
+	for _, corner := range []image.Point{{0, 0}, {14, 0}, {0, 14}, {14, 14}} {
+		if _, _, _, a := rotated.At(corner.X, corner.Y).RGBA(); a != 0 {
+			t.Errorf("corner %v has alpha %d, want transparent", corner, a)
+		}
+	}
+	// the tips of the rotated square reach the middle of each side
+	for _, p := range []image.Point{{7, 7}, {7, 0}, {0, 7}, {14, 7}, {7, 14}} {
+		if got := color.NRGBAModel.Convert(rotated.At(p.X, p.Y)).(color.NRGBA); got.R != 255 || got.A == 0 {
+			t.Errorf("pixel %v = %v, want red", p, got)
+		}
+	}
+}
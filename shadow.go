@@ -0,0 +1,65 @@
+package main
+
+import "image"
+
+// shadowRadius is the box blur radius in pixels that softens the edges of
+// watermark shadows.
+const shadowRadius = 3
+
+// shadowLayer returns a blurred black copy of the alpha mask of
+// waterMarkImg with its alpha scaled by opacity. The blur spreads the
+// shadow shadowRadius pixels past the watermark on every side, so its
+// bounds start at (-shadowRadius, -shadowRadius) relative to the watermark.
+func shadowLayer(waterMarkImg image.Image, opacity float64) *image.NRGBA {
+	bounds := waterMarkImg.Bounds()
+	w, h := bounds.Dx()+2*shadowRadius, bounds.Dy()+2*shadowRadius
+
+	alpha := make([]uint32, w*h)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			_, _, _, a := waterMarkImg.At(x, y).RGBA()
+			alpha[(y-bounds.Min.Y+shadowRadius)*w+x-bounds.Min.X+shadowRadius] = a
+		}
+	}
+
+	// a box blur is separable, so blur the rows and then the columns
+	alpha = boxBlur(alpha, w, h, 1, w, shadowRadius)
+	alpha = boxBlur(alpha, h, w, w, 1, shadowRadius)
+
+	shadow := image.NewNRGBA(image.Rect(-shadowRadius, -shadowRadius, bounds.Dx()+shadowRadius, bounds.Dy()+shadowRadius))
+	for i, a := range alpha {
+		// the color channels stay zero, which is black
+		shadow.Pix[i*4+3] = uint8(float64(a>>8) * opacity)
+	}
+
+	return shadow
+}
+
+// boxBlur averages every value of the lines of src over radius neighbours
+// on each side, counting values past the ends of a line as zero. The n
+// values of a line are step apart and consecutive lines start stride
+// apart.
+func boxBlur(src []uint32, n, lines, step, stride, radius int) []uint32 {
+	dst := make([]uint32, len(src))
+	size := uint32(2*radius + 1)
+
+	for line := 0; line < lines; line++ {
+		start := line * stride
+		var sum uint32
+		for i := 0; i < radius && i < n; i++ {
+			sum += src[start+i*step]
+		}
+
+		for i := 0; i < n; i++ {
+			if in := i + radius; in < n {
+				sum += src[start+in*step]
+			}
+			if out := i - radius - 1; out >= 0 {
+				sum -= src[start+out*step]
+			}
+			dst[start+i*step] = sum / size
+		}
+	}
+
+	return dst
+}
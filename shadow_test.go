@@ -0,0 +1,44 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestShadowOffset(t *testing.T) {
+	white := color.NRGBA{255, 255, 255, 255}
+	main := image.NewNRGBA(image.Rect(0, 0, 40, 40))
+	draw.Draw(main, main.Rect, image.NewUniform(white), image.Point{}, draw.Src)
+	red := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	draw.Draw(red, red.Rect, image.NewUniform(color.NRGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+
+	// the watermark covers [10, 20) and its shadow [14, 24) on both axes,
+	// blurred by shadowRadius past that
+	out, err := WatermarkImage(main, []WatermarkSpec{{Image: red, X: 10, Y: 10}}, WithShadow(4, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gray := func(x, y int) uint8 {
+		return color.NRGBAModel.Convert(out.At(x, y)).(color.NRGBA).G
+	}
+
+	if got := color.NRGBAModel.Convert(out.At(15, 15)); got != (color.NRGBA{255, 0, 0, 255}) {
+		t.Errorf("watermark pixel = %v, want red over the shadow", got)
+	}
+	for _, p := range []image.Point{{21, 18}, {18, 21}, {21, 21}} {
+		if g := gray(p.X, p.Y); g > 128 {
+			t.Errorf("pixel %v below and right of the watermark = %d, want dark shadow", p, g)
+		}
+	}
+	if deep, soft := gray(21, 21), gray(25, 25); deep >= soft || soft == 255 {
+		t.Errorf("shadow is %d inside and %d at its blurred edge, want it to fade out", deep, soft)
+	}
+	for _, p := range []image.Point{{9, 9}, {12, 8}, {8, 12}, {28, 28}} {
+		if got := color.NRGBAModel.Convert(out.At(p.X, p.Y)); got != white {
+			t.Errorf("pixel %v clear of the shadow = %v, want %v", p, got, white)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// sniffLen is how many bytes at the start of a file are looked at to tell
+// its format.
+const sniffLen = 512
+
+// magics maps the bytes every file of a format starts with to the format,
+// with '?' matching any byte.
+var magics = []struct {
+	prefix string
+	format string
+}{
+	{"\x89PNG\r\n\x1a\n", "png"},
+	{"\xff\xd8\xff", "jpeg"},
+	{"GIF87a", "gif"},
+	{"GIF89a", "gif"},
+	{"RIFF????WEBP", "webp"},
+	{"II*\x00", "tiff"},
+	{"MM\x00*", "tiff"},
+	{"BM", "bmp"},
+	{"????ftypavif", "avif"},
+	{"????ftypavis", "avif"},
+}
+
+// isReadableFormat reports whether images of format can be read, which
+// besides the supported formats includes svg and avif.
+func isReadableFormat(format string) bool {
+	_, ok := registeredDecoder(format)
+	return ok
+}
+
+// sniffFormat returns the format of an image starting with header, or ""
+// when it is not recognized.
+func sniffFormat(header []byte) string {
+	for _, m := range magics {
+		if matchMagic(header, m.prefix) {
+			return m.format
+		}
+	}
+
+	trimmed := bytes.TrimSpace(header)
+	if bytes.HasPrefix(trimmed, []byte("<")) && bytes.Contains(header, []byte("<svg")) {
+		return "svg"
+	}
+
+	return ""
+}
+
+// matchMagic reports whether header starts with prefix, where '?' in
+// prefix matches any byte.
+func matchMagic(header []byte, prefix string) bool {
+	if len(header) < len(prefix) {
+		return false
+	}
+	for i := 0; i < len(prefix); i++ {
+		if prefix[i] != '?' && header[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// detectFormat returns the format of the image named name read from r. It
+// is the extension of name when that is a format that can be read,
+// otherwise the format recognized from the first bytes of r, so files with
+// a missing or wrong extension can still be read. It falls back to the
+// extension, which may be empty. The returned reader has to be read instead
+// of r.
+func detectFormat(name string, r io.Reader) (string, io.Reader) {
+	format := strings.TrimPrefix(filepath.Ext(name), ".")
+	if isReadableFormat(format) {
+		return format, r
+	}
+
+	br := bufio.NewReaderSize(r, sniffLen)
+	// a short file gives fewer bytes and an error, which Peek still returns
+	header, _ := br.Peek(sniffLen)
+	if sniffed := sniffFormat(header); sniffed != "" {
+		return sniffed, br
+	}
+
+	return format, br
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// StampOptions configures the repeated text of StampImage.
+type StampOptions struct {
+	// Font is the path of a TTF/OTF font, the built-in Go Regular font
+	// is used when empty.
+	Font  string
+	Size  float64
+	Color color.Color
+
+	// Angle rotates the text clockwise in degrees, negative values
+	// slope it upwards like most preview stamps.
+	Angle float64
+	// Opacity scales the alpha of the text, from 0 to 1.
+	Opacity float64
+	// Gap is the spacing in pixels between repetitions.
+	Gap int
+	// GapY is the spacing in pixels between rows of repetitions, Gap is
+	// used when it is negative.
+	GapY int
+	// DPI is the resolution Size is rendered at, 72 when zero.
+	DPI float64
+}
+
+// DefaultStampOptions returns the settings of a typical "PREVIEW" stamp:
+// translucent white text sloping upwards at 30 degrees.
+func DefaultStampOptions() StampOptions {
+	return StampOptions{
+		Size:    48,
+		Color:   color.White,
+		Angle:   -30,
+		Opacity: 0.3,
+		Gap:     48,
+		GapY:    -1,
+	}
+}
+
+// rowGap returns the spacing between rows of so.
+func (so StampOptions) rowGap() int {
+	if so.GapY < 0 {
+		return so.Gap
+	}
+	return so.GapY
+}
+
+// StampImage returns a copy of mainImg with text repeated diagonally across
+// the whole image, the way stock photo previews are marked.
+func StampImage(mainImg image.Image, text string, so StampOptions) (image.Image, error) {
+	if mainImg == nil {
+		return nil, ErrNilImage
+	}
+
+	tile, err := stampTile(text, so)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := mainImg.Bounds()
+	newImg := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(newImg, newImg.Bounds(), mainImg, bounds.Min, draw.Src)
+
+	err = tileWatermark(newImg, tile, so.Gap, so.rowGap(), newOptions(nil))
+	if err != nil {
+		return nil, err
+	}
+
+	return newImg, nil
+}
+
+// prepareStamp renders the stamp tile of o, or returns nil when no stamp
+// is set.
+func prepareStamp(o *options) (image.Image, error) {
+	if !o.stamp {
+		return nil, nil
+	}
+	return stampTile(o.stampText, o.stampOptions)
+}
+
+// stampTile renders one repetition of the stamp text, rotated and faded.
+func stampTile(text string, so StampOptions) (image.Image, error) {
+	if so.Opacity < 0 || so.Opacity > 1 {
+		return nil, fmt.Errorf("opacity %v must be between 0 and 1", so.Opacity)
+	}
+
+	col := so.Color
+	if col == nil {
+		col = color.White
+	}
+
+	tile, err := RenderTextWatermark(text, so.Size, col, so.Font, WithDPI(so.DPI))
+	if err != nil {
+		return nil, err
+	}
+
+	if so.Angle != 0 {
+		tile, err = RotateImage(tile, so.Angle)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if so.Opacity < 1 {
+		tile = ApplyOpacity(tile, so.Opacity)
+	}
+
+	return tile, nil
+}
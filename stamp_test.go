@@ -0,0 +1,228 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"strconv"
+	"testing"
+)
+
+// inkCentroid returns the mean y of the pixels of img in columns [x0, x1)
+// that are not transparent.
+func inkCentroid(img image.Image, x0, x1 int) float64 {
+	b := img.Bounds()
+	var sum, n float64
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := x0; x < x1; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0 {
+				sum += float64(y)
+				n++
+			}
+		}
+	}
+	return sum / n
+}
+
+func TestStampTileRotated(t *testing.T) {
+	so := DefaultStampOptions()
+	so.Opacity = 1
+
+	flat := so
+	flat.Angle = 0
+	text, err := stampTile("PREVIEW", flat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tile, err := stampTile("PREVIEW", so)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, h := rotatedSize(text.Bounds().Dx(), text.Bounds().Dy(), so.Angle)
+	if got, want := tile.Bounds().Size(), image.Pt(w, h); got != want {
+		t.Fatalf("tile size = %v, want the rotated text size %v", got, want)
+	}
+
+	// sloping upwards, the end of the text is higher than its start
+	mid := tile.Bounds().Min.X + tile.Bounds().Dx()/2
+	left, right := inkCentroid(tile, tile.Bounds().Min.X, mid), inkCentroid(tile, mid, tile.Bounds().Max.X)
+	if right >= left {
+		t.Errorf("text centered at y %.1f on the left and %.1f on the right, want higher on the right", left, right)
+	}
+}
+
+func TestStampImageRepeats(t *testing.T) {
+	black := color.NRGBA{0, 0, 0, 255}
+	main := image.NewNRGBA(image.Rect(0, 0, 600, 600))
+	draw.Draw(main, main.Rect, image.NewUniform(black), image.Point{}, draw.Src)
+
+	so := DefaultStampOptions()
+	out, err := StampImage(main, "PREVIEW", so)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Bounds() != main.Rect {
+		t.Fatalf("bounds = %v, want %v", out.Bounds(), main.Rect)
+	}
+
+	// every 200x200 cell holds some of the translucent white text, which
+	// over black is no brighter than 77, 30% of white
+	for cy := 0; cy < 3; cy++ {
+		for cx := 0; cx < 3; cx++ {
+			marked := false
+			for y := cy * 200; y < cy*200+200; y++ {
+				for x := cx * 200; x < cx*200+200; x++ {
+					c := color.NRGBAModel.Convert(out.At(x, y)).(color.NRGBA)
+					if c == black {
+						continue
+					}
+					marked = true
+					if c.R > 77 {
+						t.Fatalf("pixel (%d, %d) = %v, brighter than text at opacity %v", x, y, c, so.Opacity)
+					}
+				}
+			}
+			if !marked {
+				t.Errorf("cell (%d, %d) has no text", cx, cy)
+			}
+		}
+	}
+}
+
+// inkBounds returns the smallest rectangle holding the pixels of img that
+// are not transparent.
+func inkBounds(img image.Image) image.Rectangle {
+	var r image.Rectangle
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0 {
+				r = r.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	return r
+}
+
+// inkRuns returns the number of runs of consecutive columns of img, or
+// rows when rows is set, holding pixels that are not transparent.
+func inkRuns(img image.Image, rows bool) int {
+	b := img.Bounds()
+	outer, inner := b.Dx(), b.Dy()
+	if rows {
+		outer, inner = inner, outer
+	}
+
+	runs, inked := 0, false
+	for i := 0; i < outer; i++ {
+		ink := false
+		for j := 0; j < inner && !ink; j++ {
+			x, y := b.Min.X+i, b.Min.Y+j
+			if rows {
+				x, y = b.Min.X+j, b.Min.Y+i
+			}
+			_, _, _, a := img.At(x, y).RGBA()
+			ink = a != 0
+		}
+		if ink && !inked {
+			runs++
+		}
+		inked = ink
+	}
+	return runs
+}
+
+func TestStampAngle(t *testing.T) {
+	tests := []struct {
+		angle float64
+		// compares the height of the end of the text to that of its start
+		check func(left, right float64) bool
+	}{
+		{-30, func(left, right float64) bool { return right < left-10 }},
+		{30, func(left, right float64) bool { return right > left+10 }},
+		{0, func(left, right float64) bool { return right > left-2 && right < left+2 }},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.FormatFloat(tt.angle, 'f', -1, 64), func(t *testing.T) {
+			so := DefaultStampOptions()
+			so.Angle, so.Opacity = tt.angle, 1
+			// so wide a gap leaves the one repetition at the top-left
+			so.Gap = 1000
+
+			out, err := StampImage(image.NewNRGBA(image.Rect(0, 0, 400, 400)), "PREVIEW", so)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ink := inkBounds(out)
+			if ink.Empty() {
+				t.Fatal("no text was stamped")
+			}
+			mid := (ink.Min.X + ink.Max.X) / 2
+			left, right := inkCentroid(out, ink.Min.X, mid), inkCentroid(out, mid, ink.Max.X)
+			if !tt.check(left, right) {
+				t.Errorf("text centered at y %.1f on its left and %.1f on its right", left, right)
+			}
+		})
+	}
+}
+
+func TestStampSpacing(t *testing.T) {
+	// a single upright stroke, so every repetition is one run of columns
+	// and one of rows
+	so := DefaultStampOptions()
+	so.Angle, so.Opacity = 0, 1
+	tile, err := stampTile("I", so)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ink := inkBounds(tile)
+
+	// repetitions returns how many of the tiles stepping by step from 0
+	// show their ink within size
+	repetitions := func(size, step, inkStart int) int {
+		n := 0
+		for start := 0; start+inkStart < size; start += step {
+			n++
+		}
+		return n
+	}
+
+	tests := []struct {
+		name      string
+		gap, gapY int
+	}{
+		{"narrow", 10, -1},
+		{"wide", 60, -1},
+		{"rows apart", 10, 100},
+	}
+
+	cols, rows := map[string]int{}, map[string]int{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			so.Gap, so.GapY = tt.gap, tt.gapY
+			out, err := StampImage(image.NewNRGBA(image.Rect(0, 0, 400, 300)), "I", so)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cols[tt.name], rows[tt.name] = inkRuns(out, false), inkRuns(out, true)
+			if want := repetitions(400, tile.Bounds().Dx()+so.Gap, ink.Min.X); cols[tt.name] != want {
+				t.Errorf("%d repetitions across, want %d", cols[tt.name], want)
+			}
+			if want := repetitions(300, tile.Bounds().Dy()+so.rowGap(), ink.Min.Y); rows[tt.name] != want {
+				t.Errorf("%d repetitions down, want %d", rows[tt.name], want)
+			}
+		})
+	}
+
+	if cols["wide"] >= cols["narrow"] {
+		t.Errorf("a wider gap gives %d repetitions across, want fewer than %d", cols["wide"], cols["narrow"])
+	}
+	if rows["rows apart"] >= rows["narrow"] || cols["rows apart"] != cols["narrow"] {
+		t.Errorf("a wider row gap gives %dx%d repetitions, want fewer rows than the %dx%d of the narrow gap",
+			cols["rows apart"], rows["rows apart"], cols["narrow"], rows["narrow"])
+	}
+}
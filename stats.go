@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// runStats measures a run for -stats: how long it took, the largest image
+// read and how much memory was allocated meanwhile.
+type runStats struct {
+	start  time.Time
+	before runtime.MemStats
+
+	mu   sync.Mutex
+	peak image.Point
+}
+
+// startStats starts measuring a run.
+func startStats() *runStats {
+	s := &runStats{start: time.Now()}
+	runtime.ReadMemStats(&s.before)
+	return s
+}
+
+// withStats records the size of every image read on s.
+func withStats(s *runStats) Option {
+	return func(o *options) {
+		o.stats = s
+	}
+}
+
+// observe records an image of bounds r if it is the largest so far by
+// pixel count. Workers of a batch call it concurrently, and it does
+// nothing on a nil s.
+func (s *runStats) observe(r image.Rectangle) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.Dx()*r.Dy() > s.peak.X*s.peak.Y {
+		s.peak = r.Size()
+	}
+}
+
+// print writes the elapsed time, the largest image and the memory
+// allocated since startStats to w.
+func (s *runStats) print(w io.Writer) {
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+	allocated := after.TotalAlloc - s.before.TotalAlloc
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintf(w, "stats: elapsed %s, peak image %dx%d, allocated %.1f MiB\n",
+		time.Since(s.start).Round(time.Millisecond), s.peak.X, s.peak.Y, float64(allocated)/(1<<20))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"math"
+
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+)
+
+// svgImage is an SVG document rasterized at the size of its view box. It
+// keeps the parsed vector so ResizeImage can rasterize it again at the
+// requested size instead of scaling its pixels, keeping logos crisp.
+type svgImage struct {
+	*image.NRGBA
+	icon *oksvg.SvgIcon
+}
+
+// decodeSVG parses an SVG document and rasterizes it at its own size.
+func decodeSVG(r io.Reader) (image.Image, error) {
+	icon, err := oksvg.ReadIconStream(r)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := svgConfig(icon)
+	if err != nil {
+		return nil, err
+	}
+
+	return &svgImage{NRGBA: rasterizeSVG(icon, config.Width, config.Height), icon: icon}, nil
+}
+
+// decodeSVGConfig returns the size an SVG document is rasterized at.
+func decodeSVGConfig(r io.Reader) (image.Config, error) {
+	icon, err := oksvg.ReadIconStream(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	return svgConfig(icon)
+}
+
+// svgConfig returns the size of the view box of icon in whole pixels.
+func svgConfig(icon *oksvg.SvgIcon) (image.Config, error) {
+	width := int(math.Ceil(icon.ViewBox.W))
+	height := int(math.Ceil(icon.ViewBox.H))
+	if width <= 0 || height <= 0 {
+		return image.Config{}, errors.New("svg has no width, height or viewBox")
+	}
+
+	return image.Config{ColorModel: color.NRGBAModel, Width: width, Height: height}, nil
+}
+
+// rasterizeSVG draws icon stretched to width x height.
+func rasterizeSVG(icon *oksvg.SvgIcon, width, height int) *image.NRGBA {
+	// SetTarget changes the transform, so work on a copy to let watermarks
+	// shared between goroutines be rasterized concurrently
+	target := *icon
+	target.SetTarget(0, 0, float64(width), float64(height))
+
+	rgba := image.NewRGBA(image.Rect(0, 0, width, height))
+	scanner := rasterx.NewScannerGV(width, height, rgba, rgba.Bounds())
+	target.Draw(rasterx.NewDasher(width, height, scanner), 1)
+
+	return toNRGBA(rgba)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadImageSVG(t *testing.T) {
+	// a red left half and a blue right half on a transparent background,
+	// with a gap at the bottom
+	const doc = `<svg xmlns="http://www.w3.org/2000/svg" width="20" height="10" viewBox="0 0 20 10">
+	<rect x="0" y="0" width="10" height="8" fill="#ff0000"/>
+	<rect x="10" y="0" width="10" height="8" fill="#0000ff"/>
+</svg>`
+	path := filepath.Join(t.TempDir(), "logo.svg")
+	err := os.WriteFile(path, []byte(doc), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := ReadImage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := ReadImageConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Width != 20 || config.Height != 10 {
+		t.Errorf("config size = %dx%d, want 20x10", config.Width, config.Height)
+	}
+
+	// a vector scaled up is drawn again, so the edges stay sharp
+	large, err := ResizeImage(img, 80, 40, WithResample(Bilinear))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	red, blue := color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 0, 255, 255}
+	tests := []struct {
+		img  image.Image
+		at   image.Point
+		want color.NRGBA
+	}{
+		{img, image.Pt(0, 0), red},
+		{img, image.Pt(9, 7), red},
+		{img, image.Pt(10, 0), blue},
+		{img, image.Pt(19, 7), blue},
+		{img, image.Pt(5, 9), color.NRGBA{}},
+		{large, image.Pt(39, 31), red},
+		{large, image.Pt(40, 31), blue},
+		{large, image.Pt(40, 32), color.NRGBA{}},
+	}
+	for _, tt := range tests {
+		if got := color.NRGBAModel.Convert(tt.img.At(tt.at.X, tt.at.Y)); got != tt.want {
+			t.Errorf("%v pixel %v = %v, want %v", tt.img.Bounds().Size(), tt.at, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// RenderTextWatermark rasterizes text into an image just large enough to
+// hold it. Only the glyphs are drawn, the background is left transparent so
+// it blends like any other watermark. The font is loaded from fontPath, or
+// the built-in Go Regular font is used when fontPath is empty. WithStroke
+// outlines the glyphs, growing the image by the stroke width on each side.
+// WithBadge draws the text on a rounded box instead, for captions that stay
+// readable on busy photos. size is in points, which are pixels at the
+// default of 72 DPI and scale with WithDPI.
+func RenderTextWatermark(text string, size float64, col color.Color, fontPath string, opts ...Option) (image.Image, error) {
+	if text == "" {
+		return nil, errors.New("text is empty")
+	}
+
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid font size %v", size)
+	}
+
+	o := newOptions(opts)
+	if o.dpi < 0 {
+		return nil, fmt.Errorf("dpi %v must not be negative", o.dpi)
+	}
+	if o.strokeWidth < 0 {
+		return nil, fmt.Errorf("stroke width %d must not be negative", o.strokeWidth)
+	}
+	if o.badgePadding < 0 {
+		return nil, fmt.Errorf("badge padding %d must not be negative", o.badgePadding)
+	}
+	pad := 0
+	if o.badgeColor != nil {
+		pad = o.badgePadding
+	}
+	stroke := 0
+	if o.strokeColor != nil {
+		stroke = o.strokeWidth
+	}
+
+	face, err := loadFace(fontPath, size, o.dpi)
+	if err != nil {
+		return nil, err
+	}
+	defer face.Close()
+
+	metrics := face.Metrics()
+	ascent := metrics.Ascent.Ceil()
+	height := ascent + metrics.Descent.Ceil()
+	width := font.MeasureString(face, text).Ceil()
+
+	img := image.NewNRGBA(image.Rect(0, 0, width+2*(stroke+pad), height+2*(stroke+pad)))
+	if o.badgeColor != nil {
+		draw.Draw(img, img.Bounds(), image.NewUniform(o.badgeColor), image.Point{}, draw.Src)
+		roundCorners(img, pad, nil)
+	}
+
+	drawer := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(o.strokeColor),
+		Face: face,
+	}
+
+	// draw the outline as copies of the text shifted around a disc of the
+	// stroke width, then the fill on top of it
+	for dy := -stroke; dy <= stroke; dy++ {
+		for dx := -stroke; dx <= stroke; dx++ {
+			if dx*dx+dy*dy > stroke*stroke || (dx == 0 && dy == 0) {
+				continue
+			}
+			drawer.Dot = fixed.P(pad+stroke+dx, pad+ascent+stroke+dy)
+			drawer.DrawString(text)
+		}
+	}
+
+	drawer.Src = image.NewUniform(col)
+	drawer.Dot = fixed.P(pad+stroke, pad+ascent+stroke)
+	drawer.DrawString(text)
+
+	return img, nil
+}
+
+// loadFace parses the TrueType/OpenType font at fontPath and returns a face
+// of the given point size at dpi, or at 72 DPI when dpi is zero.
+func loadFace(fontPath string, size, dpi float64) (font.Face, error) {
+	if dpi == 0 {
+		dpi = 72
+	}
+
+	data := goregular.TTF
+	if fontPath != "" {
+		var err error
+		data, err = os.ReadFile(fontPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := opentype.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fontPath, err)
+	}
+
+	return opentype.NewFace(f, &opentype.FaceOptions{
+		Size:    size,
+		DPI:     dpi,
+		Hinting: font.HintingFull,
+	})
+}
+
+// ParseColor parses a hex color of the form #RRGGBB or #RRGGBBAA, with or
+// without the leading '#'.
+func ParseColor(s string) (color.Color, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) != 6 && len(hex) != 8 {
+		return nil, fmt.Errorf("invalid color %q, expected #RRGGBB or #RRGGBBAA", s)
+	}
+
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid color %q, expected #RRGGBB or #RRGGBBAA", s)
+	}
+
+	if len(hex) == 6 {
+		v = v<<8 | 0xff
+	}
+
+	return color.NRGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
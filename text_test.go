@@ -0,0 +1,161 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestRenderTextStroke(t *testing.T) {
+	white := color.NRGBA{255, 255, 255, 255}
+	black := color.NRGBA{0, 0, 0, 255}
+
+	plain, err := RenderTextWatermark("A", 40, white, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stroked, err := RenderTextWatermark("A", 40, white, "", WithStroke(black, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := stroked.Bounds().Size(), plain.Bounds().Size().Add(image.Pt(4, 4)); got != want {
+		t.Fatalf("stroked size = %v, want %v, 2px larger on each side", got, want)
+	}
+
+	at := func(x, y int) color.NRGBA {
+		return color.NRGBAModel.Convert(stroked.At(x, y)).(color.NRGBA)
+	}
+
+	// the outline lies right next to the fill, and every fill pixel at
+	// the edge of the glyph is ringed by it rather than by transparency
+	b := stroked.Bounds()
+	outline := 0
+	for y := b.Min.Y + 1; y < b.Max.Y-1; y++ {
+		for x := b.Min.X + 1; x < b.Max.X-1; x++ {
+			if at(x, y) != white {
+				continue
+			}
+			for _, d := range []image.Point{{1, 0}, {-1, 0}, {0, 1}, {0, -1}} {
+				switch n := at(x+d.X, y+d.Y); {
+				case n == black:
+					outline++
+				case n.A == 0:
+					t.Fatalf("fill pixel (%d, %d) borders transparency at %v", x, y, image.Pt(x+d.X, y+d.Y))
+				}
+			}
+		}
+	}
+	if outline == 0 {
+		t.Error("no outline pixels next to the fill")
+	}
+
+	pb := plain.Bounds()
+	for y := pb.Min.Y; y < pb.Max.Y; y++ {
+		for x := pb.Min.X; x < pb.Max.X; x++ {
+			if c := color.NRGBAModel.Convert(plain.At(x, y)).(color.NRGBA); c.A != 0 && c.R != 255 {
+				t.Fatalf("text without a stroke has a dark pixel %v at (%d, %d)", c, x, y)
+			}
+		}
+	}
+}
+
+func TestRenderTextBadge(t *testing.T) {
+	white := color.NRGBA{255, 255, 255, 255}
+	badge := color.NRGBA{0, 0, 0, 128}
+
+	plain, err := RenderTextWatermark("A", 40, white, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	boxed, err := RenderTextWatermark("A", 40, white, "", WithBadge(badge, 8))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := boxed.Bounds().Size(), plain.Bounds().Size().Add(image.Pt(16, 16)); got != want {
+		t.Fatalf("badge size = %v, want %v, 8px larger on each side", got, want)
+	}
+
+	// behind and around the glyph, where the plain text is transparent,
+	// the badge shows, and the glyph is drawn over it
+	b := plain.Bounds()
+	glyph := 0
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			p := color.NRGBAModel.Convert(plain.At(x, y)).(color.NRGBA)
+			got := color.NRGBAModel.Convert(boxed.At(x+8, y+8)).(color.NRGBA)
+			switch {
+			case p.A == 0 && got != badge:
+				t.Fatalf("pixel (%d, %d) off the glyph = %v, want the badge %v", x+8, y+8, got, badge)
+			case p == white:
+				glyph++
+				if got != white {
+					t.Fatalf("glyph pixel (%d, %d) = %v, want %v", x+8, y+8, got, white)
+				}
+			}
+		}
+	}
+	if glyph == 0 {
+		t.Fatal("the text has no fully drawn pixels")
+	}
+
+	// the padding is badge too, apart from the rounded corners
+	at := func(x, y int) color.NRGBA {
+		return color.NRGBAModel.Convert(boxed.At(x, y)).(color.NRGBA)
+	}
+	bb := boxed.Bounds()
+	if got := at(bb.Dx()/2, 1); got != badge {
+		t.Errorf("padding pixel = %v, want %v", got, badge)
+	}
+	if got := at(0, 0); got.A != 0 {
+		t.Errorf("corner pixel = %v, want transparent", got)
+	}
+
+	if _, err := RenderTextWatermark("A", 40, white, "", WithBadge(badge, -1)); err == nil {
+		t.Error("a negative badge padding was accepted")
+	}
+}
+
+func TestRenderTextDPI(t *testing.T) {
+	// inkHeight returns the number of rows of img holding any of the glyph
+	inkHeight := func(img image.Image) int {
+		rows := 0
+		b := img.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				if _, _, _, a := img.At(x, y).RGBA(); a > 0x8000 {
+					rows++
+					break
+				}
+			}
+		}
+		return rows
+	}
+
+	white := color.NRGBA{255, 255, 255, 255}
+	heights := map[float64]int{}
+	for _, dpi := range []float64{0, 72, 144, 300} {
+		img, err := RenderTextWatermark("H", 20, white, "", WithDPI(dpi))
+		if err != nil {
+			t.Fatal(err)
+		}
+		heights[dpi] = inkHeight(img)
+	}
+
+	if heights[0] != heights[72] {
+		t.Errorf("glyph is %d rows at the default DPI, want the %d of 72 DPI", heights[0], heights[72])
+	}
+	// H is flat at the top and bottom, so it scales to within the row
+	// rounded off at 72 DPI, scaled too
+	for _, dpi := range []float64{144, 300} {
+		want, tolerance := float64(heights[72])*dpi/72, dpi/72
+		if got := float64(heights[dpi]); got < want-tolerance || got > want+tolerance {
+			t.Errorf("glyph is %v rows at %v DPI, want %v, %v times the %d of 72 DPI", got, dpi, want, dpi/72, heights[72])
+		}
+	}
+
+	if _, err := RenderTextWatermark("H", 20, white, "", WithDPI(-1)); err == nil {
+		t.Error("a negative DPI was accepted")
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// selectTIFFPage returns the TIFF read from r pointed at the page of
+// WithPage.
+func selectTIFFPage(r io.Reader, o *options) (io.Reader, error) {
+	if o.page < 0 {
+		return nil, fmt.Errorf("page %d must not be negative", o.page)
+	}
+	if o.page == 0 {
+		return r, nil
+	}
+	return tiffPage(r, o.page)
+}
+
+// tiffPage returns a TIFF stream that decodes to page n, counted from 0,
+// of the multi-page TIFF read from r. The tiff package only decodes the
+// first image file directory, so the whole file is read and the header is
+// pointed at the directory of page n, whose offsets stay valid since they
+// are relative to the start of the file.
+func tiffPage(r io.Reader, n int) (io.Reader, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 8 {
+		return nil, errors.New("tiff: header is truncated")
+	}
+
+	var order binary.ByteOrder
+	switch string(data[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return nil, errors.New("tiff: invalid header")
+	}
+
+	offset := order.Uint32(data[4:8])
+	for page := 0; page < n; page++ {
+		if offset == 0 {
+			return nil, fmt.Errorf("page %d not found, the tiff has %d pages", n, page)
+		}
+		if int64(offset)+2 > int64(len(data)) {
+			return nil, errors.New("tiff: image file directory is truncated")
+		}
+
+		entries := int64(order.Uint16(data[offset:]))
+		next := int64(offset) + 2 + entries*12
+		if next+4 > int64(len(data)) {
+			return nil, errors.New("tiff: image file directory is truncated")
+		}
+		offset = order.Uint32(data[next:])
+	}
+	if offset == 0 {
+		return nil, fmt.Errorf("page %d not found, the tiff has %d pages", n, n)
+	}
+
+	patched := append([]byte(nil), data[:8]...)
+	order.PutUint32(patched[4:], offset)
+	return io.MultiReader(bytes.NewReader(patched), bytes.NewReader(data[8:])), nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// tiffPages returns an uncompressed little-endian TIFF with a gray page of
+// each size in sizes, page i filled with the gray level levels[i].
+func tiffPages(sizes []image.Point, levels []uint8) []byte {
+	le := binary.LittleEndian
+	data := []byte("II*\x00\x08\x00\x00\x00")
+
+	for i, size := range sizes {
+		const entries = 8
+		pixels := len(data) + 2 + entries*12 + 4
+		next := pixels + size.X*size.Y
+		if i == len(sizes)-1 {
+			next = 0
+		}
+
+		data = le.AppendUint16(data, entries)
+		data = appendIFDEntry(data, 256, 3, 1, uint32(size.X))
+		data = appendIFDEntry(data, 257, 3, 1, uint32(size.Y))
+		data = appendIFDEntry(data, 258, 3, 1, 8)
+		data = appendIFDEntry(data, 259, 3, 1, 1)
+		data = appendIFDEntry(data, 262, 3, 1, 1)
+		data = appendIFDEntry(data, 273, 4, 1, uint32(pixels))
+		data = appendIFDEntry(data, 278, 3, 1, uint32(size.Y))
+		data = appendIFDEntry(data, 279, 4, 1, uint32(size.X*size.Y))
+		data = le.AppendUint32(data, uint32(next))
+		for j := 0; j < size.X*size.Y; j++ {
+			data = append(data, levels[i])
+		}
+	}
+	return data
+}
+
+func TestReadImagePage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scan.tiff")
+	data := tiffPages([]image.Point{{4, 4}, {6, 3}}, []uint8{50, 200})
+	err := os.WriteFile(path, data, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		page int
+		size image.Point
+		gray uint8
+	}{
+		{0, image.Pt(4, 4), 50},
+		{1, image.Pt(6, 3), 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.page), func(t *testing.T) {
+			img, err := ReadImage(path, WithPage(tt.page))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := img.Bounds().Size(); got != tt.size {
+				t.Errorf("size = %v, want %v", got, tt.size)
+			}
+			if got := color.GrayModel.Convert(img.At(1, 1)).(color.Gray).Y; got != tt.gray {
+				t.Errorf("gray level = %d, want %d", got, tt.gray)
+			}
+
+			config, err := ReadImageConfig(path, WithPage(tt.page))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.Width != tt.size.X || config.Height != tt.size.Y {
+				t.Errorf("ReadImageConfig = %dx%d, want %v", config.Width, config.Height, tt.size)
+			}
+		})
+	}
+
+	_, err = ReadImage(path, WithPage(2))
+	if err == nil || !strings.Contains(err.Error(), "page 2 not found") {
+		t.Errorf("ReadImage of page 2 = %v, want page 2 not found", err)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+)
+
+// TileWatermark repeats the watermark over the whole of dst starting at the
+// top-left corner, leaving gap pixels between tiles. Tiles that run past
+// the right or bottom edge are clipped.
+func TileWatermark(dst *image.NRGBA, waterMarkImg image.Image, gap int) error {
+	return tileWatermark(dst, waterMarkImg, gap, gap, newOptions(nil))
+}
+
+// tileWatermark is TileWatermark blending as o describes, with gapX pixels
+// between tiles across and gapY pixels between rows.
+func tileWatermark(dst draw.Image, waterMarkImg image.Image, gapX, gapY int, o *options) error {
+	if gapX < 0 || gapY < 0 {
+		return errors.New("gap must not be negative")
+	}
+
+	stepX := waterMarkImg.Bounds().Dx() + gapX
+	stepY := waterMarkImg.Bounds().Dy() + gapY
+	if stepX <= 0 || stepY <= 0 {
+		return errors.New("watermark is empty")
+	}
+
+	return tileImage(dst, waterMarkImg, stepX, stepY, image.Point{}, o)
+}
+
+// stripImage blends img onto dst at (x, y) and repeats it every stepX
+// pixels along that row and every stepY pixels along that column, in both
+// directions up to the edges of dst. A step of zero or less leaves its
+// axis with the single copy at (x, y).
+func stripImage(dst draw.Image, img image.Image, x, y, stepX, stepY int, o *options) error {
+	bounds := dst.Bounds()
+
+	if stepX <= 0 && stepY <= 0 {
+		return blendWatermark(dst, img, x, y, o)
+	}
+
+	if stepX > 0 {
+		for sx := stripStart(x, bounds.Min.X, stepX); sx < bounds.Max.X; sx += stepX {
+			err := blendWatermark(dst, img, sx, y, o)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if stepY > 0 {
+		for sy := stripStart(y, bounds.Min.Y, stepY); sy < bounds.Max.Y; sy += stepY {
+			if stepX > 0 && sy == y {
+				// already blended as part of the row
+				continue
+			}
+			err := blendWatermark(dst, img, x, sy, o)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// stripStart returns the first position, at or before min, of a strip
+// repeated every step pixels through v.
+func stripStart(v, min, step int) int {
+	start := min + (v-min)%step
+	if start > min {
+		start -= step
+	}
+	return start
+}
+
+// tileImage blends img onto dst every stepX pixels across and stepY pixels
+// down, starting at off from the top-left corner of dst. With WithStagger
+// every other row is shifted by half a step, like courses of bricks, and
+// starts with a partial tile at the left edge.
+func tileImage(dst draw.Image, img image.Image, stepX, stepY int, off image.Point, o *options) error {
+	if stepX <= 0 || stepY <= 0 {
+		return nil
+	}
+
+	bounds := dst.Bounds()
+	for row, y := 0, bounds.Min.Y+off.Y; y < bounds.Max.Y; row, y = row+1, y+stepY {
+		startX := bounds.Min.X + off.X
+		if o.stagger && row%2 == 1 {
+			startX -= stepX / 2
+		}
+
+		for x := startX; x < bounds.Max.X; x += stepX {
+			err := blendWatermark(dst, img, x, y, o)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"reflect"
+	"testing"
+)
+
+func TestTileWatermark(t *testing.T) {
+	red := color.NRGBA{255, 0, 0, 255}
+	green := color.NRGBA{0, 255, 0, 255}
+	blue := color.NRGBA{0, 0, 255, 255}
+
+	// a red tile marked green at its top-left corner, so every tile placed
+	// leaves one green pixel, even when clipped
+	wm := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	draw.Draw(wm, wm.Rect, image.NewUniform(red), image.Point{}, draw.Src)
+	wm.SetNRGBA(0, 0, green)
+
+	tests := []struct {
+		name   string
+		gap    int
+		tiles  int
+		covers func(x, y int) bool
+	}{
+		{"no gap", 0, 36, func(x, y int) bool { return true }},
+		{"gap", 5, 16, func(x, y int) bool { return x%15 < 10 && y%15 < 10 }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := image.NewNRGBA(image.Rect(0, 0, 55, 55))
+			draw.Draw(dst, dst.Rect, image.NewUniform(blue), image.Point{}, draw.Src)
+
+			err := TileWatermark(dst, wm, tt.gap)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var tiles int
+			for y := 0; y < 55; y++ {
+				for x := 0; x < 55; x++ {
+					got := dst.NRGBAAt(x, y)
+					if got == green {
+						tiles++
+					}
+					if covered := got != blue; covered != tt.covers(x, y) {
+						t.Fatalf("pixel (%d, %d) = %v, covered %v, want %v", x, y, got, covered, tt.covers(x, y))
+					}
+				}
+			}
+			if tiles != tt.tiles {
+				t.Errorf("%d tiles blended, want %d", tiles, tt.tiles)
+			}
+		})
+	}
+}
+
+func TestTileWatermarkStagger(t *testing.T) {
+	blue := color.NRGBA{0, 0, 255, 255}
+	green := color.NRGBA{0, 255, 0, 255}
+	wm := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	draw.Draw(wm, wm.Rect, image.NewUniform(color.NRGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+	wm.SetNRGBA(0, 0, green)
+
+	dst := image.NewNRGBA(image.Rect(0, 0, 50, 40))
+	draw.Draw(dst, dst.Rect, image.NewUniform(blue), image.Point{}, draw.Src)
+	err := tileWatermark(dst, wm, 0, 0, newOptions([]Option{WithStagger()}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the top-left corners of the tiles of each row
+	starts := map[int][]int{}
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 50; x++ {
+			if dst.NRGBAAt(x, y) == green {
+				starts[y] = append(starts[y], x)
+			}
+		}
+	}
+	even, odd := []int{0, 10, 20, 30, 40}, []int{5, 15, 25, 35, 45}
+	want := map[int][]int{0: even, 10: odd, 20: even, 30: odd}
+	if !reflect.DeepEqual(starts, want) {
+		t.Errorf("tiles start at %v, want %v", starts, want)
+	}
+
+	// odd rows begin with the right half of a tile, leaving no gap
+	for y := 10; y < 20; y++ {
+		if got := dst.NRGBAAt(0, y); got == blue {
+			t.Fatalf("pixel (0, %d) of an odd row is not covered", y)
+		}
+	}
+}
+
+func TestStripWatermark(t *testing.T) {
+	blue := color.NRGBA{0, 0, 255, 255}
+	green := color.NRGBA{0, 255, 0, 255}
+	wm := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	draw.Draw(wm, wm.Rect, image.NewUniform(color.NRGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+	wm.SetNRGBA(0, 0, green)
+
+	// the watermark at (20, 30) of a 100x60 image, 5 pixels apart
+	tests := []struct {
+		name   string
+		opt    Option
+		starts []image.Point
+		covers func(x, y int) bool
+	}{
+		{
+			"row", WithStripRow(5),
+			[]image.Point{{5, 30}, {20, 30}, {35, 30}, {50, 30}, {65, 30}, {80, 30}, {95, 30}},
+			func(x, y int) bool { return y >= 30 && y < 40 && (x+10)%15 < 10 },
+		},
+		{
+			"column", WithStripColumn(5),
+			[]image.Point{{20, 0}, {20, 15}, {20, 30}, {20, 45}},
+			func(x, y int) bool { return x >= 20 && x < 30 && y%15 < 10 },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			main := image.NewNRGBA(image.Rect(0, 0, 100, 60))
+			draw.Draw(main, main.Rect, image.NewUniform(blue), image.Point{}, draw.Src)
+			out, err := WatermarkImage(main, []WatermarkSpec{{Image: wm, X: 20, Y: 30}}, tt.opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var starts []image.Point
+			for y := 0; y < 60; y++ {
+				for x := 0; x < 100; x++ {
+					got := color.NRGBAModel.Convert(out.At(x, y))
+					if got == green {
+						starts = append(starts, image.Pt(x, y))
+					}
+					if covered := got != blue; covered != tt.covers(x, y) {
+						t.Fatalf("pixel (%d, %d) = %v, covered %v, want %v", x, y, got, covered, tt.covers(x, y))
+					}
+				}
+			}
+			if !reflect.DeepEqual(starts, tt.starts) {
+				t.Errorf("watermarks start at %v, want %v", starts, tt.starts)
+			}
+		})
+	}
+}
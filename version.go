@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strings"
+)
+
+// versionInfo returns the module version of the binary, the Go version it
+// was built with and the revision of the source it was built from, when
+// recorded.
+func versionInfo() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown version, built without module support"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s\n", info.Main.Path, info.Main.Version)
+	fmt.Fprintf(&b, "built with %s", info.GoVersion)
+
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			fmt.Fprintf(&b, "\nrevision %s", setting.Value)
+		case "vcs.time":
+			fmt.Fprintf(&b, "\ncommitted %s", setting.Value)
+		case "vcs.modified":
+			if setting.Value == "true" {
+				b.WriteString("\nwith uncommitted changes")
+			}
+		}
+	}
+
+	return b.String()
+}
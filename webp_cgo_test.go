@@ -0,0 +1,39 @@
+//go:build cgo
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"path/filepath"
+	"testing"
+)
+
+func TestWebPRoundTrip(t *testing.T) {
+	// blocks of flat color, which lossy WebP keeps close to the original
+	// apart from the shift of storing them as YUV
+	img := image.NewNRGBA(image.Rect(0, 0, 32, 16))
+	draw.Draw(img, image.Rect(0, 0, 16, 16), image.NewUniform(color.NRGBA{200, 30, 40, 255}), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(16, 0, 32, 16), image.NewUniform(color.NRGBA{20, 90, 220, 255}), image.Point{}, draw.Src)
+
+	path := filepath.Join(t.TempDir(), "round.webp")
+	err := SaveImage(img, path, WithQuality(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadImage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Bounds() != img.Rect {
+		t.Fatalf("bounds = %v, want %v", got.Bounds(), img.Rect)
+	}
+	for _, at := range []image.Point{{4, 4}, {12, 10}, {20, 4}, {28, 12}} {
+		g := color.NRGBAModel.Convert(got.At(at.X, at.Y)).(color.NRGBA)
+		if w := img.NRGBAAt(at.X, at.Y); !closeNRGBA(g, w, 16) {
+			t.Errorf("pixel %v = %v, want about %v", at, g, w)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !cgo
+
+package main
+
+import (
+	"errors"
+	"image"
+	"io"
+)
+
+// encodeWebP is unavailable without cgo because the encoder wraps libwebp.
+func encodeWebP(w io.Writer, img image.Image, quality int) error {
+	return errors.New("webp encoding requires a build with cgo enabled")
+}
@@ -0,0 +1,17 @@
+//go:build !cgo
+
+package main
+
+import (
+	"image"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestWebPEncodeWithoutCgo(t *testing.T) {
+	err := WriteImageTo(io.Discard, image.NewNRGBA(image.Rect(0, 0, 4, 4)), "webp")
+	if err == nil || !strings.Contains(err.Error(), "cgo") {
+		t.Errorf("WriteImageTo webp = %v, want an error naming cgo", err)
+	}
+}